@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/rancher-sandbox/docker-machine-driver-hyperkit/pkg/hyperkit"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(ninePServerCmd)
+}
+
+var ninePServerCmd = &cobra.Command{
+	Use:    "9p-server SOCKET DIR [SOCKET DIR]...",
+	Short:  "Serve directories over 9P for the virtio-9p devices of a machine.",
+	Long:   `Serve each directory over 9P on a unix socket until interrupted. It is started by the driver for --share-type 9p.`,
+	Hidden: true,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) == 0 || len(args)%2 != 0 {
+			return fmt.Errorf("expected pairs of SOCKET DIR arguments")
+		}
+		return nil
+	},
+	RunE: ninePServerCommand,
+}
+
+func ninePServerCommand(cmd *cobra.Command, args []string) error {
+	errs := make(chan error, len(args)/2)
+	for i := 0; i < len(args); i += 2 {
+		socket, dir := args[i], args[i+1]
+		// A socket left behind by a crashed server would make the listen fail
+		_ = os.Remove(socket)
+		l, err := net.Listen("unix", socket)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(socket)
+		defer l.Close()
+		go func() {
+			errs <- hyperkit.Serve9P(l, dir)
+		}()
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	select {
+	case <-signals:
+		return nil
+	case err := <-errs:
+		return err
+	}
+}
@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/rancher-sandbox/docker-machine-driver-hyperkit/pkg/hyperkit"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(cacheCmd)
+	cacheCmd.AddCommand(cacheListCmd)
+	cacheCmd.AddCommand(cachePruneCmd)
+}
+
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Manage the ISO and boot file cache.",
+	Long: `Manage the ISO and boot file cache. ISO images and the kernel and initrd extracted
+from them are stored once per SHA-256 digest and linked into the machine directories.`,
+}
+
+var cacheListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the cached ISO images.",
+	Long:  `List the cached ISO images and the machines using them.`,
+	Args:  cobra.NoArgs,
+	RunE:  cacheListCommand,
+}
+
+var cachePruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Remove cached ISO images that are not used by any machine.",
+	Long: `Remove cached ISO images that are not used by any machine. Machines keep their
+own links to the cached files, so this only loses the sharing with future machines.`,
+	Args: cobra.NoArgs,
+	RunE: cachePruneCommand,
+}
+
+func cacheListCommand(cmd *cobra.Command, args []string) error {
+	users, err := isoDigestUsers()
+	if err != nil {
+		return err
+	}
+	entries, err := hyperkit.NewBootCache(storagePath).List()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "DIGEST\tSIZE\tMODIFIED\tMACHINES")
+	for _, entry := range entries {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", entry.Digest, units.HumanSize(float64(entry.Size)),
+			entry.Modified.Format(time.RFC3339), strings.Join(users[entry.Digest], ","))
+	}
+	return w.Flush()
+}
+
+func cachePruneCommand(cmd *cobra.Command, args []string) error {
+	users, err := isoDigestUsers()
+	if err != nil {
+		return err
+	}
+	inUse := make(map[string]bool)
+	for digest := range users {
+		inUse[digest] = true
+	}
+	pruned, err := hyperkit.NewBootCache(storagePath).Prune(inUse)
+	for _, entry := range pruned {
+		fmt.Printf("Removed %s (%s)\n", entry.Digest, units.HumanSize(float64(entry.Size)))
+	}
+	return err
+}
+
+// isoDigestUsers returns the names of the machines using each cached ISO digest
+func isoDigestUsers() (map[string][]string, error) {
+	drivers, err := hyperkitDrivers()
+	if err != nil {
+		return nil, err
+	}
+	users := make(map[string][]string)
+	for name, driver := range drivers {
+		if driver.ISOSHA256 != "" {
+			users[driver.ISOSHA256] = append(users[driver.ISOSHA256], name)
+		}
+	}
+	for _, names := range users {
+		sort.Strings(names)
+	}
+	return users, nil
+}
+
+// hyperkitDrivers returns the drivers of all hyperkit machines in the storage path, by machine name
+func hyperkitDrivers() (map[string]*hyperkit.Driver, error) {
+	api := newAPI()
+	defer api.Close()
+
+	names, err := api.List()
+	if err != nil {
+		return nil, err
+	}
+	drivers := make(map[string]*hyperkit.Driver)
+	for _, name := range names {
+		host, err := api.Load(name)
+		if err != nil {
+			return nil, fmt.Errorf("error loading config for host %s: %v", name, err)
+		}
+		if host.DriverName != "hyperkit" {
+			continue
+		}
+		driver, err := loadDriver(host)
+		if err != nil {
+			return nil, err
+		}
+		drivers[name] = driver
+	}
+	return drivers, nil
+}
@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+var cloneFrom string
+
+func init() {
+	rootCmd.AddCommand(cloneCmd)
+	cloneCmd.Flags().StringVar(&cloneFrom, "from", "", "Name of the machine to clone")
+	_ = cloneCmd.MarkFlagRequired("from")
+}
+
+var cloneCmd = &cobra.Command{
+	Use:   "clone",
+	Short: "Create a new machine as a copy of an existing one.",
+	Long: `Create a new machine as a copy of an existing, stopped machine. The disks and boot files
+are copied, but the new machine gets its own UUID, MAC address, SSH key and docker TLS certificates.`,
+	RunE: cloneCommand,
+}
+
+func cloneCommand(cmd *cobra.Command, args []string) error {
+	api := newAPI()
+	defer api.Close()
+
+	exists, err := api.Exists(machineName)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("host %s already exists", machineName)
+	}
+
+	srcHost, err := api.Load(cloneFrom)
+	if err != nil {
+		return err
+	}
+	src, err := loadDriver(srcHost)
+	if err != nil {
+		return err
+	}
+
+	driver := src.NewClone(machineName)
+	if err := driver.CopyMachineFiles(src); err != nil {
+		_ = api.Remove(machineName)
+		return fmt.Errorf("error copying files of host %s: %v", srcHost.Name, err)
+	}
+
+	data, err := json.Marshal(driver)
+	if err != nil {
+		return err
+	}
+	host, err := api.NewHost("hyperkit", data)
+	if err != nil {
+		return err
+	}
+	host.HostOptions.EngineOptions = srcHost.HostOptions.EngineOptions
+	host.HostOptions.AuthOptions.StorePath = storagePath
+	// Drive the new machine directly instead of through the plugin, so the changes
+	// to the SSH key path end up in the saved configuration.
+	host.Driver = driver
+	if err := api.Save(host); err != nil {
+		return err
+	}
+
+	fmt.Println("Starting cloned machine now...")
+	if err := driver.Start(); err != nil {
+		return err
+	}
+	if err := driver.ReplaceSSHKey(); err != nil {
+		return err
+	}
+	if err := api.Save(host); err != nil {
+		return err
+	}
+
+	// Provisioning the certificates also sets the hostname to the new machine name
+	fmt.Println("Generating docker TLS certificates...")
+	if err := host.ConfigureAuth(); err != nil {
+		return err
+	}
+	return api.Save(host)
+}
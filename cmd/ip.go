@@ -6,15 +6,21 @@ import (
 	"github.com/spf13/cobra"
 )
 
+var ipAll bool
+
 func init() {
 	rootCmd.AddCommand(ipCmd)
+	ipCmd.Flags().BoolVar(&ipAll, "all", false, "List all network interfaces as IP, network and MAC address")
 }
 
 var ipCmd = &cobra.Command{
 	Use:   "ip",
 	Short: "Display the IP address of the machine.",
-	Long:  `Display the IP address of the machine.`,
-	RunE:  ipCommand,
+	Long: `Display the IP address of the machine.
+
+With --all every network interface is listed, starting with the primary one. Interfaces
+that don't have an address (yet) are listed with "-".`,
+	RunE: ipCommand,
 }
 
 func ipCommand(cmd *cobra.Command, args []string) error {
@@ -26,6 +32,28 @@ func ipCommand(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
+	if ipAll {
+		driver, err := loadDriver(host)
+		if err != nil {
+			return err
+		}
+		interfaces, err := driver.Interfaces()
+		if err != nil {
+			return err
+		}
+		for _, iface := range interfaces {
+			ip, mac := iface.IPAddress, iface.MACAddress
+			if ip == "" {
+				ip = "-"
+			}
+			if mac == "" {
+				mac = "-"
+			}
+			fmt.Printf("%s\t%s\t%s\n", ip, iface.Network, mac)
+		}
+		return nil
+	}
+
 	ip, err := host.Driver.GetIP()
 	if err == nil {
 		fmt.Println(ip)
@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/rancher-sandbox/docker-machine-driver-hyperkit/pkg/hyperkit"
+	"github.com/spf13/cobra"
+)
+
+var leasesDryRun bool
+
+func init() {
+	rootCmd.AddCommand(leasesCmd)
+	leasesCmd.AddCommand(leasesPruneCmd)
+	leasesPruneCmd.Flags().BoolVar(&leasesDryRun, "dry-run", false, "Only list the leases that would be removed")
+}
+
+var leasesCmd = &cobra.Command{
+	Use:   "leases",
+	Short: "Manage the vmnet DHCP leases.",
+	Long:  `Manage the DHCP leases that bootpd hands out to vmnet machines, stored in ` + hyperkit.LeasesPath + `.`,
+}
+
+var leasesPruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Remove DHCP leases of machines that no longer exist.",
+	Long: `Remove all DHCP leases whose MAC address doesn't belong to a machine in the storage path.
+Note that this includes the leases of VMs managed by other tools using vmnet.`,
+	Args: cobra.NoArgs,
+	RunE: leasesPruneCommand,
+}
+
+func leasesPruneCommand(cmd *cobra.Command, args []string) error {
+	drivers, err := hyperkitDrivers()
+	if err != nil {
+		return err
+	}
+	var owned []string
+	for name, driver := range drivers {
+		macs, err := driver.MACAddresses()
+		if err != nil {
+			return fmt.Errorf("error getting MAC addresses of host %s: %v", name, err)
+		}
+		owned = append(owned, macs...)
+	}
+	pruned, err := hyperkit.PruneDHCPLeases(owned, leasesDryRun)
+	if err != nil {
+		return err
+	}
+	verb := "Removed"
+	if leasesDryRun {
+		verb = "Would remove"
+	}
+	for _, lease := range pruned {
+		fmt.Printf("%s %s (%s, %s)\n", verb, lease.IPAddress, lease.HWAddress, lease.Name)
+	}
+	return nil
+}
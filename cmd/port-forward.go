@@ -0,0 +1,163 @@
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+
+	"github.com/docker/machine/libmachine/host"
+	"github.com/docker/machine/libmachine/ssh"
+	"github.com/docker/machine/libmachine/state"
+	"github.com/rancher-sandbox/docker-machine-driver-hyperkit/pkg/hyperkit"
+	"github.com/spf13/cobra"
+	cryptossh "golang.org/x/crypto/ssh"
+)
+
+var (
+	guestIP      string
+	guestSSHPort int
+)
+
+func init() {
+	rootCmd.AddCommand(portForwardCmd)
+	// The driver passes the IP address and SSH port when it starts the forwarding for --publish,
+	// because the machine config hasn't been updated with the current values at that time.
+	portForwardCmd.Flags().StringVar(&guestIP, "guest-ip", "", "IP address of the machine")
+	portForwardCmd.Flags().IntVar(&guestSSHPort, "ssh-port", 0, "SSH port of the machine")
+	_ = portForwardCmd.Flags().MarkHidden("guest-ip")
+	_ = portForwardCmd.Flags().MarkHidden("ssh-port")
+}
+
+var portForwardCmd = &cobra.Command{
+	Use:   "port-forward HOSTPORT:GUESTPORT[/vsock]...",
+	Short: "Forward localhost ports to the machine.",
+	Long: `Forward localhost TCP ports to ports of the machine until interrupted. Connections are
+tunneled over SSH, or connect to a vsock port of the machine when the forward has a /vsock suffix.
+Use the --publish option of start to forward ports for as long as the machine is running.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: portForwardCommand,
+}
+
+func portForwardCommand(cmd *cobra.Command, args []string) error {
+	forwards, err := hyperkit.ParsePortForwards(args)
+	if err != nil {
+		return err
+	}
+
+	api := newAPI()
+	defer api.Close()
+
+	h, err := api.Load(machineName)
+	if err != nil {
+		return err
+	}
+	driver, err := loadDriver(h)
+	if err != nil {
+		return err
+	}
+	if guestIP != "" {
+		driver.IPAddress = guestIP
+		if guestSSHPort != 0 {
+			driver.SSHPort = guestSSHPort
+		}
+	} else {
+		currentState, err := driver.GetState()
+		if err != nil {
+			return err
+		}
+		if currentState != state.Running {
+			return fmt.Errorf("cannot forward ports: Host %q is not running", h.Name)
+		}
+		for _, f := range forwards {
+			if f.VSock {
+				if _, err := os.Stat(hyperkit.VSockConnectPath(driver.ResolveStorePath(""))); err != nil {
+					return fmt.Errorf("vsock is not enabled for host %q; start it with a --publish option using /vsock", h.Name)
+				}
+				break
+			}
+		}
+	}
+
+	dialer := &guestDialer{driver: driver}
+	defer dialer.Close()
+
+	stop := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		close(stop)
+	}()
+	return hyperkit.ForwardPorts(forwards, dialer.Dial, stop)
+}
+
+// guestDialer creates connections to the guest. TCP connections are tunneled through a single
+// SSH connection, which is re-established when it fails.
+type guestDialer struct {
+	driver *hyperkit.Driver
+	mu     sync.Mutex
+	client *cryptossh.Client
+}
+
+func (g *guestDialer) Dial(f hyperkit.PortForward) (net.Conn, error) {
+	if f.VSock {
+		return hyperkit.DialVSock(g.driver.ResolveStorePath(""), f.GuestPort)
+	}
+	addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(f.GuestPort))
+	client, err := g.sshClient()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := client.Dial("tcp", addr)
+	if err == nil {
+		return conn, nil
+	}
+	// The SSH connection may have been lost, e.g. because the machine was restarted
+	g.reset(client)
+	if client, err = g.sshClient(); err != nil {
+		return nil, err
+	}
+	return client.Dial("tcp", addr)
+}
+
+func (g *guestDialer) sshClient() (*cryptossh.Client, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.client != nil {
+		return g.client, nil
+	}
+	ssh.SetDefaultClient(ssh.Native)
+	client, err := (&host.Host{Driver: g.driver}).CreateSSHClient()
+	if err != nil {
+		return nil, err
+	}
+	native, ok := client.(*ssh.NativeClient)
+	if !ok {
+		return nil, fmt.Errorf("unexpected SSH client type %T", client)
+	}
+	g.client, err = cryptossh.Dial("tcp", net.JoinHostPort(native.Hostname, strconv.Itoa(native.Port)), &native.Config)
+	return g.client, err
+}
+
+// reset closes client, unless it has been replaced already by another connection attempt
+func (g *guestDialer) reset(client *cryptossh.Client) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.client == client {
+		g.client.Close()
+		g.client = nil
+	}
+}
+
+func (g *guestDialer) Close() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.client == nil {
+		return nil
+	}
+	return g.client.Close()
+}
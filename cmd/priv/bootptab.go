@@ -0,0 +1,32 @@
+package priv
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/rancher-sandbox/docker-machine-driver-hyperkit/cmd"
+	"github.com/rancher-sandbox/docker-machine-driver-hyperkit/pkg/hyperkit"
+)
+
+func Bootptab() {
+	if len(os.Args) < 4 {
+		cmd.Abort("usage: bootptab [add NAME MAC IP|remove MAC...]")
+	}
+
+	var err error
+	switch os.Args[2] {
+	case "add":
+		if len(os.Args) != 6 {
+			cmd.Abort("usage: bootptab add NAME MAC IP")
+		}
+		err = hyperkit.AddBootptabEntry(hyperkit.BootptabPath, os.Args[3], os.Args[4], os.Args[5])
+	case "remove":
+		err = hyperkit.RemoveBootptabEntries(hyperkit.BootptabPath, os.Args[3:]...)
+	default:
+		err = fmt.Errorf("Unknown bootptab subcommand: %s", os.Args[2])
+	}
+	if err != nil {
+		cmd.Abort("bootptab %s failed: %v", os.Args[2], err)
+	}
+	os.Exit(0)
+}
@@ -0,0 +1,27 @@
+package priv
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/rancher-sandbox/docker-machine-driver-hyperkit/cmd"
+	"github.com/rancher-sandbox/docker-machine-driver-hyperkit/pkg/hyperkit"
+)
+
+func DHCPLeases() {
+	if len(os.Args) < 4 {
+		cmd.Abort("usage: dhcp-leases remove MAC...")
+	}
+
+	var err error
+	switch os.Args[2] {
+	case "remove":
+		err = hyperkit.RemoveDHCPLeases(hyperkit.LeasesPath, os.Args[3:]...)
+	default:
+		err = fmt.Errorf("Unknown dhcp-leases subcommand: %s", os.Args[2])
+	}
+	if err != nil {
+		cmd.Abort("dhcp-leases %s failed: %v", os.Args[2], err)
+	}
+	os.Exit(0)
+}
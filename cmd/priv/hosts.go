@@ -0,0 +1,36 @@
+package priv
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/rancher-sandbox/docker-machine-driver-hyperkit/cmd"
+	"github.com/rancher-sandbox/docker-machine-driver-hyperkit/pkg/hyperkit"
+)
+
+func Hosts() {
+	if len(os.Args) < 4 {
+		cmd.Abort("usage: hosts [add NAME IP|remove NAME]")
+	}
+
+	var err error
+	switch os.Args[2] {
+	case "add":
+		if len(os.Args) != 5 {
+			cmd.Abort("usage: hosts add NAME IP")
+		}
+		err = hyperkit.AddHostsEntry(hyperkit.HostsPath, os.Args[3], os.Args[4])
+	case "remove":
+		if len(os.Args) != 4 {
+			cmd.Abort("usage: hosts remove NAME")
+		}
+		err = hyperkit.RemoveHostsEntry(hyperkit.HostsPath, os.Args[3])
+	default:
+		err = fmt.Errorf("Unknown hosts subcommand: %s", os.Args[2])
+	}
+	if err != nil {
+		cmd.Abort("hosts %s failed: %v", os.Args[2], err)
+	}
+	hyperkit.FlushDNSCache()
+	os.Exit(0)
+}
@@ -9,6 +9,7 @@ import (
 
 	hyperkit "github.com/moby/hyperkit/go"
 	"github.com/rancher-sandbox/docker-machine-driver-hyperkit/cmd"
+	pkghyperkit "github.com/rancher-sandbox/docker-machine-driver-hyperkit/pkg/hyperkit"
 )
 
 func Hyperkit() {
@@ -22,15 +23,18 @@ func Hyperkit() {
 		cmd.Abort("Unmarshalling hyperkit structure failed: %v", err)
 	}
 
-	var disks []hyperkit.RawDisk
+	var disks []json.RawMessage
 	err = json.Unmarshal([]byte(os.Args[3]), &disks)
 	if err != nil {
 		cmd.Abort("Unmarshalling hyperkit disks structure failed: %v", err)
 	}
 
-	// Type conversion from hyperkit.RawDisk to hyperkit.Disk
-	for _, disk := range disks {
-		h.Disks = append(h.Disks, &disk)
+	for _, data := range disks {
+		disk, err := unmarshalDisk(data)
+		if err != nil {
+			cmd.Abort("Unmarshalling hyperkit disk failed: %v", err)
+		}
+		h.Disks = append(h.Disks, disk)
 	}
 
 	// If a file called "hyperkit" exists in the same directory as the driver,
@@ -42,14 +46,7 @@ func Hyperkit() {
 		}
 	}
 
-	// hyperkit executable must be owned by root (or group owned by either wheel or admin)
-	var stat syscall.Stat_t
-	if err := syscall.Stat(h.HyperKit, &stat); err != nil {
-		cmd.Abort("Cannot stat %s", h.HyperKit)
-	}
-	if stat.Uid != 0 && stat.Gid != 0 && stat.Gid != 80 {
-		cmd.Abort("Executable %s must be owned by root, or have group ownership by wheel(0) or admin(80)", h.HyperKit)
-	}
+	checkExecutableOwner(h.HyperKit)
 
 	_, err = h.Start(os.Args[4])
 	if err != nil {
@@ -58,3 +55,54 @@ func Hyperkit() {
 	_, _ = fmt.Fprintln(os.Stderr, "Hyperkit started successfully")
 	os.Exit(0)
 }
+
+// unmarshalDisk returns either a hyperkit.RawDisk or a hyperkit.QcowDisk, depending on the file extension of the disk path.
+// Extra network interfaces are passed as disks as well, and are returned as a pkghyperkit.NetworkDevice.
+func unmarshalDisk(data []byte) (hyperkit.Disk, error) {
+	var path struct {
+		Path string `json:"path"`
+		NIC  string `json:"nic"`
+	}
+	if err := json.Unmarshal(data, &path); err != nil {
+		return nil, err
+	}
+	if path.NIC != "" {
+		var nic pkghyperkit.NetworkDevice
+		if err := json.Unmarshal(data, &nic); err != nil {
+			return nil, err
+		}
+		// The fields end up in the hyperkit command line, which runs with root privileges
+		if err := nic.Validate(); err != nil {
+			return nil, err
+		}
+		return &nic, nil
+	}
+	if hyperkit.GetDiskFormat(path.Path) == hyperkit.DiskFormatQcow {
+		var disk hyperkit.QcowDisk
+		if err := json.Unmarshal(data, &disk); err != nil {
+			return nil, err
+		}
+		// qcow-tool is executed with root privileges as well, so apply the same checks as for hyperkit
+		if !filepath.IsAbs(disk.QcowToolPath) {
+			return nil, fmt.Errorf("path to qcow-tool must be absolute: %q", disk.QcowToolPath)
+		}
+		checkExecutableOwner(disk.QcowToolPath)
+		return &disk, nil
+	}
+	var disk hyperkit.RawDisk
+	if err := json.Unmarshal(data, &disk); err != nil {
+		return nil, err
+	}
+	return &disk, nil
+}
+
+// checkExecutableOwner aborts unless the executable is owned by root (or group owned by either wheel or admin)
+func checkExecutableOwner(executable string) {
+	var stat syscall.Stat_t
+	if err := syscall.Stat(executable, &stat); err != nil {
+		cmd.Abort("Cannot stat %s", executable)
+	}
+	if stat.Uid != 0 && stat.Gid != 0 && stat.Gid != 80 {
+		cmd.Abort("Executable %s must be owned by root, or have group ownership by wheel(0) or admin(80)", executable)
+	}
+}
@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+var newDiskSize int
+
+func init() {
+	rootCmd.AddCommand(resizeDiskCmd)
+	resizeDiskCmd.Flags().IntVar(&newDiskSize, "size", 0, "New disk size in MB")
+	_ = resizeDiskCmd.MarkFlagRequired("size")
+}
+
+var resizeDiskCmd = &cobra.Command{
+	Use:   "resize-disk",
+	Short: "Grow the disk of a machine.",
+	Long: `Grow the disk of a machine. Disks cannot be shrunk.
+If the machine is running, the partition and filesystem inside the guest are grown
+as well; otherwise they will be grown when the machine is started the next time.`,
+	RunE: resizeDiskCommand,
+}
+
+func resizeDiskCommand(cmd *cobra.Command, args []string) error {
+	api := newAPI()
+	defer api.Close()
+
+	host, err := api.Load(machineName)
+	if err != nil {
+		return err
+	}
+
+	driver, err := loadDriver(host)
+	if err != nil {
+		return err
+	}
+
+	if err := driver.ResizeDisk(newDiskSize); err != nil {
+		return fmt.Errorf("error resizing disk of host %s: %v", host.Name, err)
+	}
+	host.Driver = driver
+	return api.Save(host)
+}
@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rancher-sandbox/docker-machine-driver-hyperkit/pkg/hyperkit"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(snapshotCmd)
+	snapshotCmd.AddCommand(snapshotCreateCmd)
+	snapshotCmd.AddCommand(snapshotListCmd)
+	snapshotCmd.AddCommand(snapshotRestoreCmd)
+	snapshotCmd.AddCommand(snapshotDeleteCmd)
+}
+
+var snapshotCmd = &cobra.Command{
+	Use:   "snapshot",
+	Short: "Manage snapshots of a machine.",
+	Long: `Manage snapshots of a machine. A snapshot contains the machine disk and configuration;
+it is a copy-on-write clone when the storage path is on APFS, and a plain copy otherwise.`,
+}
+
+var snapshotCreateCmd = &cobra.Command{
+	Use:   "create NAME",
+	Short: "Create a snapshot of a machine.",
+	Long:  `Create a snapshot of a machine.`,
+	Args:  cobra.ExactArgs(1),
+	RunE: withDriver(func(driver *hyperkit.Driver, args []string) error {
+		return driver.CreateSnapshot(args[0])
+	}),
+}
+
+var snapshotListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the snapshots of a machine.",
+	Long:  `List the snapshots of a machine.`,
+	Args:  cobra.NoArgs,
+	RunE: withDriver(func(driver *hyperkit.Driver, args []string) error {
+		snapshots, err := driver.ListSnapshots()
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tCREATED")
+		for _, snapshot := range snapshots {
+			fmt.Fprintf(w, "%s\t%s\n", snapshot.Name, snapshot.Created.Format(time.RFC3339))
+		}
+		return w.Flush()
+	}),
+}
+
+var snapshotRestoreCmd = &cobra.Command{
+	Use:   "restore NAME",
+	Short: "Restore a machine from a snapshot.",
+	Long:  `Restore a machine from a snapshot. The machine must be stopped.`,
+	Args:  cobra.ExactArgs(1),
+	RunE: withDriver(func(driver *hyperkit.Driver, args []string) error {
+		return driver.RestoreSnapshot(args[0])
+	}),
+}
+
+var snapshotDeleteCmd = &cobra.Command{
+	Use:   "delete NAME",
+	Short: "Delete a snapshot of a machine.",
+	Long:  `Delete a snapshot of a machine.`,
+	Args:  cobra.ExactArgs(1),
+	RunE: withDriver(func(driver *hyperkit.Driver, args []string) error {
+		return driver.DeleteSnapshot(args[0])
+	}),
+}
+
+// withDriver returns a cobra RunE function that calls fn with the hyperkit driver of the current machine
+func withDriver(fn func(driver *hyperkit.Driver, args []string) error) func(cmd *cobra.Command, args []string) error {
+	return func(cmd *cobra.Command, args []string) error {
+		api := newAPI()
+		defer api.Close()
+
+		host, err := api.Load(machineName)
+		if err != nil {
+			return err
+		}
+
+		driver, err := loadDriver(host)
+		if err != nil {
+			return err
+		}
+		return fn(driver, args)
+	}
+}
@@ -0,0 +1,172 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/docker/machine/libmachine/log"
+	"github.com/rancher-sandbox/docker-machine-driver-hyperkit/pkg/hyperkit"
+	"github.com/spf13/cobra"
+)
+
+const (
+	// sshfsRetryInterval is the delay before a lost sshfs mount is mounted again
+	sshfsRetryInterval = 5 * time.Second
+	// sshfsUnmountTimeout is how long to wait for the mounts to go away when stopping; it is
+	// shorter than the time the driver waits for daemons to exit
+	sshfsUnmountTimeout = 5 * time.Second
+)
+
+func init() {
+	rootCmd.AddCommand(sshfsServerCmd)
+	sshfsServerCmd.Flags().StringVar(&guestIP, "guest-ip", "", "IP address of the machine")
+	sshfsServerCmd.Flags().IntVar(&guestSSHPort, "ssh-port", 0, "SSH port of the machine")
+	_ = sshfsServerCmd.Flags().MarkHidden("guest-ip")
+	_ = sshfsServerCmd.Flags().MarkHidden("ssh-port")
+}
+
+var sshfsServerCmd = &cobra.Command{
+	Use:   "sshfs-server DIR MOUNTPOINT [DIR MOUNTPOINT]...",
+	Short: "Mount directories in a machine with sshfs.",
+	Long: `Mount each directory in the machine with sshfs and serve its files over SFTP through SSH
+until interrupted, then unmount it. Mounts are restored when the SSH connection is lost.
+It is started by the driver for --share-type sshfs.`,
+	Hidden: true,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) == 0 || len(args)%2 != 0 {
+			return fmt.Errorf("expected pairs of DIR MOUNTPOINT arguments")
+		}
+		return nil
+	},
+	RunE: sshfsServerCommand,
+}
+
+func sshfsServerCommand(cmd *cobra.Command, args []string) error {
+	api := newAPI()
+	defer api.Close()
+
+	h, err := api.Load(machineName)
+	if err != nil {
+		return err
+	}
+	driver, err := loadDriver(h)
+	if err != nil {
+		return err
+	}
+	if guestIP != "" {
+		driver.IPAddress = guestIP
+		if guestSSHPort != 0 {
+			driver.SSHPort = guestSSHPort
+		}
+	}
+
+	dialer := &guestDialer{driver: driver}
+	defer dialer.Close()
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < len(args); i += 2 {
+		dir, mountPoint := args[i], args[i+1]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				if err := mountSSHFS(dialer, dir, mountPoint); err != nil {
+					log.Warnf("sshfs mount of %s on %s failed: %v", dir, mountPoint, err)
+				}
+				select {
+				case <-stop:
+					return
+				case <-time.After(sshfsRetryInterval):
+				}
+			}
+		}()
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	<-signals
+	close(stop)
+
+	// Unmounting ends the sshfs processes and with them the SSH sessions. The driver waits for
+	// this process to exit before it stops the machine, so the unmounting is bounded by a timeout.
+	done := make(chan struct{})
+	go func() {
+		for i := 1; i < len(args); i += 2 {
+			if err := runGuestCommand(dialer, hyperkit.SSHFSUnmountCommand(args[i])); err != nil {
+				log.Warnf("Unmounting %s failed: %v", args[i], err)
+			}
+		}
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(sshfsUnmountTimeout):
+		log.Warnf("Unmounting the shares didn't finish within %s", sshfsUnmountTimeout)
+	}
+	return nil
+}
+
+// mountSSHFS runs sshfs in the guest to mount dir on mountPoint, and serves the files until sshfs exits
+func mountSSHFS(dialer *guestDialer, dir, mountPoint string) error {
+	client, err := dialer.sshClient()
+	if err != nil {
+		return err
+	}
+	session, err := client.NewSession()
+	if err != nil {
+		// The SSH connection may have been lost, e.g. because the machine was restarted
+		dialer.reset(client)
+		return err
+	}
+	defer session.Close()
+
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	session.Stderr = os.Stderr
+	if err := session.Start(hyperkit.SSHFSMountCommand(mountPoint)); err != nil {
+		return err
+	}
+	log.Infof("Mounting %s on %s", dir, mountPoint)
+	serveErr := hyperkit.ServeSFTP(sessionPipe{Reader: stdout, WriteCloser: stdin}, dir)
+	if err := session.Wait(); err != nil {
+		return err
+	}
+	return serveErr
+}
+
+// runGuestCommand runs command in the guest over the SSH connection of dialer
+func runGuestCommand(dialer *guestDialer, command string) error {
+	client, err := dialer.sshClient()
+	if err != nil {
+		return err
+	}
+	session, err := client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	out, err := session.CombinedOutput(command)
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, out)
+	}
+	return nil
+}
+
+// sessionPipe connects the standard output and input of an SSH session to the SFTP server
+type sessionPipe struct {
+	io.Reader
+	io.WriteCloser
+}
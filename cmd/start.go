@@ -5,10 +5,11 @@ import (
 	"fmt"
 	"path"
 	"path/filepath"
-	"strings"
 
 	"github.com/docker/machine/libmachine"
 	"github.com/docker/machine/libmachine/drivers"
+	"github.com/docker/machine/libmachine/host"
+	pkgdrivers "github.com/rancher-sandbox/docker-machine-driver-hyperkit/pkg/drivers"
 	"github.com/rancher-sandbox/docker-machine-driver-hyperkit/pkg/hyperkit"
 	"github.com/spf13/cobra"
 )
@@ -16,11 +17,26 @@ import (
 var (
 	cmdline      string
 	cpuCount     int
+	dataDisks    []string
+	diskFormat   string
 	diskSize     int
+	guestVSock   bool
+	hostsEntry   bool
 	hyperkitPath string
+	initrdPath   string
+	ipTimeout    int
+	isoSHA256    string
 	isoURL       string
+	kernelPath   string
 	memorySize   int
 	mountRoot    string
+	network      string
+	nics         []string
+	noISO        bool
+	publish      []string
+	shareType    string
+	staticIP     string
+	vpnkitSock   string
 	volumeMounts []string
 
 	startCmd = &cobra.Command{
@@ -29,25 +45,34 @@ var (
 		Long:  `Create and start a new VM.`,
 		RunE:  startCommand,
 	}
-
-	// TODO(jandubois) these boot options are a subset of what minikube uses right now
-	// TODO audit the settings and document why each one is being used!
-	// The "noembed" option is required on boot2docker.iso (TinyCoreLinux) to make sure
-	// the system doesn't run out of a ramdisk; otherwise pivot_root will fail.
-	defaultCmdline = "loglevel=3 console=ttyS0 console=tty0 noembed nomodeset norestore random.trust_cpu=on hw_rng_model=virtio base"
 )
 
 func init() {
 	rootCmd.AddCommand(startCmd)
 
-	startCmd.Flags().StringVar(&cmdline, "boot-options", defaultCmdline, "Boot commandline options")
+	startCmd.Flags().StringVar(&cmdline, "boot-options", "", "Boot commandline options; taken from the ISO by default, a leading '+' appends to those")
 	startCmd.Flags().IntVar(&cpuCount, "cpus", 2, "Number of cpus")
+	startCmd.Flags().StringArrayVar(&dataDisks, "data-disk", []string{}, "Additional disk as name:sizeMB (repeatable)")
+	startCmd.Flags().StringVar(&diskFormat, "disk-format", pkgdrivers.DiskFormatRaw, "Disk image format (raw or qcow2)")
 	startCmd.Flags().IntVar(&diskSize, "disk-size", 40000, "Disk size in MB")
+	startCmd.Flags().BoolVar(&guestVSock, "guest-vsock", false, "The guest accepts SSH and Docker connections on vsock ports 22 and 2376, as required by --network vpnkit")
+	startCmd.Flags().BoolVar(&hostsEntry, "hosts-entry", false, "Map <name>.hyperkit.local to the machine IP address in /etc/hosts")
 	startCmd.Flags().StringVar(&hyperkitPath, "hyperkit", "", "Path to hyperkit executable")
+	startCmd.Flags().StringVar(&initrdPath, "initrd", "", "Path to an initrd to boot instead of the one from the ISO")
+	startCmd.Flags().IntVar(&ipTimeout, "ip-timeout", 60, "Seconds to wait for the machine to get an IP address")
+	startCmd.Flags().StringVar(&isoSHA256, "iso-sha256", "", "Expected SHA-256 digest of the ISO (defaults to the content of <iso-url>.sha256, if it exists)")
 	startCmd.Flags().StringVar(&isoURL, "iso-url", "", "URL of the boot2docker.iso")
+	startCmd.Flags().StringVar(&kernelPath, "kernel", "", "Path to a kernel to boot instead of the one from the ISO")
 	startCmd.Flags().IntVar(&memorySize, "memory", 4096, "Memory size in MB")
 	startCmd.Flags().StringVar(&mountRoot, "mount-root", "/nfsshares", "NFS mount root")
-	startCmd.Flags().StringArrayVar(&volumeMounts, "volume", []string{}, "Paths to mount via NFS")
+	startCmd.Flags().StringVar(&network, "network", hyperkit.NetworkVMNet, "Network mode: vmnet, vpnkit or both; vpnkit alone requires --guest-vsock")
+	startCmd.Flags().StringArrayVar(&nics, "nic", []string{}, "Additional network interface: vmnet, vpnkit or vpnkit:IP (repeatable)")
+	startCmd.Flags().BoolVar(&noISO, "no-iso", false, "Don't attach an ISO; requires --kernel")
+	startCmd.Flags().StringArrayVar(&publish, "publish", []string{}, "Forward a localhost port to the machine as HOSTPORT:GUESTPORT[/vsock] (repeatable)")
+	startCmd.Flags().StringVar(&shareType, "share-type", hyperkit.ShareNFS, "How --volume paths are shared: nfs (requires admin rights), 9p or sshfs")
+	startCmd.Flags().StringVar(&staticIP, "static-ip", "", "IP address to reserve for the machine in the vmnet subnet")
+	startCmd.Flags().StringVar(&vpnkitSock, "vpnkit-sock", "", "Path of the vpnkit socket; \"auto\" uses the one of Docker Desktop")
+	startCmd.Flags().StringArrayVar(&volumeMounts, "volume", []string{}, "Paths to share with the machine as local[:guest[:options]]; options such as ro,maproot=0,sync apply to nfs (repeatable)")
 }
 
 func startCommand(cmd *cobra.Command, args []string) error {
@@ -55,6 +80,9 @@ func startCommand(cmd *cobra.Command, args []string) error {
 	defer api.Close()
 
 	driver, err := newDriver(machineName, storagePath)
+	if err != nil {
+		return err
+	}
 	data, err := json.Marshal(driver)
 	if err != nil {
 		return err
@@ -76,6 +104,16 @@ func newAPI() *libmachine.Client {
 	return libmachine.NewClient(storagePath, path.Join(storagePath, "certs"))
 }
 
+// loadDriver returns the hyperkit driver of an existing host. Unlike host.Driver, which talks
+// to the driver plugin via RPC, it gives access to the methods that are specific to hyperkit.
+func loadDriver(host *host.Host) (*hyperkit.Driver, error) {
+	driver := hyperkit.NewDriver(host.Name, storagePath)
+	if err := json.Unmarshal(host.RawDriver, driver); err != nil {
+		return nil, fmt.Errorf("error loading driver config for host %s: %v", host.Name, err)
+	}
+	return driver, nil
+}
+
 func newDriver(machineName, storePath string) (interface{}, error) {
 	if hyperkitPath != "" {
 		realPath, err := filepath.EvalSymlinks(hyperkitPath)
@@ -84,6 +122,22 @@ func newDriver(machineName, storePath string) (interface{}, error) {
 		}
 		hyperkitPath = realPath
 	}
+	for _, path := range []*string{&kernelPath, &initrdPath} {
+		if *path != "" {
+			absPath, err := filepath.Abs(*path)
+			if err != nil {
+				return nil, err
+			}
+			*path = absPath
+		}
+	}
+	if vpnkitSock != "" && vpnkitSock != "auto" {
+		absPath, err := filepath.Abs(vpnkitSock)
+		if err != nil {
+			return nil, err
+		}
+		vpnkitSock = absPath
+	}
 	driver := hyperkit.Driver{
 		BaseDriver: &drivers.BaseDriver{
 			MachineName: machineName,
@@ -91,18 +145,41 @@ func newDriver(machineName, storePath string) (interface{}, error) {
 			SSHUser:     "docker",
 		},
 		Boot2DockerURL: isoURL,
+		DiskFormat:     diskFormat,
 		DiskSize:       diskSize,
+		GuestVSock:     guestVSock,
+		HostsEntry:     hostsEntry,
 		Hyperkit:       hyperkitPath,
+		IPTimeout:      ipTimeout,
+		ISOSHA256:      isoSHA256,
+		Initrd:         initrdPath,
+		Kernel:         kernelPath,
 		Memory:         memorySize,
 		CPU:            cpuCount,
 		NFSSharesRoot:  mountRoot,
 		NFSShares:      volumeMounts,
+		Network:        network,
+		NoISO:          noISO,
+		Publish:        publish,
+		ShareType:      shareType,
+		StaticIP:       staticIP,
+		VpnKitSock:     vpnkitSock,
 		Cmdline:        cmdline,
 	}
 
-	// If the user-provided cmdline starts with a "+" then it is supposed to be appended to the defaultCmdline.
-	if strings.HasPrefix(cmdline, "+") {
-		driver.Cmdline = fmt.Sprintf("%s %s", defaultCmdline, cmdline[1:])
+	for _, spec := range dataDisks {
+		disk, err := hyperkit.ParseDataDisk(spec)
+		if err != nil {
+			return nil, err
+		}
+		driver.ExtraDisks = append(driver.ExtraDisks, disk)
+	}
+	for _, spec := range nics {
+		nic, err := hyperkit.ParseNIC(spec)
+		if err != nil {
+			return nil, err
+		}
+		driver.ExtraNICs = append(driver.ExtraNICs, nic)
 	}
 	return &driver, nil
 }
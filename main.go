@@ -46,6 +46,12 @@ func main() {
 	if len(os.Args) > 1 {
 		// All of the privileged commands will call os.Exit() and never return
 		switch os.Args[1] {
+		case "bootptab":
+			priv.Bootptab()
+		case "dhcp-leases":
+			priv.DHCPLeases()
+		case "hosts":
+			priv.Hosts()
 		case "hyperkit":
 			priv.Hyperkit()
 		case "nfs-exports":
@@ -17,6 +17,7 @@ limitations under the License.
 package drivers
 
 import (
+	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
@@ -34,9 +35,38 @@ import (
 // This file is for common code shared among internal machine drivers
 // Code here should not be called from within minikube
 
+const (
+	// DiskFormatRaw is a plain disk image that is sized by truncating the file
+	DiskFormatRaw = "raw"
+	// DiskFormatQcow2 is a sparse qcow2 image that only grows as blocks are being written
+	DiskFormatQcow2 = "qcow2"
+)
+
+// ValidateDiskFormat returns an error if format is not one of the supported disk formats
+func ValidateDiskFormat(format string) error {
+	switch format {
+	case DiskFormatRaw, DiskFormatQcow2:
+		return nil
+	}
+	return fmt.Errorf("unsupported disk format %q; must be either %q or %q", format, DiskFormatRaw, DiskFormatQcow2)
+}
+
+func diskImagePath(d *drivers.BaseDriver, name, format string) string {
+	ext := ".rawdisk"
+	if format == DiskFormatQcow2 {
+		ext = ".qcow2"
+	}
+	return filepath.Join(d.ResolveStorePath("."), name+ext)
+}
+
 // GetDiskPath returns the path of the machine disk image
-func GetDiskPath(d *drivers.BaseDriver) string {
-	return filepath.Join(d.ResolveStorePath("."), d.GetMachineName()+".rawdisk")
+func GetDiskPath(d *drivers.BaseDriver, format string) string {
+	return diskImagePath(d, d.GetMachineName(), format)
+}
+
+// GetDataDiskPath returns the path of an additional, named disk image of the machine
+func GetDataDiskPath(d *drivers.BaseDriver, name, format string) string {
+	return diskImagePath(d, d.GetMachineName()+"-"+name, format)
 }
 
 // CommonDriver is the common driver base class
@@ -80,6 +110,50 @@ func createRawDiskImage(sshKeyPath, diskPath string, diskSizeMb int) error {
 	return nil
 }
 
+func createQcow2DiskImage(sshKeyPath, diskPath string, diskSizeMb int) error {
+	tarBuf, err := mcnutils.MakeDiskImage(sshKeyPath)
+	if err != nil {
+		return errors.Wrap(err, "make disk image")
+	}
+
+	file, err := os.OpenFile(diskPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.Wrap(err, "open")
+	}
+	defer file.Close()
+
+	if err := writeQcow2Image(file, tarBuf.Bytes(), int64(diskSizeMb)*1024*1024); err != nil {
+		return errors.Wrap(err, "write qcow2")
+	}
+	if err := file.Close(); err != nil {
+		return errors.Wrapf(err, "closing file %s", diskPath)
+	}
+	return nil
+}
+
+// CreateEmptyDiskImage creates a disk image without any content (it is left to the guest to format it)
+func CreateEmptyDiskImage(diskPath string, diskSizeMb int, diskFormat string) error {
+	file, err := os.OpenFile(diskPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.Wrap(err, "open")
+	}
+	defer file.Close()
+
+	size := int64(diskSizeMb) * 1024 * 1024
+	if diskFormat == DiskFormatQcow2 {
+		err = writeQcow2Image(file, nil, size)
+	} else {
+		err = file.Truncate(size)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "creating %s disk image %s", diskFormat, diskPath)
+	}
+	if err := file.Close(); err != nil {
+		return errors.Wrapf(err, "closing file %s", diskPath)
+	}
+	return nil
+}
+
 func publicSSHKeyPath(d *drivers.BaseDriver) string {
 	return d.GetSSHKeyPath() + ".pub"
 }
@@ -94,25 +168,54 @@ func Restart(d drivers.Driver) error {
 	return d.Start()
 }
 
-// MakeDiskImage makes a boot2docker VM disk image.
-func MakeDiskImage(d *drivers.BaseDriver, boot2dockerURL string, diskSize int) error {
-	log.Infof("Making disk image using store path: %s", d.StorePath)
+// CopyIsoToMachineDir downloads the boot2docker ISO (unless it is cached already) and copies it into the machine directory.
+// The ISO is verified against isoSHA256, or against the checksum file next to boot2dockerURL if isoSHA256 is empty.
+// It returns the verified digest, or an empty string if no checksum was available.
+func CopyIsoToMachineDir(d *drivers.BaseDriver, boot2dockerURL, isoSHA256 string) (string, error) {
 	b2 := mcnutils.NewB2dUtils(d.StorePath)
 	if err := b2.CopyIsoToMachineDir(boot2dockerURL, d.MachineName); err != nil {
-		return errors.Wrap(err, "copy iso to machine dir")
+		return "", errors.Wrap(err, "copy iso to machine dir")
 	}
 
+	if isoSHA256 == "" && boot2dockerURL != "" {
+		digest, err := FetchSiblingChecksum(boot2dockerURL)
+		if err != nil {
+			return "", errors.Wrap(err, "fetching iso checksum")
+		}
+		isoSHA256 = digest
+	}
+	isoPath := d.ResolveStorePath("boot2docker.iso")
+	if isoSHA256 == "" {
+		log.Warnf("No checksum available for %s; the ISO has not been verified", isoPath)
+		return "", nil
+	}
+	log.Infof("Verifying checksum of %s...", isoPath)
+	if err := VerifySHA256(isoPath, isoSHA256); err != nil {
+		// Don't leave an untrusted image behind that could be booted later on
+		_ = os.Remove(isoPath)
+		return "", err
+	}
+	return NormalizeSHA256(isoSHA256)
+}
+
+// MakeDiskImage makes a boot2docker VM disk image.
+func MakeDiskImage(d *drivers.BaseDriver, diskSize int, diskFormat string) error {
+	log.Infof("Making disk image using store path: %s", d.StorePath)
 	keyPath := d.GetSSHKeyPath()
 	log.Infof("Creating ssh key: %s...", keyPath)
 	if err := ssh.GenerateSSHKey(keyPath); err != nil {
 		return errors.Wrap(err, "generate ssh key")
 	}
 
-	diskPath := GetDiskPath(d)
-	log.Infof("Creating raw disk image: %s...", diskPath)
+	diskPath := GetDiskPath(d, diskFormat)
+	log.Infof("Creating %s disk image: %s...", diskFormat, diskPath)
 	if _, err := os.Stat(diskPath); os.IsNotExist(err) {
-		if err := createRawDiskImage(publicSSHKeyPath(d), diskPath, diskSize); err != nil {
-			return errors.Wrapf(err, "createRawDiskImage(%s)", diskPath)
+		createDiskImage := createRawDiskImage
+		if diskFormat == DiskFormatQcow2 {
+			createDiskImage = createQcow2DiskImage
+		}
+		if err := createDiskImage(publicSSHKeyPath(d), diskPath, diskSize); err != nil {
+			return errors.Wrapf(err, "create %s disk image (%s)", diskFormat, diskPath)
 		}
 		machPath := d.ResolveStorePath(".")
 		if err := fixMachinePermissions(machPath); err != nil {
@@ -17,6 +17,8 @@ limitations under the License.
 package drivers
 
 import (
+	"bytes"
+	"encoding/binary"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -54,3 +56,112 @@ func Test_createDiskImage(t *testing.T) {
 		t.Errorf("Disk size is %v, want %v", fi.Size(), sizeInBytes)
 	}
 }
+
+func Test_createQcow2DiskImage(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "")
+	if nil != err {
+		return
+	}
+	defer func() { //clean up tempdir
+		err := os.RemoveAll(tmpdir)
+		if err != nil {
+			t.Errorf("failed to clean up temp folder  %q", tmpdir)
+		}
+	}()
+
+	sshPath := filepath.Join(tmpdir, "ssh")
+	if err := ioutil.WriteFile(sshPath, []byte("mysshkey"), 0644); err != nil {
+		t.Fatalf("writefile: %v", err)
+	}
+	diskPath := filepath.Join(tmpdir, "disk.qcow2")
+
+	sizeInMb := 40000
+	if err := createQcow2DiskImage(sshPath, diskPath, sizeInMb); err != nil {
+		t.Fatalf("createQcow2DiskImage() error = %v", err)
+	}
+	image, err := ioutil.ReadFile(diskPath)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if int64(len(image)) >= int64(sizeInMb)*1024*1024/100 {
+		t.Errorf("Image file size is %v, should be sparse", len(image))
+	}
+
+	be := binary.BigEndian
+	if magic := be.Uint32(image[0:]); magic != qcow2Magic {
+		t.Fatalf("Magic is %x, want %x", magic, qcow2Magic)
+	}
+	if size := be.Uint64(image[24:]); size != uint64(sizeInMb)*1024*1024 {
+		t.Errorf("Virtual size is %v, want %v", size, sizeInMb*1024*1024)
+	}
+
+	// Follow the L1 and L2 tables to the first data cluster; it must contain the userdata tar
+	l1Offset := be.Uint64(image[40:])
+	l2Offset := be.Uint64(image[l1Offset:]) &^ qcow2OflagCopied
+	dataOffset := be.Uint64(image[l2Offset:]) &^ qcow2OflagCopied
+	if dataOffset == 0 || dataOffset%qcow2ClusterSize != 0 {
+		t.Fatalf("Data cluster offset is %v", dataOffset)
+	}
+	magicString := "boot2docker, please format-me"
+	if !bytes.HasPrefix(image[dataOffset:], []byte(magicString)) {
+		t.Errorf("First data cluster does not start with %q", magicString)
+	}
+	if !bytes.Contains(image[dataOffset:], []byte("mysshkey")) {
+		t.Errorf("First data cluster does not contain the ssh key")
+	}
+
+	// All clusters in the file must have a refcount of 1
+	refcountTableOffset := be.Uint64(image[48:])
+	refcountBlockOffset := be.Uint64(image[refcountTableOffset:])
+	for i := 0; i < len(image)/qcow2ClusterSize; i++ {
+		if refcount := be.Uint16(image[refcountBlockOffset+uint64(i*2):]); refcount != 1 {
+			t.Errorf("Refcount of cluster %d is %d, want 1", i, refcount)
+		}
+	}
+}
+
+func Test_CreateEmptyDiskImage(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "")
+	if nil != err {
+		return
+	}
+	defer func() { //clean up tempdir
+		err := os.RemoveAll(tmpdir)
+		if err != nil {
+			t.Errorf("failed to clean up temp folder  %q", tmpdir)
+		}
+	}()
+
+	rawPath := filepath.Join(tmpdir, "data.rawdisk")
+	if err := CreateEmptyDiskImage(rawPath, 100, DiskFormatRaw); err != nil {
+		t.Fatalf("CreateEmptyDiskImage() error = %v", err)
+	}
+	fi, err := os.Stat(rawPath)
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if fi.Size() != 104857600 {
+		t.Errorf("Disk size is %v, want %v", fi.Size(), 104857600)
+	}
+
+	qcow2Path := filepath.Join(tmpdir, "data.qcow2")
+	if err := CreateEmptyDiskImage(qcow2Path, 100, DiskFormatQcow2); err != nil {
+		t.Fatalf("CreateEmptyDiskImage() error = %v", err)
+	}
+	image, err := ioutil.ReadFile(qcow2Path)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	be := binary.BigEndian
+	if size := be.Uint64(image[24:]); size != 104857600 {
+		t.Errorf("Virtual size is %v, want %v", size, 104857600)
+	}
+	l1Offset := be.Uint64(image[40:])
+	if l2Offset := be.Uint64(image[l1Offset:]); l2Offset != 0 {
+		t.Errorf("Empty image should not have any L2 tables, found one at %v", l2Offset)
+	}
+
+	if err := CreateEmptyDiskImage(rawPath, 100, DiskFormatRaw); err == nil {
+		t.Errorf("CreateEmptyDiskImage() should not overwrite existing images")
+	}
+}
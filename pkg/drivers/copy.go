@@ -0,0 +1,132 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/docker/machine/libmachine/log"
+	"github.com/pkg/errors"
+)
+
+// copyBlockSize is the granularity used to detect holes when copying sparse files
+const copyBlockSize = 64 * 1024
+
+// CloneFile creates dst as a copy of src; dst must not exist yet. It makes a copy-on-write
+// clone when the filesystem supports it (APFS), and falls back to copying the file otherwise.
+func CloneFile(src, dst string) error {
+	err := clonefile(src, dst)
+	if err == nil {
+		return nil
+	}
+	log.Debugf("Cannot clone %s, falling back to copy: %v", src, err)
+	return copyFile(src, dst)
+}
+
+// copyFile copies src to dst, keeping all-zero blocks as holes so that sparse
+// disk images don't become fully allocated.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	buf := make([]byte, copyBlockSize)
+	zeros := make([]byte, copyBlockSize)
+	for {
+		n, readErr := io.ReadFull(in, buf)
+		if n > 0 {
+			var err error
+			if bytes.Equal(buf[:n], zeros[:n]) {
+				_, err = out.Seek(int64(n), io.SeekCurrent)
+			} else {
+				_, err = out.Write(buf[:n])
+			}
+			if err != nil {
+				return errors.Wrapf(err, "copying %s", src)
+			}
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil {
+			return errors.Wrapf(readErr, "reading %s", src)
+		}
+	}
+	// Trailing holes are only created by setting the file size
+	if err := out.Truncate(fi.Size()); err != nil {
+		return errors.Wrapf(err, "truncate %s", dst)
+	}
+	return out.Close()
+}
+
+// LinkFile replaces dst with a hard link to src, or with a clone of src when src and dst
+// are on different filesystems. dst is replaced atomically, so it never appears partially written.
+func LinkFile(src, dst string) error {
+	tmp := dst + ".tmp"
+	if err := os.Remove(tmp); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Link(src, tmp); err != nil {
+		log.Debugf("Cannot link %s, falling back to clone: %v", src, err)
+		if err := CloneFile(src, tmp); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// WriteFileAtomic replaces path with a file containing data. The data is written to a temporary
+// file in the same directory first, so readers never see a partially written file.
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
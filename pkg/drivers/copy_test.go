@@ -0,0 +1,69 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_copyFile(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "")
+	if nil != err {
+		return
+	}
+	defer func() { //clean up tempdir
+		err := os.RemoveAll(tmpdir)
+		if err != nil {
+			t.Errorf("failed to clean up temp folder  %q", tmpdir)
+		}
+	}()
+
+	// A file with data in the middle of a hole and a trailing hole that is not block aligned
+	src := filepath.Join(tmpdir, "src")
+	content := make([]byte, 5*copyBlockSize+123)
+	copy(content[2*copyBlockSize+7:], "some data")
+	if err := ioutil.WriteFile(src, content, 0600); err != nil {
+		t.Fatalf("writefile: %v", err)
+	}
+
+	dst := filepath.Join(tmpdir, "dst")
+	if err := copyFile(src, dst); err != nil {
+		t.Fatalf("copyFile() error = %v", err)
+	}
+	got, err := ioutil.ReadFile(dst)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Errorf("copy of %s has different content (size %d, want %d)", src, len(got), len(content))
+	}
+	fi, err := os.Stat(dst)
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("copy has mode %v, want %v", fi.Mode().Perm(), os.FileMode(0600))
+	}
+
+	if err := CloneFile(src, dst); err == nil {
+		t.Errorf("CloneFile() should fail when the destination exists")
+	}
+}
@@ -0,0 +1,178 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// checksumFileSuffix is appended to an ISO URL to locate its checksum file
+const checksumFileSuffix = ".sha256"
+
+var (
+	sha256Regexp      = regexp.MustCompile(`^[0-9a-f]{64}$`)
+	bsdChecksumRegexp = regexp.MustCompile(`^SHA256 \((.*)\) = ([0-9a-fA-F]+)$`)
+)
+
+// FileSHA256 returns the hex encoded SHA-256 digest of the file at path
+func FileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// ChecksumMismatchError is returned when a file doesn't have the expected SHA-256 digest
+type ChecksumMismatchError struct {
+	Path     string
+	Expected string
+	Actual   string
+}
+
+func (e *ChecksumMismatchError) Error() string {
+	return fmt.Sprintf("SHA-256 checksum mismatch for %s: expected %s, got %s", e.Path, e.Expected, e.Actual)
+}
+
+// NormalizeSHA256 validates a hex encoded SHA-256 digest, which may have a "sha256:" prefix,
+// and returns it in lower case without the prefix.
+func NormalizeSHA256(digest string) (string, error) {
+	normalized := strings.TrimPrefix(strings.ToLower(strings.TrimSpace(digest)), "sha256:")
+	if !sha256Regexp.MatchString(normalized) {
+		return "", fmt.Errorf("invalid SHA-256 digest %q", digest)
+	}
+	return normalized, nil
+}
+
+// VerifySHA256 returns a *ChecksumMismatchError if the file at path doesn't have the expected digest
+func VerifySHA256(path, expected string) error {
+	expected, err := NormalizeSHA256(expected)
+	if err != nil {
+		return err
+	}
+	actual, err := FileSHA256(path)
+	if err != nil {
+		return err
+	}
+	if actual != expected {
+		return &ChecksumMismatchError{Path: path, Expected: expected, Actual: actual}
+	}
+	return nil
+}
+
+// ParseChecksumFile returns the digest for filename from the content of a checksum file.
+// It accepts a bare digest, the output of sha256sum ("digest  name" or "digest *name")
+// and the BSD format ("SHA256 (name) = digest"). A file with a single digest applies to
+// any filename.
+func ParseChecksumFile(data []byte, filename string) (string, error) {
+	var digests []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var digest, name string
+		if m := bsdChecksumRegexp.FindStringSubmatch(line); m != nil {
+			name, digest = m[1], m[2]
+		} else {
+			fields := strings.Fields(line)
+			digest = fields[0]
+			if len(fields) > 1 {
+				name = strings.TrimPrefix(fields[1], "*")
+			}
+		}
+		if name != "" && filepath.Base(name) == filename {
+			return NormalizeSHA256(digest)
+		}
+		digests = append(digests, digest)
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	if len(digests) == 1 {
+		return NormalizeSHA256(digests[0])
+	}
+	return "", fmt.Errorf("no checksum for %s found", filename)
+}
+
+// FetchSiblingChecksum returns the digest from the checksum file next to isoURL (isoURL + ".sha256"),
+// which may be a local path, a file:// URL or an http(s) URL. It returns an empty string when there
+// is no such file.
+func FetchSiblingChecksum(isoURL string) (string, error) {
+	u, err := url.Parse(isoURL)
+	if err != nil {
+		return "", err
+	}
+	filename := path.Base(u.Path)
+	checksumURL := isoURL + checksumFileSuffix
+
+	var data []byte
+	if u.Scheme == "file" || u.Scheme == "" {
+		data, err = ioutil.ReadFile(u.Path + checksumFileSuffix)
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		if err != nil {
+			return "", err
+		}
+	} else {
+		client := &http.Client{Timeout: 30 * time.Second}
+		resp, err := client.Get(checksumURL)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound {
+			return "", nil
+		}
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("fetching %s: %s", checksumURL, resp.Status)
+		}
+		// Checksum files are tiny; don't read arbitrary amounts of data from a misconfigured server
+		if data, err = ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024)); err != nil {
+			return "", err
+		}
+	}
+	digest, err := ParseChecksumFile(data, filename)
+	if err != nil {
+		return "", errors.Wrapf(err, "parsing %s", checksumURL)
+	}
+	return digest, nil
+}
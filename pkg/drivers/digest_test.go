@@ -0,0 +1,136 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// sha256 of "hello\n"
+const helloDigest = "5891b5b522d5df086d0ff0b110fbd9d21bb4fc7163af34d08286a2e846f6be03"
+
+func TestParseChecksumFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    string
+		wantErr bool
+	}{
+		{"bare digest", helloDigest + "\n", helloDigest, false},
+		{"sha256sum", helloDigest + "  boot2docker.iso\n", helloDigest, false},
+		{"binary mode", helloDigest + " *boot2docker.iso\n", helloDigest, false},
+		{"bsd", "SHA256 (boot2docker.iso) = " + helloDigest + "\n", helloDigest, false},
+		{"other file only", helloDigest + "  other.iso\n", helloDigest, false},
+		{"multiple files", "0000000000000000000000000000000000000000000000000000000000000000  other.iso\n" +
+			helloDigest + "  dist/boot2docker.iso\n", helloDigest, false},
+		{"multiple files without match", helloDigest + "  a.iso\n" + helloDigest + "  b.iso\n", "", true},
+		{"upper case", "SHA256:5891B5B522D5DF086D0FF0B110FBD9D21BB4FC7163AF34D08286A2E846F6BE03", helloDigest, false},
+		{"invalid digest", "5891b5b5  boot2docker.iso\n", "", true},
+		{"empty", "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseChecksumFile([]byte(tt.data), "boot2docker.iso")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseChecksumFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseChecksumFile() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifySHA256(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "")
+	if err != nil {
+		t.Fatalf("TempDir() error = %v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	path := filepath.Join(tmpdir, "boot2docker.iso")
+	if err := ioutil.WriteFile(path, []byte("hello\n"), 0600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if err := VerifySHA256(path, "sha256:"+helloDigest); err != nil {
+		t.Errorf("VerifySHA256() error = %v", err)
+	}
+	wrong := "0000000000000000000000000000000000000000000000000000000000000000"
+	err = VerifySHA256(path, wrong)
+	mismatch, ok := err.(*ChecksumMismatchError)
+	if !ok {
+		t.Fatalf("VerifySHA256() error = %v, want a *ChecksumMismatchError", err)
+	}
+	if mismatch.Expected != wrong || mismatch.Actual != helloDigest {
+		t.Errorf("VerifySHA256() error = %+v", mismatch)
+	}
+}
+
+func TestFetchSiblingChecksum(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "")
+	if err != nil {
+		t.Fatalf("TempDir() error = %v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	isoPath := filepath.Join(tmpdir, "boot2docker.iso")
+	if err := ioutil.WriteFile(isoPath+".sha256", []byte(helloDigest+"  boot2docker.iso\n"), 0600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/boot2docker.iso.sha256":
+			_, _ = w.Write([]byte(helloDigest))
+		case "/broken.iso.sha256":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		isoURL  string
+		want    string
+		wantErr bool
+	}{
+		{"local path", isoPath, helloDigest, false},
+		{"file url", "file://" + isoPath, helloDigest, false},
+		{"missing local file", filepath.Join(tmpdir, "other.iso"), "", false},
+		{"http", server.URL + "/boot2docker.iso", helloDigest, false},
+		{"missing http file", server.URL + "/other.iso", "", false},
+		{"http error", server.URL + "/broken.iso", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := FetchSiblingChecksum(tt.isoURL)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("FetchSiblingChecksum() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("FetchSiblingChecksum() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
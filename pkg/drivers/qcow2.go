@@ -0,0 +1,134 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// The qcow2 writer below only produces fresh images: a header, a single refcount table,
+// the refcount blocks, the L1 table, and just enough L2 tables and data clusters to hold
+// the initial content. All remaining clusters are unallocated and will be added by
+// hyperkit on demand, which is what keeps the image sparse and growable.
+const (
+	qcow2Magic         = 0x514649fb
+	qcow2Version       = 3
+	qcow2ClusterBits   = 16
+	qcow2ClusterSize   = 1 << qcow2ClusterBits
+	qcow2HeaderLength  = 104
+	qcow2RefcountOrder = 4 // 16 bit refcounts
+	qcow2OflagCopied   = uint64(1) << 63
+
+	qcow2L2Entries       = qcow2ClusterSize / 8
+	qcow2RefcountEntries = qcow2ClusterSize * 8 / (1 << qcow2RefcountOrder)
+)
+
+func divRoundUp(n, d int64) int64 {
+	return (n + d - 1) / d
+}
+
+// writeQcow2Image writes a qcow2 image with a virtual size of `size` bytes to w.
+// The first len(data) bytes of the virtual disk contain `data`; the rest reads as zeros.
+func writeQcow2Image(w io.WriterAt, data []byte, size int64) error {
+	if int64(len(data)) > size {
+		return fmt.Errorf("initial data (%d bytes) does not fit into a %d byte disk", len(data), size)
+	}
+
+	dataClusters := divRoundUp(int64(len(data)), qcow2ClusterSize)
+	l2Tables := divRoundUp(dataClusters, qcow2L2Entries)
+	l1Size := divRoundUp(size, qcow2ClusterSize*qcow2L2Entries)
+	l1Clusters := divRoundUp(l1Size*8, qcow2ClusterSize)
+
+	// header + refcount table + refcount blocks + L1 + L2 + data; the refcount blocks
+	// have to cover themselves, so keep adding blocks until everything is covered.
+	refcountBlocks := int64(1)
+	totalClusters := func() int64 {
+		return 2 + refcountBlocks + l1Clusters + l2Tables + dataClusters
+	}
+	for refcountBlocks*qcow2RefcountEntries < totalClusters() {
+		refcountBlocks++
+	}
+	if refcountBlocks > qcow2L2Entries {
+		return fmt.Errorf("disk size %d is too large for a qcow2 image", size)
+	}
+
+	refcountTableOffset := int64(qcow2ClusterSize)
+	refcountBlockOffset := refcountTableOffset + qcow2ClusterSize
+	l1Offset := refcountBlockOffset + refcountBlocks*qcow2ClusterSize
+	l2Offset := l1Offset + l1Clusters*qcow2ClusterSize
+	dataOffset := l2Offset + l2Tables*qcow2ClusterSize
+
+	header := make([]byte, qcow2ClusterSize)
+	be := binary.BigEndian
+	be.PutUint32(header[0:], qcow2Magic)
+	be.PutUint32(header[4:], qcow2Version)
+	be.PutUint32(header[20:], qcow2ClusterBits)
+	be.PutUint64(header[24:], uint64(size))
+	be.PutUint32(header[36:], uint32(l1Size))
+	be.PutUint64(header[40:], uint64(l1Offset))
+	be.PutUint64(header[48:], uint64(refcountTableOffset))
+	be.PutUint32(header[56:], 1)
+	be.PutUint32(header[96:], qcow2RefcountOrder)
+	be.PutUint32(header[100:], qcow2HeaderLength)
+	// The header extension area after header[104:] is terminated by an all-zero end marker.
+	if _, err := w.WriteAt(header, 0); err != nil {
+		return err
+	}
+
+	refcountTable := make([]byte, qcow2ClusterSize)
+	for i := int64(0); i < refcountBlocks; i++ {
+		be.PutUint64(refcountTable[i*8:], uint64(refcountBlockOffset+i*qcow2ClusterSize))
+	}
+	if _, err := w.WriteAt(refcountTable, refcountTableOffset); err != nil {
+		return err
+	}
+
+	refcounts := make([]byte, refcountBlocks*qcow2ClusterSize)
+	for i := int64(0); i < totalClusters(); i++ {
+		be.PutUint16(refcounts[i*2:], 1)
+	}
+	if _, err := w.WriteAt(refcounts, refcountBlockOffset); err != nil {
+		return err
+	}
+
+	l1 := make([]byte, l1Clusters*qcow2ClusterSize)
+	for i := int64(0); i < l2Tables; i++ {
+		be.PutUint64(l1[i*8:], uint64(l2Offset+i*qcow2ClusterSize)|qcow2OflagCopied)
+	}
+	if _, err := w.WriteAt(l1, l1Offset); err != nil {
+		return err
+	}
+
+	if l2Tables == 0 {
+		return nil
+	}
+	l2 := make([]byte, l2Tables*qcow2ClusterSize)
+	for i := int64(0); i < dataClusters; i++ {
+		be.PutUint64(l2[i*8:], uint64(dataOffset+i*qcow2ClusterSize)|qcow2OflagCopied)
+	}
+	if _, err := w.WriteAt(l2, l2Offset); err != nil {
+		return err
+	}
+
+	// Pad the data to a full cluster so the file ends on a cluster boundary.
+	padded := make([]byte, dataClusters*qcow2ClusterSize)
+	copy(padded, data)
+	_, err := w.WriteAt(padded, dataOffset)
+	return err
+}
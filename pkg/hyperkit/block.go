@@ -0,0 +1,95 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hyperkit
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	pkgdrivers "github.com/rancher-sandbox/docker-machine-driver-hyperkit/pkg/drivers"
+)
+
+// Managed blocks are sections of configuration files owned by the driver. They are delimited by
+// "# BEGIN: identifier" and "# END: identifier" lines, like the NFS exports in /etc/exports.
+
+func blockMarkers(identifier string) (string, string) {
+	return "# BEGIN: " + identifier, "# END: " + identifier
+}
+
+// setManagedBlock returns content with the block of identifier replaced by body. The block is
+// appended if it doesn't exist yet, and removed if body is empty. Lines outside of the block are kept;
+// an unterminated block extends to the end of the content.
+func setManagedBlock(content, identifier, body string) string {
+	begin, end := blockMarkers(identifier)
+	var lines []string
+	if content != "" {
+		lines = strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+	}
+
+	var result []string
+	inBlock, replaced := false, false
+	for _, line := range lines {
+		switch {
+		case !inBlock && line == begin:
+			inBlock = true
+		case inBlock && line == end:
+			inBlock = false
+			if body != "" && !replaced {
+				result = append(result, begin, body, end)
+				replaced = true
+			}
+		case !inBlock:
+			result = append(result, line)
+		}
+	}
+	if body != "" && !replaced {
+		result = append(result, begin, body, end)
+	}
+	if len(result) == 0 {
+		return ""
+	}
+	return strings.Join(result, "\n") + "\n"
+}
+
+// updateManagedBlock replaces the block of identifier in the file at path with body, or removes
+// the block if body is empty. A missing file is created with the given permissions.
+func updateManagedBlock(path, identifier, body string, perm os.FileMode) error {
+	if strings.Contains(body, "# END: "+identifier) {
+		return fmt.Errorf("block body must not contain its end marker")
+	}
+	// Update the target of a symlink instead of replacing the link
+	if target, err := filepath.EvalSymlinks(path); err == nil {
+		path = target
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		if info, err := os.Stat(path); err == nil {
+			perm = info.Mode().Perm()
+		}
+	}
+	content := setManagedBlock(string(data), identifier, strings.TrimSuffix(body, "\n"))
+	if content == string(data) {
+		return nil
+	}
+	return pkgdrivers.WriteFileAtomic(path, []byte(content), perm)
+}
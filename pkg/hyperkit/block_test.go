@@ -0,0 +1,80 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hyperkit
+
+import "testing"
+
+func Test_setManagedBlock(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		body    string
+		want    string
+	}{
+		{
+			name: "empty",
+			body: "one",
+			want: "# BEGIN: id\none\n# END: id\n",
+		},
+		{
+			name:    "append",
+			content: "other\n",
+			body:    "one\ntwo",
+			want:    "other\n# BEGIN: id\none\ntwo\n# END: id\n",
+		},
+		{
+			name:    "replace in place",
+			content: "before\n# BEGIN: id\nold\n# END: id\nafter\n",
+			body:    "new",
+			want:    "before\n# BEGIN: id\nnew\n# END: id\nafter\n",
+		},
+		{
+			name:    "remove",
+			content: "before\n# BEGIN: id\nold\n# END: id\nafter",
+			want:    "before\nafter\n",
+		},
+		{
+			name:    "remove duplicates",
+			content: "# BEGIN: id\nold\n# END: id\n# BEGIN: id\nolder\n# END: id\n",
+			body:    "new",
+			want:    "# BEGIN: id\nnew\n# END: id\n",
+		},
+		{
+			name:    "other identifiers",
+			content: "# BEGIN: id2\nkeep\n# END: id2\n",
+			want:    "# BEGIN: id2\nkeep\n# END: id2\n",
+		},
+		{
+			name:    "unterminated",
+			content: "before\n# BEGIN: id\nold\n",
+			body:    "new",
+			want:    "before\n# BEGIN: id\nnew\n# END: id\n",
+		},
+		{
+			name:    "remove last block",
+			content: "# BEGIN: id\nold\n# END: id\n",
+			want:    "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := setManagedBlock(tt.content, "id", tt.body); got != tt.want {
+				t.Errorf("setManagedBlock() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,157 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hyperkit
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	pkgdrivers "github.com/rancher-sandbox/docker-machine-driver-hyperkit/pkg/drivers"
+)
+
+// BootptabPath is the bootpd configuration file with static IP address bindings
+const BootptabPath = "/etc/bootptab"
+
+// bootptabHeader separates the (unused) global options from the host entries
+const bootptabHeader = "%%"
+
+// BootptabEntry is a static binding of a MAC address to an IP address
+type BootptabEntry struct {
+	Name      string
+	HWAddress string
+	IPAddress string
+}
+
+// normalizeMacAddress returns mac in the format used by bootpd: lower case without leading zeros
+func normalizeMacAddress(mac string) (string, error) {
+	parts := strings.Split(mac, ":")
+	if len(parts) != 6 {
+		return "", fmt.Errorf("invalid MAC address %q", mac)
+	}
+	for i, part := range parts {
+		b, err := strconv.ParseUint(part, 16, 8)
+		if err != nil {
+			return "", fmt.Errorf("invalid MAC address %q", mac)
+		}
+		parts[i] = strconv.FormatUint(b, 16)
+	}
+	return strings.Join(parts, ":"), nil
+}
+
+// parseBootptabLine returns the entry defined by a line of the bootptab file, if any.
+// Host entries have the format "name htype hwaddr ipaddr".
+func parseBootptabLine(line string) (BootptabEntry, bool) {
+	fields := strings.Fields(line)
+	if len(fields) < 4 || strings.HasPrefix(fields[0], "#") || fields[1] != "1" {
+		return BootptabEntry{}, false
+	}
+	mac, err := normalizeMacAddress(fields[2])
+	if err != nil {
+		return BootptabEntry{}, false
+	}
+	return BootptabEntry{Name: fields[0], HWAddress: mac, IPAddress: fields[3]}, true
+}
+
+// updateBootptab rewrites the bootptab file with the lines returned by update. Lines that aren't
+// host entries are kept as they are. The file is replaced atomically because bootpd may read it anytime.
+func updateBootptab(path string, update func(lines []string) ([]string, error)) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	var lines []string
+	if len(data) > 0 {
+		lines = strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	}
+	hasHeader := false
+	for _, line := range lines {
+		if strings.TrimSpace(line) == bootptabHeader {
+			hasHeader = true
+		}
+	}
+	if !hasHeader {
+		lines = append(lines, bootptabHeader)
+	}
+
+	if lines, err = update(lines); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	for _, line := range lines {
+		buf.WriteString(line)
+		buf.WriteString("\n")
+	}
+	return pkgdrivers.WriteFileAtomic(path, buf.Bytes(), 0644)
+}
+
+// AddBootptabEntry binds mac to ip, replacing any existing binding of mac.
+// It fails if ip is bound to a different MAC address already.
+func AddBootptabEntry(path, name, mac, ip string) error {
+	mac, err := normalizeMacAddress(mac)
+	if err != nil {
+		return err
+	}
+	if net.ParseIP(ip).To4() == nil {
+		return fmt.Errorf("invalid IPv4 address %q", ip)
+	}
+	if name == "" || strings.ContainsAny(name, " \t\n#:") {
+		return fmt.Errorf("invalid host name %q", name)
+	}
+	return updateBootptab(path, func(lines []string) ([]string, error) {
+		var result []string
+		for _, line := range lines {
+			if entry, ok := parseBootptabLine(line); ok {
+				if entry.HWAddress == mac {
+					continue
+				}
+				if entry.IPAddress == ip {
+					return nil, fmt.Errorf("%s is already reserved for %s (%s)", ip, entry.Name, entry.HWAddress)
+				}
+			}
+			result = append(result, line)
+		}
+		return append(result, fmt.Sprintf("%s\t1\t%s\t%s", name, mac, ip)), nil
+	})
+}
+
+// RemoveBootptabEntries removes the bindings of all given MAC addresses
+func RemoveBootptabEntries(path string, macs ...string) error {
+	remove := map[string]bool{}
+	for _, mac := range macs {
+		mac, err := normalizeMacAddress(mac)
+		if err != nil {
+			return err
+		}
+		remove[mac] = true
+	}
+	return updateBootptab(path, func(lines []string) ([]string, error) {
+		var result []string
+		for _, line := range lines {
+			if entry, ok := parseBootptabLine(line); ok && remove[entry.HWAddress] {
+				continue
+			}
+			result = append(result, line)
+		}
+		return result, nil
+	})
+}
@@ -0,0 +1,78 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hyperkit
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBootptab(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "")
+	if err != nil {
+		t.Fatalf("TempDir() error = %v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	path := filepath.Join(tmpdir, "bootptab")
+	existing := "# managed by hand\n%%\n# name\thtype\thwaddr\tipaddr\nprinter\t1\t0a:00:00:00:00:01\t192.168.64.200\n"
+	if err := ioutil.WriteFile(path, []byte(existing), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	if err := AddBootptabEntry(path, "one", "AA:0B:00:00:00:01", "192.168.64.10"); err != nil {
+		t.Fatalf("AddBootptabEntry() error = %v", err)
+	}
+	// Replaces the previous reservation of the same MAC address
+	if err := AddBootptabEntry(path, "one", "aa:b:0:0:0:1", "192.168.64.11"); err != nil {
+		t.Fatalf("AddBootptabEntry() error = %v", err)
+	}
+	if err := AddBootptabEntry(path, "two", "aa:0b:00:00:00:02", "192.168.64.200"); err == nil {
+		t.Errorf("AddBootptabEntry() should fail for an address reserved by another MAC")
+	}
+	if err := AddBootptabEntry(path, "two words", "aa:0b:00:00:00:02", "192.168.64.12"); err == nil {
+		t.Errorf("AddBootptabEntry() should fail for an invalid host name")
+	}
+	if err := AddBootptabEntry(path, "two", "aa:0b:00:00:00:02", "192.168.64.12"); err != nil {
+		t.Fatalf("AddBootptabEntry() error = %v", err)
+	}
+
+	want := existing + "one\t1\taa:b:0:0:0:1\t192.168.64.11\ntwo\t1\taa:b:0:0:0:2\t192.168.64.12\n"
+	if got, _ := ioutil.ReadFile(path); string(got) != want {
+		t.Errorf("bootptab = %q, want %q", got, want)
+	}
+
+	if err := RemoveBootptabEntries(path, "aa:0b:00:00:00:01", "aa:0b:00:00:00:99"); err != nil {
+		t.Fatalf("RemoveBootptabEntries() error = %v", err)
+	}
+	want = existing + "two\t1\taa:b:0:0:0:2\t192.168.64.12\n"
+	if got, _ := ioutil.ReadFile(path); string(got) != want {
+		t.Errorf("bootptab = %q, want %q", got, want)
+	}
+
+	// A missing file is created with the header line
+	newPath := filepath.Join(tmpdir, "new")
+	if err := AddBootptabEntry(newPath, "one", "aa:0b:00:00:00:01", "192.168.64.10"); err != nil {
+		t.Fatalf("AddBootptabEntry() error = %v", err)
+	}
+	want = "%%\none\t1\taa:b:0:0:0:1\t192.168.64.10\n"
+	if got, _ := ioutil.ReadFile(newPath); string(got) != want {
+		t.Errorf("bootptab = %q, want %q", got, want)
+	}
+}
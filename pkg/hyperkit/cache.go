@@ -0,0 +1,245 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hyperkit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/docker/machine/libmachine/log"
+	"github.com/pkg/errors"
+	pkgdrivers "github.com/rancher-sandbox/docker-machine-driver-hyperkit/pkg/drivers"
+)
+
+const (
+	cacheISOFilename       = "boot2docker.iso"
+	cacheBootFilesFilename = "bootfiles.json"
+)
+
+var digestRegexp = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// BootCache stores ISO images and the boot files extracted from them once, keyed by the
+// SHA-256 digest of the ISO. Machines get hard links (or clones) of the cached files.
+type BootCache struct {
+	Dir string
+}
+
+// CacheEntry describes the cached files of a single ISO image
+type CacheEntry struct {
+	Digest   string
+	Size     int64
+	Modified time.Time
+}
+
+// NewBootCache returns the boot file cache of the given storage path
+func NewBootCache(storePath string) *BootCache {
+	return &BootCache{Dir: filepath.Join(storePath, "cache", "boot")}
+}
+
+func (c *BootCache) entryDir(digest string) string {
+	return filepath.Join(c.Dir, digest)
+}
+
+// AddISO stores the ISO at isoPath in the cache unless an identical image is cached already,
+// and replaces isoPath with a link to the cached copy. digest is the verified SHA-256 digest of
+// the image; it is computed if empty. It returns the digest of the image.
+func (c *BootCache) AddISO(isoPath, digest string) (string, error) {
+	if digest == "" {
+		var err error
+		if digest, err = pkgdrivers.FileSHA256(isoPath); err != nil {
+			return "", errors.Wrapf(err, "computing digest of %s", isoPath)
+		}
+	}
+	dir := c.entryDir(digest)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	cachedISO := filepath.Join(dir, cacheISOFilename)
+	if _, err := os.Stat(cachedISO); os.IsNotExist(err) {
+		log.Debugf("Adding %s to the boot cache as %s", isoPath, digest)
+		if err := pkgdrivers.LinkFile(isoPath, cachedISO); err != nil {
+			return "", errors.Wrap(err, "adding iso to cache")
+		}
+	}
+	if err := pkgdrivers.LinkFile(cachedISO, isoPath); err != nil {
+		return "", errors.Wrap(err, "linking cached iso")
+	}
+	return digest, nil
+}
+
+// BootFiles returns the boot files of the cached ISO image with the given digest, extracting
+// them from the image the first time they are requested. The returned paths point into the cache.
+func (c *BootCache) BootFiles(digest string) (ISOBootFiles, error) {
+	var files ISOBootFiles
+	dir := c.entryDir(digest)
+	indexPath := filepath.Join(dir, cacheBootFilesFilename)
+
+	data, err := ioutil.ReadFile(indexPath)
+	if err == nil {
+		if err := json.Unmarshal(data, &files); err != nil {
+			return files, errors.Wrapf(err, "reading %s", indexPath)
+		}
+		return files.inDir(dir), nil
+	}
+	if !os.IsNotExist(err) {
+		return files, err
+	}
+
+	// Extract into a private directory first, so that concurrent extractions of the
+	// same image don't see partially written files.
+	tmpDir, err := ioutil.TempDir(dir, ".extract-")
+	if err != nil {
+		return files, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	extracted, err := ISOExtractBootFiles(filepath.Join(dir, cacheISOFilename), tmpDir)
+	if err != nil {
+		return files, errors.Wrap(err, "extracting boot files")
+	}
+	files = extracted.baseNames()
+	for _, name := range files.names() {
+		if err := os.Rename(filepath.Join(tmpDir, name), filepath.Join(dir, name)); err != nil {
+			return files, err
+		}
+	}
+	if data, err = json.Marshal(files); err != nil {
+		return files, err
+	}
+	tmpIndex := filepath.Join(tmpDir, cacheBootFilesFilename)
+	if err := ioutil.WriteFile(tmpIndex, data, 0644); err != nil {
+		return files, err
+	}
+	if err := os.Rename(tmpIndex, indexPath); err != nil {
+		return files, err
+	}
+	return files.inDir(dir), nil
+}
+
+// LinkBootFiles links the cached boot files of the given ISO image into destDir and
+// returns their paths in destDir.
+func (c *BootCache) LinkBootFiles(digest, destDir string) (ISOBootFiles, error) {
+	files, err := c.BootFiles(digest)
+	if err != nil {
+		return files, err
+	}
+	for _, name := range files.baseNames().names() {
+		if err := pkgdrivers.LinkFile(filepath.Join(c.entryDir(digest), name), filepath.Join(destDir, name)); err != nil {
+			return files, errors.Wrapf(err, "linking cached %s", name)
+		}
+	}
+	return files.baseNames().inDir(destDir), nil
+}
+
+// List returns all cache entries, sorted by digest
+func (c *BootCache) List() ([]CacheEntry, error) {
+	dirs, err := ioutil.ReadDir(c.Dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []CacheEntry
+	for _, dir := range dirs {
+		if !dir.IsDir() || !digestRegexp.MatchString(dir.Name()) {
+			continue
+		}
+		entry := CacheEntry{Digest: dir.Name(), Modified: dir.ModTime()}
+		files, err := ioutil.ReadDir(c.entryDir(dir.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			if f.Mode().IsRegular() {
+				entry.Size += f.Size()
+			}
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Digest < entries[j].Digest })
+	return entries, nil
+}
+
+// Prune removes all cache entries whose digest is not in use and returns the removed entries.
+// Machines keep working after their entry has been pruned, because they hold their own links.
+func (c *BootCache) Prune(inUse map[string]bool) ([]CacheEntry, error) {
+	entries, err := c.List()
+	if err != nil {
+		return nil, err
+	}
+	var pruned []CacheEntry
+	for _, entry := range entries {
+		if inUse[entry.Digest] {
+			continue
+		}
+		if err := os.RemoveAll(c.entryDir(entry.Digest)); err != nil {
+			return pruned, fmt.Errorf("removing cache entry %s: %v", entry.Digest, err)
+		}
+		pruned = append(pruned, entry)
+	}
+	return pruned, nil
+}
+
+// baseNames returns a copy of the boot files with all paths reduced to their file names
+func (f ISOBootFiles) baseNames() ISOBootFiles {
+	base := func(path string) string {
+		if path == "" {
+			return ""
+		}
+		return filepath.Base(path)
+	}
+	return ISOBootFiles{
+		InitrdPath:      base(f.InitrdPath),
+		KernelPath:      base(f.KernelPath),
+		IsoLinuxCfgPath: base(f.IsoLinuxCfgPath),
+		Cmdline:         f.Cmdline,
+	}
+}
+
+// inDir returns a copy of the boot files with all file names resolved relative to dir
+func (f ISOBootFiles) inDir(dir string) ISOBootFiles {
+	join := func(name string) string {
+		if name == "" {
+			return ""
+		}
+		return filepath.Join(dir, name)
+	}
+	return ISOBootFiles{
+		InitrdPath:      join(f.InitrdPath),
+		KernelPath:      join(f.KernelPath),
+		IsoLinuxCfgPath: join(f.IsoLinuxCfgPath),
+		Cmdline:         f.Cmdline,
+	}
+}
+
+// names returns the non-empty file names of the boot files
+func (f ISOBootFiles) names() []string {
+	var names []string
+	for _, name := range []string{f.KernelPath, f.InitrdPath, f.IsoLinuxCfgPath} {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,102 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hyperkit
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+	pkgdrivers "github.com/rancher-sandbox/docker-machine-driver-hyperkit/pkg/drivers"
+)
+
+func TestBootCache(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "")
+	if err != nil {
+		t.Fatalf("TempDir() error = %v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	cache := NewBootCache(tmpdir)
+	var isoPaths []string
+	for _, machine := range []string{"one", "two"} {
+		dir := filepath.Join(tmpdir, "machines", machine)
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			t.Fatalf("MkdirAll() error = %v", err)
+		}
+		isoPath := filepath.Join(dir, cacheISOFilename)
+		if err := pkgdrivers.CloneFile("iso_test.iso", isoPath); err != nil {
+			t.Fatalf("CloneFile() error = %v", err)
+		}
+		isoPaths = append(isoPaths, isoPath)
+	}
+
+	want, err := pkgdrivers.FileSHA256("iso_test.iso")
+	if err != nil {
+		t.Fatalf("FileSHA256() error = %v", err)
+	}
+	// The first ISO is hashed by AddISO, the second one was verified already
+	for i, isoPath := range isoPaths {
+		verified := ""
+		if i > 0 {
+			verified = want
+		}
+		digest, err := cache.AddISO(isoPath, verified)
+		if err != nil {
+			t.Fatalf("AddISO() error = %v", err)
+		}
+		if digest != want {
+			t.Errorf("AddISO() digest = %s, want %s", digest, want)
+		}
+	}
+	fi1, err1 := os.Stat(isoPaths[0])
+	fi2, err2 := os.Stat(isoPaths[1])
+	if err1 != nil || err2 != nil {
+		t.Fatalf("Stat() errors = %v, %v", err1, err2)
+	}
+	if !os.SameFile(fi1, fi2) {
+		t.Errorf("machine ISOs are not linked to the same cached file")
+	}
+
+	// The test ISO doesn't contain a kernel
+	_, err = cache.LinkBootFiles(want, filepath.Dir(isoPaths[0]))
+	if _, ok := errors.Cause(err).(*MissingBootFileError); !ok {
+		t.Errorf("LinkBootFiles() error = %v, want a *MissingBootFileError", err)
+	}
+
+	entries, err := cache.List()
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(entries) != 1 || entries[0].Digest != want || entries[0].Size < fi1.Size() {
+		t.Errorf("List() = %+v, want a single entry for %s", entries, want)
+	}
+
+	pruned, err := cache.Prune(map[string]bool{want: true})
+	if err != nil || len(pruned) != 0 {
+		t.Errorf("Prune() = %+v, %v; want nothing pruned while in use", pruned, err)
+	}
+	pruned, err = cache.Prune(nil)
+	if err != nil || len(pruned) != 1 {
+		t.Errorf("Prune() = %+v, %v; want the unused entry pruned", pruned, err)
+	}
+	if _, err := os.Stat(isoPaths[0]); err != nil {
+		t.Errorf("machine ISO is gone after pruning: %v", err)
+	}
+}
@@ -0,0 +1,135 @@
+// +build darwin
+
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hyperkit
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/docker/machine/libmachine/log"
+	"github.com/docker/machine/libmachine/ssh"
+	"github.com/docker/machine/libmachine/state"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	pkgdrivers "github.com/rancher-sandbox/docker-machine-driver-hyperkit/pkg/drivers"
+)
+
+// replaceSSHKeyScript installs a new authorized key for the docker user. boot2docker restores
+// the home directory from userdata.tar on every boot, so the tarball has to be updated as well.
+const replaceSSHKeyScript = `set -e
+mkdir -p ~/.ssh
+echo '%s' > ~/.ssh/authorized_keys.new
+chmod 600 ~/.ssh/authorized_keys.new
+mv ~/.ssh/authorized_keys.new ~/.ssh/authorized_keys
+if [ -f /var/lib/boot2docker/userdata.tar ]; then
+	sudo tar cf /var/lib/boot2docker/userdata.tar -C ~ .ssh
+fi
+`
+
+// NewClone returns the driver for a new machine called machineName with the same settings as d.
+// The clone gets a fresh UUID, so vmnet will assign it a different MAC (and IP) address.
+func (d *Driver) NewClone(machineName string) *Driver {
+	clone := *d
+	base := *d.BaseDriver
+	clone.BaseDriver = &base
+	clone.MachineName = machineName
+	clone.IPAddress = ""
+	clone.SSHKeyPath = ""
+	clone.StaticIP = ""
+	// Host ports can't be shared with the original machine
+	clone.Publish = nil
+	if d.network() == NetworkVPNKit {
+		clone.SSHPort = 0
+		clone.DockerPort = 0
+	}
+	clone.UUID = uuid.New().String()
+	clone.BootKernel = clone.ResolveStorePath(filepath.Base(d.BootKernel))
+	if d.BootInitrd != "" {
+		clone.BootInitrd = clone.ResolveStorePath(filepath.Base(d.BootInitrd))
+	}
+	return &clone
+}
+
+// CopyMachineFiles copies the disks, ISO and boot files of the stopped machine src into the
+// machine directory of d, and creates a new SSH key for d. The guest will only accept the SSH
+// key of src until ReplaceSSHKey has been called, so d uses that key in the meantime.
+func (d *Driver) CopyMachineFiles(src *Driver) error {
+	st, err := src.GetState()
+	if err != nil {
+		return errors.Wrap(err, "get state")
+	}
+	if st == state.Running {
+		return fmt.Errorf("machine %s must be stopped before it can be cloned", src.MachineName)
+	}
+
+	if err := os.MkdirAll(d.ResolveStorePath("."), 0700); err != nil {
+		return err
+	}
+
+	files := [][2]string{
+		{pkgdrivers.GetDiskPath(src.BaseDriver, src.DiskFormat), pkgdrivers.GetDiskPath(d.BaseDriver, d.DiskFormat)},
+		{src.BootKernel, d.BootKernel},
+	}
+	if src.BootInitrd != "" {
+		files = append(files, [2]string{src.BootInitrd, d.BootInitrd})
+	}
+	if !src.NoISO {
+		files = append(files, [2]string{src.ResolveStorePath(isoFilename), d.ResolveStorePath(isoFilename)})
+	}
+	for _, disk := range src.ExtraDisks {
+		files = append(files, [2]string{src.dataDiskPath(disk), d.dataDiskPath(disk)})
+	}
+	for _, file := range files {
+		log.Infof("Copying %s to %s...", file[0], file[1])
+		if err := pkgdrivers.CloneFile(file[0], file[1]); err != nil {
+			return errors.Wrapf(err, "copying %s", file[0])
+		}
+	}
+
+	keyPath := d.ResolveStorePath("id_rsa")
+	log.Infof("Creating ssh key: %s...", keyPath)
+	if err := ssh.GenerateSSHKey(keyPath); err != nil {
+		return errors.Wrap(err, "generate ssh key")
+	}
+	d.SSHKeyPath = src.GetSSHKeyPath()
+	return nil
+}
+
+// ReplaceSSHKey authorizes the SSH key of the machine inside the running guest, replacing the key
+// of the machine it has been cloned from, and switches the driver over to using its own key.
+func (d *Driver) ReplaceSSHKey() error {
+	keyPath := d.ResolveStorePath("id_rsa")
+	pubKey, err := ioutil.ReadFile(keyPath + ".pub")
+	if err != nil {
+		return err
+	}
+	if err := drivers.WaitForSSH(d); err != nil {
+		return err
+	}
+	script := fmt.Sprintf(replaceSSHKeyScript, strings.TrimSpace(string(pubKey)))
+	if _, err := drivers.RunSSHCommandFromDriver(d, script); err != nil {
+		return errors.Wrap(err, "replacing authorized ssh key")
+	}
+	d.SSHKeyPath = keyPath
+	return nil
+}
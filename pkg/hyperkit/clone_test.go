@@ -0,0 +1,62 @@
+// +build darwin
+
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hyperkit
+
+import (
+	"testing"
+
+	"github.com/docker/machine/libmachine/drivers"
+)
+
+func TestNewClone(t *testing.T) {
+	d := NewDriver("", "")
+	d.BaseDriver = &drivers.BaseDriver{
+		MachineName: "source",
+		StorePath:   "/store",
+		IPAddress:   "192.168.64.2",
+		SSHKeyPath:  "/store/machines/source/id_rsa",
+	}
+	d.UUID = "2ba4ac7e-a86c-4c0b-8ff4-5e8a1c4e5d1e"
+	d.BootKernel = "/store/machines/source/bzImage"
+	d.BootInitrd = "/store/machines/source/initrd.img"
+	d.Memory = 8192
+
+	clone := d.NewClone("worker")
+	if clone.MachineName != "worker" || d.MachineName != "source" {
+		t.Errorf("machine names are %q and %q, want %q and %q", clone.MachineName, d.MachineName, "worker", "source")
+	}
+	if clone.UUID == "" || clone.UUID == d.UUID {
+		t.Errorf("clone UUID is %q, want a fresh UUID", clone.UUID)
+	}
+	if clone.IPAddress != "" {
+		t.Errorf("clone IP address is %q, want it to be empty", clone.IPAddress)
+	}
+	if got, want := clone.GetSSHKeyPath(), "/store/machines/worker/id_rsa"; got != want {
+		t.Errorf("clone ssh key path is %q, want %q", got, want)
+	}
+	if want := "/store/machines/worker/bzImage"; clone.BootKernel != want {
+		t.Errorf("clone kernel is %q, want %q", clone.BootKernel, want)
+	}
+	if want := "/store/machines/worker/initrd.img"; clone.BootInitrd != want {
+		t.Errorf("clone initrd is %q, want %q", clone.BootInitrd, want)
+	}
+	if clone.Memory != d.Memory {
+		t.Errorf("clone memory is %d, want %d", clone.Memory, d.Memory)
+	}
+}
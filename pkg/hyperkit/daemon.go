@@ -0,0 +1,168 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hyperkit
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/docker/machine/libmachine/drivers/plugin/localbinary"
+	"github.com/docker/machine/libmachine/log"
+	ps "github.com/mitchellh/go-ps"
+	"github.com/pkg/errors"
+)
+
+const (
+	// daemonStartupGrace is how long a daemon must keep running to be considered started successfully
+	daemonStartupGrace = time.Second
+	// daemonStopTimeout is how long to wait for a daemon to exit, e.g. while sshfs-server unmounts the shares
+	daemonStopTimeout = 10 * time.Second
+	// maxProcessNameLen is the length that macOS truncates process names to (MAXCOMLEN)
+	maxProcessNameLen = 16
+)
+
+// startDaemon runs the driver executable with args as a detached background process that outlives
+// the driver. Its pid is written to pidFile and its output is appended to logFile. A daemon that is
+// still running from a previous pidFile is stopped first.
+func startDaemon(pidFile, logFile string, args ...string) error {
+	if err := stopDaemon(pidFile); err != nil {
+		return err
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	cmd := exec.Command(executable, args...)
+	cmd.Env = daemonEnv()
+	cmd.Stdout = out
+	cmd.Stderr = out
+	// Start a new session, so that the daemon doesn't get the signals sent to the driver's process group
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	log.Debugf("Starting daemon: %s", cmd)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	select {
+	case err := <-exited:
+		output, _ := ioutil.ReadFile(logFile)
+		return fmt.Errorf("%s exited right away: %v\n%s", args[0], err, lastLines(string(output), 10))
+	case <-time.After(daemonStartupGrace):
+	}
+	return ioutil.WriteFile(pidFile, []byte(strconv.Itoa(cmd.Process.Pid)), 0644)
+}
+
+// daemonEnv returns the environment of the driver without the docker-machine plugin variables.
+// The daemon would otherwise run as a driver plugin server instead of running its command.
+func daemonEnv() []string {
+	var env []string
+	for _, kv := range os.Environ() {
+		if strings.HasPrefix(kv, localbinary.PluginEnvKey+"=") || strings.HasPrefix(kv, localbinary.PluginEnvDriverName+"=") {
+			continue
+		}
+		env = append(env, kv)
+	}
+	return env
+}
+
+// stopDaemon terminates the daemon whose pid is stored in pidFile, waits for it to exit and removes
+// the file. It is not an error if there is no pidFile or the daemon isn't running anymore.
+func stopDaemon(pidFile string) error {
+	data, err := ioutil.ReadFile(pidFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return errors.Wrapf(err, "parsing pid file %s", pidFile)
+	}
+	if !isDaemon(pid) {
+		log.Debugf("Removing stale pid file %s", pidFile)
+		return os.Remove(pidFile)
+	}
+	log.Debugf("Stopping daemon with pid %d", pid)
+	if err := syscall.Kill(pid, syscall.SIGTERM); err != nil && err != syscall.ESRCH {
+		return errors.Wrapf(err, "stopping daemon with pid %d", pid)
+	}
+	if !waitForExit(pid, daemonStopTimeout) {
+		log.Warnf("Daemon with pid %d is still running after %s", pid, daemonStopTimeout)
+	}
+	return os.Remove(pidFile)
+}
+
+// isDaemon reports whether pid is a process of the driver executable. Pid files go stale when
+// the host restarts, and their pids may have been reused by unrelated processes since.
+func isDaemon(pid int) bool {
+	p, err := ps.FindProcess(pid)
+	if err != nil || p == nil {
+		return false
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return false
+	}
+	return p.Executable() == processName(executable)
+}
+
+// processName returns the name that the process table shows for a process of executable
+func processName(executable string) string {
+	name := filepath.Base(executable)
+	if len(name) > maxProcessNameLen {
+		name = name[:maxProcessNameLen]
+	}
+	return name
+}
+
+// waitForExit waits up to timeout for the process pid to exit and reports whether it did
+func waitForExit(pid int, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		if err := syscall.Kill(pid, 0); err == syscall.ESRCH {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// lastLines returns the last n lines of text
+func lastLines(text string, n int) string {
+	lines := strings.Split(strings.TrimRight(text, "\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,177 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hyperkit
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/docker/machine/libmachine/drivers/plugin/localbinary"
+)
+
+func Test_stopDaemon(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "daemon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	pidFile := filepath.Join(tmpdir, "daemon.pid")
+
+	if err := stopDaemon(pidFile); err != nil {
+		t.Errorf("stopDaemon() without pid file error = %v", err)
+	}
+
+	// The daemon is the test executable, like the driver executable runs its daemons
+	cmd := exec.Command(os.Args[0], "-test.run=^TestDaemonHelperProcess$")
+	cmd.Env = append(os.Environ(), "HYPERKIT_TEST_DAEMON=1")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	if err := ioutil.WriteFile(pidFile, []byte(strconv.Itoa(cmd.Process.Pid)), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := stopDaemon(pidFile); err != nil {
+		t.Fatalf("stopDaemon() error = %v", err)
+	}
+	// stopDaemon waits for the daemon to exit
+	select {
+	case <-exited:
+	case <-time.After(time.Second):
+		cmd.Process.Kill()
+		t.Fatal("daemon is still running")
+	}
+	if _, err := os.Stat(pidFile); !os.IsNotExist(err) {
+		t.Errorf("pid file still exists: %v", err)
+	}
+
+	// A stale pid file of a process that doesn't exist anymore is removed
+	if err := ioutil.WriteFile(pidFile, []byte("999999999"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := stopDaemon(pidFile); err != nil {
+		t.Errorf("stopDaemon() with stale pid file error = %v", err)
+	}
+
+	// A pid that has been reused by an unrelated process isn't signalled
+	other := exec.Command("sleep", "60")
+	if err := other.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer other.Process.Kill()
+	otherExited := make(chan error, 1)
+	go func() { otherExited <- other.Wait() }()
+	if err := ioutil.WriteFile(pidFile, []byte(strconv.Itoa(other.Process.Pid)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := stopDaemon(pidFile); err != nil {
+		t.Errorf("stopDaemon() with reused pid error = %v", err)
+	}
+	select {
+	case err := <-otherExited:
+		t.Errorf("unrelated process was stopped: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+	if _, err := os.Stat(pidFile); !os.IsNotExist(err) {
+		t.Errorf("stale pid file still exists: %v", err)
+	}
+
+	// Nor is a process whose name is only a prefix of the driver executable name
+	sleep, err := exec.LookPath("sleep")
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefix := filepath.Join(tmpdir, processName(os.Args[0])[:5])
+	if err := os.Symlink(sleep, prefix); err != nil {
+		t.Fatal(err)
+	}
+	other = exec.Command(prefix, "60")
+	if err := other.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer other.Process.Kill()
+	otherExited = make(chan error, 1)
+	go func() { otherExited <- other.Wait() }()
+	if err := ioutil.WriteFile(pidFile, []byte(strconv.Itoa(other.Process.Pid)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := stopDaemon(pidFile); err != nil {
+		t.Errorf("stopDaemon() with reused pid error = %v", err)
+	}
+	select {
+	case err := <-otherExited:
+		t.Errorf("process with a prefix of the driver name was stopped: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func Test_startDaemon(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "daemon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	pidFile := filepath.Join(tmpdir, "daemon.pid")
+	logFile := filepath.Join(tmpdir, "daemon.log")
+
+	// The driver runs with the plugin variables when docker-machine starts it, but its daemons
+	// must run their command instead of a plugin server
+	for key, value := range map[string]string{
+		"HYPERKIT_TEST_DAEMON":          "1",
+		localbinary.PluginEnvKey:        localbinary.PluginEnvVal,
+		localbinary.PluginEnvDriverName: "hyperkit",
+	} {
+		os.Setenv(key, value)
+		defer os.Unsetenv(key)
+	}
+
+	if err := startDaemon(pidFile, logFile, "-test.run=^TestDaemonHelperProcess$"); err != nil {
+		t.Fatalf("startDaemon() error = %v", err)
+	}
+	if err := stopDaemon(pidFile); err != nil {
+		t.Errorf("stopDaemon() error = %v", err)
+	}
+}
+
+// TestDaemonHelperProcess is the daemon of Test_stopDaemon and Test_startDaemon. It exits right
+// away if it got the plugin variables of the driver.
+func TestDaemonHelperProcess(t *testing.T) {
+	if os.Getenv("HYPERKIT_TEST_DAEMON") != "1" {
+		return
+	}
+	if os.Getenv(localbinary.PluginEnvKey) != "" {
+		os.Exit(1)
+	}
+	time.Sleep(60 * time.Second)
+	os.Exit(0)
+}
+
+func Test_lastLines(t *testing.T) {
+	if got, want := lastLines("1\n2\n3\n", 2), "2\n3"; got != want {
+		t.Errorf("lastLines() = %q, want %q", got, want)
+	}
+	if got, want := lastLines("1\n2", 5), "1\n2"; got != want {
+		t.Errorf("lastLines() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,170 @@
+// +build darwin
+
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hyperkit
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/docker/machine/libmachine/log"
+	"github.com/docker/machine/libmachine/state"
+	"github.com/pkg/errors"
+	pkgdrivers "github.com/rancher-sandbox/docker-machine-driver-hyperkit/pkg/drivers"
+)
+
+var dataDiskNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_-]*$`)
+
+// DataDisk is an additional disk attached to the machine
+type DataDisk struct {
+	Name string
+	Size int // in MB
+}
+
+// ParseDataDisk parses a "name:sizeMB" data disk specification
+func ParseDataDisk(spec string) (DataDisk, error) {
+	parts := strings.Split(spec, ":")
+	if len(parts) != 2 {
+		return DataDisk{}, fmt.Errorf("data disk %q must be specified as name:sizeMB", spec)
+	}
+	if !dataDiskNameRegexp.MatchString(parts[0]) {
+		return DataDisk{}, fmt.Errorf("invalid data disk name %q", parts[0])
+	}
+	size, err := strconv.Atoi(parts[1])
+	if err != nil || size <= 0 {
+		return DataDisk{}, fmt.Errorf("invalid size for data disk %q: %q", parts[0], parts[1])
+	}
+	return DataDisk{Name: parts[0], Size: size}, nil
+}
+
+func (d *Driver) dataDiskPath(disk DataDisk) string {
+	return pkgdrivers.GetDataDiskPath(d.BaseDriver, disk.Name, d.DiskFormat)
+}
+
+// createDataDisks creates all data disk images that don't exist yet
+func (d *Driver) createDataDisks() error {
+	for _, disk := range d.ExtraDisks {
+		path := d.dataDiskPath(disk)
+		if _, err := os.Stat(path); err == nil {
+			continue
+		}
+		log.Infof("Creating %s data disk image: %s...", d.DiskFormat, path)
+		if err := pkgdrivers.CreateEmptyDiskImage(path, disk.Size, d.DiskFormat); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeDataDisks deletes all data disk images
+func (d *Driver) removeDataDisks() error {
+	for _, disk := range d.ExtraDisks {
+		if err := os.Remove(d.dataDiskPath(disk)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// growFilesystemScript grows the last partition of the boot2docker data disk (partition 1;
+// the swap partition comes first) and the ext4 filesystem on it to the size of the disk.
+// It fails when the kernel doesn't see the new disk size yet, because hyperkit doesn't
+// notify the guest about capacity changes of a running virtio-blk device.
+const growFilesystemScript = `set -e
+DISK=vda
+SIZE_MB=%d
+if [ $(( $(cat /sys/block/$DISK/size) / 2048 )) -lt $SIZE_MB ]; then
+	echo "/dev/$DISK is still smaller than ${SIZE_MB}MB; the machine must be restarted first" >&2
+	exit 1
+fi
+if command -v growpart >/dev/null 2>&1; then
+	sudo growpart /dev/$DISK 1 || [ $? -eq 1 ]
+elif command -v sfdisk >/dev/null 2>&1; then
+	echo ', +' | sudo sfdisk --no-reread -N 1 /dev/$DISK
+	sudo partx -u /dev/$DISK
+else
+	echo "neither growpart nor sfdisk are available to grow /dev/${DISK}1" >&2
+	exit 1
+fi
+sudo resize2fs /dev/${DISK}1
+`
+
+// ResizeDisk grows the machine disk image to size MB. Disks cannot be shrunk.
+// If the machine is running, the partition and filesystem are grown right away;
+// otherwise (or if the guest cannot see the new size yet) this happens on the next Start.
+func (d *Driver) ResizeDisk(size int) error {
+	st, err := d.GetState()
+	if err != nil {
+		return errors.Wrap(err, "get state")
+	}
+
+	disk, err := d.newDisk(pkgdrivers.GetDiskPath(d.BaseDriver, d.DiskFormat), size)
+	if err != nil {
+		return err
+	}
+	current, err := disk.GetCurrentSize()
+	if err != nil {
+		return errors.Wrapf(err, "getting size of %s", disk)
+	}
+	if size < current {
+		return fmt.Errorf("cannot shrink disk %s from %d MB to %d MB", disk, current, size)
+	}
+	if size == current {
+		log.Infof("Disk %s already has a size of %d MB", disk, size)
+		return nil
+	}
+	if st == state.Running && d.DiskFormat == pkgdrivers.DiskFormatQcow2 {
+		// qcow-tool rewrites the image metadata, which would race with the running hyperkit
+		return fmt.Errorf("qcow2 disk %s can only be resized while the machine is stopped", disk)
+	}
+
+	log.Infof("Resizing disk %s from %d MB to %d MB", disk, current, size)
+	if err := disk.Ensure(); err != nil {
+		return errors.Wrapf(err, "resizing %s", disk)
+	}
+	d.DiskSize = size
+	d.GrowFilesystem = true
+
+	if st == state.Running {
+		if err := d.growFilesystem(); err != nil {
+			log.Warnf("Could not grow the filesystem of the running machine, will retry on next start: %v", err)
+		}
+	}
+	return nil
+}
+
+// growFilesystem grows the guest partition and filesystem to the size of the disk,
+// if the disk has been resized since the filesystem was last grown.
+func (d *Driver) growFilesystem() error {
+	if !d.GrowFilesystem {
+		return nil
+	}
+	log.Info("Growing guest filesystem")
+	if err := drivers.WaitForSSH(d); err != nil {
+		return err
+	}
+	if _, err := drivers.RunSSHCommandFromDriver(d, fmt.Sprintf(growFilesystemScript, d.DiskSize)); err != nil {
+		return err
+	}
+	d.GrowFilesystem = false
+	return nil
+}
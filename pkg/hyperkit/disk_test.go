@@ -0,0 +1,52 @@
+// +build darwin
+
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hyperkit
+
+import (
+	"testing"
+)
+
+func TestParseDataDisk(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    DataDisk
+		wantErr bool
+	}{
+		{"docker:20000", DataDisk{Name: "docker", Size: 20000}, false},
+		{"data_1:1", DataDisk{Name: "data_1", Size: 1}, false},
+		{"docker", DataDisk{}, true},
+		{"docker:20000:1", DataDisk{}, true},
+		{"../docker:20000", DataDisk{}, true},
+		{":20000", DataDisk{}, true},
+		{"docker:big", DataDisk{}, true},
+		{"docker:0", DataDisk{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := ParseDataDisk(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseDataDisk() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("ParseDataDisk() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -22,11 +22,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"os"
 	"os/exec"
 	"os/user"
-	"path"
-	"path/filepath"
 	"strconv"
 	"strings"
 	"syscall"
@@ -48,13 +47,28 @@ const (
 	isoFilename     = "boot2docker.iso"
 	pidFileName     = "hyperkit.pid"
 	machineFileName = "hyperkit.json"
-
-	defaultCPUs     = 1
-	defaultDiskSize = 20000
-	defaultMemory   = 1024
-	defaultSSHUser  = "docker"
+	// The user-provided boot files are copied to fixed names, so that they can't replace each other
+	// or other files of the machine
+	kernelFileName = "kernel"
+	initrdFileName = "initrd"
+
+	portForwardPidFileName = "port-forward.pid"
+	portForwardLogFileName = "port-forward.log"
+
+	defaultCPUs      = 1
+	defaultDiskSize  = 20000
+	defaultIPTimeout = 60 // seconds
+	defaultMemory    = 1024
+	defaultSSHUser   = "docker"
 )
 
+// TODO(jandubois) these boot options are a subset of what minikube uses right now
+// TODO audit the settings and document why each one is being used!
+// The "noembed" option is required on boot2docker.iso (TinyCoreLinux) to make sure
+// the system doesn't run out of a ramdisk; otherwise pivot_root will fail.
+// It is only used when the ISO doesn't provide a command line in its boot config.
+var defaultCmdline = "loglevel=3 console=ttyS0 console=tty0 noembed nomodeset norestore random.trust_cpu=on hw_rng_model=virtio base"
+
 // Driver is the machine driver for Hyperkit
 type Driver struct {
 	*drivers.BaseDriver
@@ -62,13 +76,30 @@ type Driver struct {
 	Boot2DockerURL string
 	BootInitrd     string
 	BootKernel     string
+	BootMounts     bool
 	CPU            int
 	Cmdline        string
+	DiskFormat     string
 	DiskSize       int
+	DockerPort     int
+	ExtraDisks     []DataDisk
+	ExtraNICs      []NIC
+	GrowFilesystem bool
+	GuestVSock     bool
+	HostsEntry     bool
 	Hyperkit       string
+	ISOSHA256      string
+	IPTimeout      int
+	Initrd         string
+	Kernel         string
 	Memory         int
 	NFSShares      []string
 	NFSSharesRoot  string
+	Network        string
+	NoISO          bool
+	Publish        []string
+	ShareType      string
+	StaticIP       string
 	UUID           string
 	VSockPorts     []string
 	VpnKitSock     string
@@ -79,6 +110,7 @@ func NewDriver(machineName, storePath string) *Driver {
 	return &Driver{
 		// Don't init BaseDriver values here. They are overwritten by API .SetConfigRaw() call.
 		CommonDriver: &pkgdrivers.CommonDriver{},
+		DiskFormat:   pkgdrivers.DiskFormatRaw,
 		DiskSize:     defaultDiskSize,
 	}
 }
@@ -99,18 +131,99 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 			Usage:  "Number of CPUs for the host.",
 			Value:  defaultCPUs,
 		},
+		mcnflag.StringSliceFlag{
+			EnvVar: "HYPERKIT_DATA_DISK",
+			Name:   "hyperkit-data-disk",
+			Usage:  "Additional disk as name:sizeMB (repeatable).",
+			Value:  []string{},
+		},
+		mcnflag.StringFlag{
+			EnvVar: "HYPERKIT_DISK_FORMAT",
+			Name:   "hyperkit-disk-format",
+			Usage:  "Format of the disk image for host (raw or qcow2).",
+			Value:  pkgdrivers.DiskFormatRaw,
+		},
 		mcnflag.IntFlag{
 			EnvVar: "HYPERKIT_DISK_SIZE",
 			Name:   "hyperkit-disk-size",
 			Usage:  "Size of disk for host in MB.",
 			Value:  defaultDiskSize,
 		},
+		mcnflag.BoolFlag{
+			EnvVar: "HYPERKIT_GUEST_VSOCK",
+			Name:   "hyperkit-guest-vsock",
+			Usage:  "The guest accepts SSH and Docker connections on vsock ports 22 and 2376, as required by --hyperkit-network vpnkit.",
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "HYPERKIT_HOSTS_ENTRY",
+			Name:   "hyperkit-hosts-entry",
+			Usage:  "Map <name>.hyperkit.local to the host IP address in /etc/hosts.",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "HYPERKIT_INITRD",
+			Name:   "hyperkit-initrd",
+			Usage:  "Path of an initrd to boot instead of the one from the boot2docker image.",
+			Value:  "",
+		},
+		mcnflag.IntFlag{
+			EnvVar: "HYPERKIT_IP_TIMEOUT",
+			Name:   "hyperkit-ip-timeout",
+			Usage:  "Seconds to wait for the host to get an IP address.",
+			Value:  defaultIPTimeout,
+		},
+		mcnflag.StringFlag{
+			EnvVar: "HYPERKIT_ISO_SHA256",
+			Name:   "hyperkit-iso-sha256",
+			Usage:  "Expected SHA-256 digest of the boot2docker image. Defaults to the content of the URL with a .sha256 suffix, if it exists.",
+			Value:  "",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "HYPERKIT_KERNEL",
+			Name:   "hyperkit-kernel",
+			Usage:  "Path of a kernel to boot instead of the one from the boot2docker image.",
+			Value:  "",
+		},
 		mcnflag.IntFlag{
 			EnvVar: "HYPERKIT_MEMORY_SIZE",
 			Name:   "hyperkit-memory-size",
 			Usage:  "Memory size for host in MB.",
 			Value:  defaultMemory,
 		},
+		mcnflag.StringFlag{
+			EnvVar: "HYPERKIT_NETWORK",
+			Name:   "hyperkit-network",
+			Usage:  "Network mode: vmnet, vpnkit or both. vpnkit alone requires --hyperkit-guest-vsock.",
+			Value:  NetworkVMNet,
+		},
+		mcnflag.StringSliceFlag{
+			EnvVar: "HYPERKIT_NIC",
+			Name:   "hyperkit-nic",
+			Usage:  "Additional network interface: vmnet, vpnkit or vpnkit:IP (repeatable).",
+			Value:  []string{},
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "HYPERKIT_NO_ISO",
+			Name:   "hyperkit-no-iso",
+			Usage:  "Don't attach the boot2docker image; requires --hyperkit-kernel.",
+		},
+		mcnflag.StringSliceFlag{
+			EnvVar: "HYPERKIT_PUBLISH",
+			Name:   "hyperkit-publish",
+			Usage:  "Forward a localhost port to the guest as HOSTPORT:GUESTPORT, or HOSTPORT:GUESTPORT/vsock for a vsock port.",
+			Value:  []string{},
+		},
+		mcnflag.StringFlag{
+			EnvVar: "HYPERKIT_STATIC_IP",
+			Name:   "hyperkit-static-ip",
+			Usage:  "IP address to reserve for the host in the vmnet subnet.",
+			Value:  "",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "HYPERKIT_VPNKIT_SOCK",
+			Name:   "hyperkit-vpnkit-sock",
+			Usage:  "Path of the vpnkit socket; \"auto\" uses the one of Docker Desktop.",
+			Value:  "",
+		},
 	}
 }
 
@@ -118,14 +231,110 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 func (d *Driver) SetConfigFromFlags(flags drivers.DriverOptions) error {
 	d.Boot2DockerURL = flags.String("hyperkit-boot2docker-url")
 	d.CPU = flags.Int("hyperkit-cpu-count")
+	d.DiskFormat = flags.String("hyperkit-disk-format")
 	d.DiskSize = int(flags.Int("hyperkit-disk-size"))
+	d.GuestVSock = flags.Bool("hyperkit-guest-vsock")
+	d.HostsEntry = flags.Bool("hyperkit-hosts-entry")
+	d.Initrd = flags.String("hyperkit-initrd")
+	d.IPTimeout = flags.Int("hyperkit-ip-timeout")
+	d.ISOSHA256 = flags.String("hyperkit-iso-sha256")
+	d.Kernel = flags.String("hyperkit-kernel")
 	d.Memory = flags.Int("hyperkit-memory-size")
-
+	d.Network = flags.String("hyperkit-network")
+	d.NoISO = flags.Bool("hyperkit-no-iso")
+	d.Publish = flags.StringSlice("hyperkit-publish")
+	d.StaticIP = flags.String("hyperkit-static-ip")
+	d.VpnKitSock = flags.String("hyperkit-vpnkit-sock")
+
+	for _, spec := range flags.StringSlice("hyperkit-data-disk") {
+		disk, err := ParseDataDisk(spec)
+		if err != nil {
+			return err
+		}
+		d.ExtraDisks = append(d.ExtraDisks, disk)
+	}
+	for _, spec := range flags.StringSlice("hyperkit-nic") {
+		nic, err := ParseNIC(spec)
+		if err != nil {
+			return err
+		}
+		d.ExtraNICs = append(d.ExtraNICs, nic)
+	}
 	return nil
 }
 
 // PreCreateCheck is called to enforce pre-creation steps
 func (d *Driver) PreCreateCheck() error {
+	if err := pkgdrivers.ValidateDiskFormat(d.DiskFormat); err != nil {
+		return err
+	}
+	if d.ISOSHA256 != "" {
+		if _, err := pkgdrivers.NormalizeSHA256(d.ISOSHA256); err != nil {
+			return err
+		}
+	}
+	if d.Kernel == "" {
+		if d.Initrd != "" {
+			return fmt.Errorf("an initrd can only be used together with a kernel")
+		}
+		if d.NoISO {
+			return fmt.Errorf("a kernel is required to boot without an ISO")
+		}
+	}
+	if d.Network != "" {
+		if err := ValidateNetwork(d.Network); err != nil {
+			return err
+		}
+	}
+	if d.network() == NetworkVPNKit {
+		// The guest can only be reached through vsock; the boot2docker ISO doesn't listen there
+		if !d.GuestVSock {
+			return fmt.Errorf("the %s network requires a guest that accepts SSH and Docker connections on vsock ports 22 and 2376; confirm that it does with the guest vsock option, or use the %s network", NetworkVPNKit, NetworkBoth)
+		}
+		// nfsd rejects the mounts that vpnkit proxies from unprivileged ports on the host
+		if d.shareType() == ShareNFS && len(d.NFSShares) > 0 {
+			return fmt.Errorf("NFS shares require the vmnet network; use share type %s or %s with the %s network", Share9P, ShareSSHFS, NetworkVPNKit)
+		}
+	}
+	if d.VpnKitSock != "" && !d.usesVPNKit() && !d.hasVPNKitNIC() {
+		return fmt.Errorf("a vpnkit socket requires the %s or %s network, or a vpnkit interface", NetworkVPNKit, NetworkBoth)
+	}
+	if d.ShareType != "" {
+		if err := ValidateShareType(d.ShareType); err != nil {
+			return err
+		}
+	}
+	if err := d.validateShares(); err != nil {
+		return err
+	}
+	if d.StaticIP != "" {
+		if !d.usesVMNet() {
+			return fmt.Errorf("a static IP requires the vmnet network")
+		}
+		if err := ValidateStaticIP(d.StaticIP); err != nil {
+			return err
+		}
+	}
+	if d.HostsEntry {
+		if err := ValidateHostLabel(d.MachineName); err != nil {
+			return err
+		}
+	}
+	if _, err := ParsePortForwards(d.Publish); err != nil {
+		return err
+	}
+	for _, nic := range d.ExtraNICs {
+		if _, err := ParseNIC(nic.String()); err != nil {
+			return err
+		}
+	}
+	names := map[string]bool{}
+	for _, disk := range d.ExtraDisks {
+		if names[disk.Name] {
+			return fmt.Errorf("duplicate data disk name %q", disk.Name)
+		}
+		names[disk.Name] = true
+	}
 	return nil
 }
 
@@ -146,14 +355,30 @@ func self(args ...string) (string, error) {
 func (d *Driver) Create() error {
 	d.SSHUser = defaultSSHUser
 
-	// TODO: handle different disk types.
-	if err := pkgdrivers.MakeDiskImage(d.BaseDriver, d.Boot2DockerURL, d.DiskSize); err != nil {
+	if !d.NoISO {
+		verified, err := pkgdrivers.CopyIsoToMachineDir(d.BaseDriver, d.Boot2DockerURL, d.ISOSHA256)
+		if err != nil {
+			return err
+		}
+		digest, err := NewBootCache(d.StorePath).AddISO(d.ResolveStorePath(isoFilename), verified)
+		if err != nil {
+			return errors.Wrap(err, "caching iso")
+		}
+		d.ISOSHA256 = digest
+	}
+
+	if err := pkgdrivers.MakeDiskImage(d.BaseDriver, d.DiskSize, d.DiskFormat); err != nil {
 		return errors.Wrap(err, "making disk image")
 	}
 
-	isoPath := d.ResolveStorePath(isoFilename)
-	if err := d.extractKernel(isoPath); err != nil {
-		return errors.Wrap(err, "extracting kernel")
+	if d.Kernel != "" {
+		if err := d.copyBootFiles(); err != nil {
+			return errors.Wrap(err, "copying boot files")
+		}
+	} else {
+		if err := d.extractKernel(); err != nil {
+			return errors.Wrap(err, "extracting kernel")
+		}
 	}
 
 	return d.Start()
@@ -177,9 +402,52 @@ func (d *Driver) GetURL() (string, error) {
 	if err != nil {
 		return "", err
 	}
+	if d.network() == NetworkVPNKit {
+		// The guest is only reachable through the port forwarded over vsock
+		return fmt.Sprintf("tcp://%s:%d", ip, d.DockerPort), nil
+	}
 	return fmt.Sprintf("tcp://%s:2376", ip), nil
 }
 
+// network returns the network mode of the machine. Machines created before the mode could be
+// chosen were attached to vpnkit in addition to vmnet if they had a vpnkit socket.
+func (d *Driver) network() string {
+	if d.Network != "" {
+		return d.Network
+	}
+	if d.VpnKitSock != "" {
+		return NetworkBoth
+	}
+	return NetworkVMNet
+}
+
+func (d *Driver) usesVMNet() bool {
+	return d.network() != NetworkVPNKit
+}
+
+func (d *Driver) usesVPNKit() bool {
+	return d.network() != NetworkVMNet
+}
+
+// allocateLocalPorts picks the localhost ports that are forwarded to SSH and Docker in the guest
+func (d *Driver) allocateLocalPorts() error {
+	ports, err := freeLocalPorts(2)
+	if err != nil {
+		return errors.Wrap(err, "allocating local ports")
+	}
+	d.SSHPort, d.DockerPort = ports[0], ports[1]
+	return nil
+}
+
+// hostAddress returns the address of the host as seen from the guest. NFS is only served on the
+// vmnet network, because the host address on the vpnkit network depends on its configuration.
+func (d *Driver) hostAddress() (net.IP, error) {
+	if !d.usesVMNet() {
+		return nil, fmt.Errorf("the host can only be reached on the %s network", NetworkVMNet)
+	}
+	return GetNetAddr()
+}
+
 // Return the state of the hyperkit pid
 func pidState(pid int) (state.State, error) {
 	if pid == 0 {
@@ -224,6 +492,43 @@ func (d *Driver) Remove() error {
 			return err
 		}
 	}
+	if d.StaticIP != "" {
+		if err := d.removeStaticIP(); err != nil {
+			log.Warnf("Could not remove the reservation of %s: %v", d.StaticIP, err)
+		}
+	}
+	d.removeHostsEntry()
+	d.stopPortForwards()
+	d.stopSSHFS()
+	d.stop9PServer()
+	if err := d.removeDHCPLeases(); err != nil {
+		log.Warnf("Could not remove the DHCP leases of the machine: %v", err)
+	}
+	return d.removeDataDisks()
+}
+
+// removeDHCPLeases removes the machine's entries from the vmnet leases file, so that
+// their addresses can be handed out again before the leases expire
+func (d *Driver) removeDHCPLeases() error {
+	macs, err := d.MACAddresses()
+	if err != nil || len(macs) == 0 {
+		return err
+	}
+	if out, err := self(append([]string{"dhcp-leases", "remove"}, macs...)...); err != nil {
+		return fmt.Errorf("%v: %s", err, out)
+	}
+	return nil
+}
+
+// removeStaticIP removes the bootpd reservation of the machine's static IP address
+func (d *Driver) removeStaticIP() error {
+	mac, err := d.MACAddress()
+	if err != nil {
+		return err
+	}
+	if out, err := self("bootptab", "remove", mac); err != nil {
+		return fmt.Errorf("%v: %s", err, out)
+	}
 	return nil
 }
 
@@ -234,16 +539,30 @@ func (d *Driver) Restart() error {
 
 func (d *Driver) createHost() (*hyperkit.HyperKit, error) {
 	stateDir := d.ResolveStorePath("")
-	h, err := hyperkit.New(d.Hyperkit, d.VpnKitSock, stateDir)
+	vpnkitSock := ""
+	if d.usesVPNKit() || d.hasVPNKitNIC() {
+		vpnkitSock = d.VpnKitSock
+		if vpnkitSock == "" {
+			vpnkitSock = "auto"
+		}
+	}
+	h, err := hyperkit.New(d.Hyperkit, vpnkitSock, stateDir)
 	if err != nil {
 		return nil, errors.Wrap(err, "new-ing Hyperkit")
 	}
+	// hyperkit.New resolves the socket path, which the extra vpnkit interfaces need as well
+	nicVPNKitSock := h.VPNKitSock
+	if !d.usesVPNKit() {
+		h.VPNKitSock = ""
+	}
 
 	// TODO: handle the rest of our settings.
 	h.Kernel = d.BootKernel
 	h.Initrd = d.BootInitrd
-	h.VMNet = true
-	h.ISOImages = []string{d.ResolveStorePath(isoFilename)}
+	h.VMNet = d.usesVMNet()
+	if !d.NoISO {
+		h.ISOImages = []string{d.ResolveStorePath(isoFilename)}
+	}
 	h.Console = hyperkit.ConsoleFile
 	if d.CPU > defaultCPUs {
 		h.CPUs = d.CPU
@@ -251,9 +570,10 @@ func (d *Driver) createHost() (*hyperkit.HyperKit, error) {
 	if d.Memory > defaultMemory {
 		h.Memory = d.Memory
 	}
-	h.UUID = d.UUID
-	if h.UUID == "" {
-		h.UUID = uuid.NewSHA1(uuid.Nil, []byte(stateDir)).String()
+	h.UUID = d.vmUUID()
+	if d.usesVPNKit() {
+		// vpnkit hands out the same address to the same UUID
+		h.VPNKitUUID = h.UUID
 	}
 
 	if vsockPorts, err := d.extractVSockPorts(); err != nil {
@@ -262,41 +582,104 @@ func (d *Driver) createHost() (*hyperkit.HyperKit, error) {
 		h.VSock = true
 		h.VSockPorts = vsockPorts
 	}
+	// Connections to guest vsock ports go through the connect socket in the state directory
+	forwards, err := d.portForwards()
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range forwards {
+		if f.VSock {
+			h.VSock = true
+		}
+	}
 
-	h.Disks = []hyperkit.Disk{
-		&hyperkit.RawDisk{
-			Path: pkgdrivers.GetDiskPath(d.BaseDriver),
-			Size: d.DiskSize,
-			Trim: true,
-		},
+	disk, err := d.newDisk(pkgdrivers.GetDiskPath(d.BaseDriver, d.DiskFormat), d.DiskSize)
+	if err != nil {
+		return nil, err
+	}
+	h.Disks = []hyperkit.Disk{disk}
+
+	for _, dataDisk := range d.ExtraDisks {
+		disk, err := d.newDisk(d.dataDiskPath(dataDisk), dataDisk.Size)
+		if err != nil {
+			return nil, err
+		}
+		h.Disks = append(h.Disks, disk)
+	}
+	h.Disks = append(h.Disks, d.nicDevices(nicVPNKitSock)...)
+
+	if d.shareType() == Share9P {
+		h.Sockets9P = d.ninePSockets()
 	}
 
 	return h, nil
 }
 
+// newDisk returns the hyperkit disk of the configured disk format for the image at path
+func (d *Driver) newDisk(path string, size int) (hyperkit.Disk, error) {
+	if d.DiskFormat != pkgdrivers.DiskFormatQcow2 {
+		return &hyperkit.RawDisk{
+			Path: path,
+			Size: size,
+			Trim: true,
+		}, nil
+	}
+	// hyperkit runs qcow-tool to check (and resize) the image before starting the VM.
+	// Resolve it here because the privileged hyperkit command will not search the PATH.
+	qcowTool, err := exec.LookPath("qcow-tool")
+	if err != nil {
+		return nil, errors.Wrap(err, "qcow2 disk images require qcow-tool")
+	}
+	return &hyperkit.QcowDisk{
+		Path:         path,
+		Size:         size,
+		Trim:         true,
+		QcowToolPath: qcowTool,
+	}, nil
+}
+
 // Start a host
 func (d *Driver) Start() error {
 	if err := d.recoverFromUncleanShutdown(); err != nil {
 		return err
 	}
 
+	if err := d.createDataDisks(); err != nil {
+		return errors.Wrap(err, "creating data disks")
+	}
+
+	// The ports saved by a previous start may have been taken by another process in the meantime
+	if d.network() == NetworkVPNKit && !localPortsAvailable(d.SSHPort, d.DockerPort) {
+		if err := d.allocateLocalPorts(); err != nil {
+			return err
+		}
+		log.Debugf("Forwarding SSH to port %d and Docker to port %d", d.SSHPort, d.DockerPort)
+	}
+
 	h, err := d.createHost()
 	if err != nil {
 		return err
 	}
 
 	log.Debugf("Using UUID %s", h.UUID)
-	mac, err := self("uuid-to-mac-addr", h.UUID)
-	if err != nil {
-		return errors.Wrap(err, "getting MAC address from UUID")
+	var mac string
+	if d.usesVMNet() {
+		if mac, err = d.MACAddress(); err != nil {
+			return err
+		}
+		log.Debugf("Generated MAC %s", mac)
 	}
 
-	// Need to strip 0's
-	mac = trimMacAddress(mac)
-	log.Debugf("Generated MAC %s", mac)
+	if d.StaticIP != "" {
+		log.Infof("Reserving IP address %s for %s", d.StaticIP, mac)
+		if out, err := self("bootptab", "add", d.MachineName, mac, d.StaticIP); err != nil {
+			return errors.Wrapf(err, "reserving static IP %s: %s", d.StaticIP, out)
+		}
+	}
 
-	// Marshal h.Disks separately because they will need to be unmarshaled as hyperkit.RawDisk types
-	// because hyperkit.Disk is just an interface.
+	// Marshal h.Disks separately because they will need to be unmarshaled as hyperkit.RawDisk or
+	// hyperkit.QcowDisk types (depending on the file extension), or as NetworkDevice, because
+	// hyperkit.Disk is just an interface.
 	disks, err := json.Marshal(h.Disks)
 	if err != nil {
 		return errors.Wrap(err, "exporting hyperkit disks struct to JSON")
@@ -307,9 +690,16 @@ func (d *Driver) Start() error {
 		return errors.Wrap(err, "exporting hyperkit struct to JSON")
 	}
 
+	if d.shareType() == Share9P && len(d.NFSShares) > 0 {
+		if err := d.start9PServer(); err != nil {
+			return errors.Wrap(err, "starting 9p server")
+		}
+	}
+
 	log.Debugf("Starting with cmdline: %s\nhyperkit is %s\ndisks is %s", d.Cmdline, string(hyperkit), string(disks))
 	out, err := self("hyperkit", string(hyperkit), string(disks), d.Cmdline)
 	if err != nil {
+		d.stop9PServer()
 		return errors.Wrapf(err, "failed to start hyperkit with cmd line: %s\nError: %v\n%s", d.Cmdline, err, out)
 	}
 
@@ -317,14 +707,59 @@ func (d *Driver) Start() error {
 		return err
 	}
 
-	if err := d.setupNFSMounts(); err != nil {
+	if d.HostsEntry {
+		if out, err := self("hosts", "add", d.MachineName, d.IPAddress); err != nil {
+			log.Warnf("Adding %s to %s failed: %v: %s", HostName(d.MachineName), HostsPath, err, out)
+		}
+	}
+
+	if err := d.startPortForwards(); err != nil {
+		return errors.Wrap(err, "starting port forwarding")
+	}
+
+	if err := d.growFilesystem(); err != nil {
+		log.Warnf("Growing guest filesystem failed: %v", err)
+	}
+
+	if err := d.setupShares(); err != nil {
 		return err
 	}
 	return nil
 }
 
+// vmUUID returns the UUID of the VM; machines created without a UUID get one derived from their directory
+func (d *Driver) vmUUID() string {
+	if d.UUID != "" {
+		return d.UUID
+	}
+	return uuid.NewSHA1(uuid.Nil, []byte(d.ResolveStorePath(""))).String()
+}
+
+// MACAddress returns the MAC address vmnet assigns to the machine, which is derived from its UUID
+func (d *Driver) MACAddress() (string, error) {
+	mac, err := self("uuid-to-mac-addr", d.vmUUID())
+	if err != nil {
+		return "", errors.Wrap(err, "getting MAC address from UUID")
+	}
+	// Need to strip 0's
+	return trimMacAddress(mac), nil
+}
+
 func (d *Driver) setupIP(mac string) error {
-	getIP := func() error {
+	if !d.usesVMNet() {
+		// SSH and Docker are forwarded to localhost
+		d.IPAddress = "127.0.0.1"
+		log.Debugf("IP: %s", d.IPAddress)
+		return nil
+	}
+	if d.StaticIP != "" {
+		// bootpd hands out the reserved address, but doesn't record it in the leases file
+		d.IPAddress = d.StaticIP
+		log.Debugf("IP: %s", d.IPAddress)
+		return nil
+	}
+
+	checkState := func() error {
 		st, err := d.GetState()
 		if err != nil {
 			return errors.Wrap(err, "get state")
@@ -332,62 +767,62 @@ func (d *Driver) setupIP(mac string) error {
 		if st == state.Error || st == state.Stopped {
 			return fmt.Errorf("hyperkit crashed! command line:\n  hyperkit %s", d.Cmdline)
 		}
-
-		d.IPAddress, err = GetIPAddressByMACAddress(mac)
-		if err != nil {
-			return &tempError{err}
-		}
 		return nil
 	}
 
-	var err error
-
-	// Implement a retry loop without calling any minikube code
-	for i := 0; i < 30; i++ {
-		log.Debugf("Attempt %d", i)
-		err = getIP()
-		if err == nil {
-			break
-		}
-		if _, ok := err.(*tempError); !ok {
-			return err
-		}
-		time.Sleep(2 * time.Second)
-	}
-
+	ip, err := waitForIPAddress(mac, LeasesPath, d.ipTimeout(), checkState)
 	if err != nil {
-		return fmt.Errorf("IP address never found in dhcp leases file %v", err)
+		return err
 	}
+	d.IPAddress = ip
 	log.Debugf("IP: %s", d.IPAddress)
 
 	return nil
 }
 
-func (d *Driver) setupNFSMounts() error {
+// ipTimeout returns how long to wait for the machine to get an IP address
+func (d *Driver) ipTimeout() time.Duration {
+	if d.IPTimeout > 0 {
+		return time.Duration(d.IPTimeout) * time.Second
+	}
+	return defaultIPTimeout * time.Second
+}
+
+func (d *Driver) setupShares() error {
 	var err error
 
 	if len(d.NFSShares) > 0 {
-		log.Info("Setting up NFS mounts")
+		log.Infof("Setting up %s mounts", d.shareType())
 		if err := drivers.WaitForSSH(d); err != nil {
 			return err
 		}
-		err = d.setupNFSShare()
+		switch d.shareType() {
+		case Share9P:
+			err = d.setup9PShare()
+		case ShareSSHFS:
+			err = d.startSSHFS()
+		default:
+			err = d.setupNFSShare()
+		}
 		if err != nil {
 			// TODO(tstromberg): Check that logging an and error and return it is appropriate. Seems weird.
-			log.Errorf("NFS setup failed: %v", err)
+			log.Errorf("%s setup failed: %v", d.shareType(), err)
 			return err
 		}
 	}
 
-	return nil
-}
-
-type tempError struct {
-	Err error
-}
+	// sshfs mounts are restored by the sshfs process, and the guest can't mount removed shares
+	if d.BootMounts && (len(d.NFSShares) == 0 || d.shareType() == ShareSSHFS) {
+		log.Info("Removing persistent mounts")
+		if err := drivers.WaitForSSH(d); err != nil {
+			return err
+		}
+		if err := d.persistMounts("", nil); err != nil {
+			log.Warnf("Removing the persistent mounts failed: %v", err)
+		}
+	}
 
-func (t tempError) Error() string {
-	return "Temporary error: " + t.Err.Error()
+	return nil
 }
 
 //recoverFromUncleanShutdown searches for an existing hyperkit.pid file in
@@ -437,7 +872,16 @@ func (d *Driver) recoverFromUncleanShutdown() error {
 
 // Stop a host gracefully
 func (d *Driver) Stop() error {
-	d.cleanupNfsExports()
+	if d.shareType() == ShareNFS {
+		d.cleanupNfsExports()
+	}
+	d.removeHostsEntry()
+	d.stopPortForwards()
+	// sshfs is stopped while the guest is running, so that it can unmount the shares; stopDaemon
+	// waits for it to finish before hyperkit is stopped
+	d.stopSSHFS()
+	// The 9p server is stopped after hyperkit, so that the guest can flush its writes
+	defer d.stop9PServer()
 	err := d.sendSignal(syscall.SIGTERM)
 	if err != nil {
 		return errors.Wrap(err, "hyperkit sigterm failed")
@@ -460,27 +904,74 @@ func (d *Driver) Stop() error {
 	return d.Kill()
 }
 
-func (d *Driver) extractKernel(isoPath string) error {
-	files, err := ISOExtractBootFiles(isoPath, d.ResolveStorePath(""))
+// extractKernel links the boot files of the machine ISO from the boot cache into the machine directory
+func (d *Driver) extractKernel() error {
+	files, err := NewBootCache(d.StorePath).LinkBootFiles(d.ISOSHA256, d.ResolveStorePath("."))
 	if err != nil {
 		return err
 	}
 
 	if files.KernelPath == "" {
-		return errors.Wrapf(err, "failed to extract kernel boot image from iso")
+		return errors.New("failed to extract kernel boot image from iso")
 	}
 	d.BootKernel = files.KernelPath
+	d.BootInitrd = files.InitrdPath
+	d.Cmdline = d.bootCmdline(files.Cmdline)
 
-	if files.InitrdPath == "" {
-		return errors.Wrapf(err, "failed to extract initial ram disk from iso")
+	return nil
+}
+
+// copyBootFiles copies the user-provided kernel and initrd into the machine directory
+func (d *Driver) copyBootFiles() error {
+	var err error
+	if d.BootKernel, err = d.copyBootFile(d.Kernel, kernelFileName); err != nil {
+		return err
 	}
-	d.BootInitrd = files.InitrdPath
+	if d.Initrd != "" {
+		if d.BootInitrd, err = d.copyBootFile(d.Initrd, initrdFileName); err != nil {
+			return err
+		}
+	}
+	d.Cmdline = d.bootCmdline("")
+	return nil
+}
 
-	if files.IsoLinuxCfgPath == "" {
-		return errors.Wrapf(err, "failed to extract isolinux config")
+// copyBootFile copies src to the file name in the machine directory, and returns its path
+func (d *Driver) copyBootFile(src, name string) (string, error) {
+	dst := d.ResolveStorePath(name)
+	srcInfo, err := os.Stat(src)
+	if err != nil {
+		return "", err
+	}
+	// Removing dst would remove the only copy of the boot file
+	if dstInfo, err := os.Stat(dst); err == nil && os.SameFile(srcInfo, dstInfo) {
+		return "", fmt.Errorf("boot file %s must not be in the machine directory as %s", src, name)
 	}
+	log.Infof("Copying %s to %s...", src, dst)
+	if err := os.Remove(dst); err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	return dst, pkgdrivers.CloneFile(src, dst)
+}
 
-	return nil
+// bootCmdline returns the kernel command line for booting the machine. Unless the user has
+// provided a complete command line, it is taken from the boot config of the ISO (if there is one).
+// A user-provided command line starting with "+" is appended to the one from the boot config.
+func (d *Driver) bootCmdline(configCmdline string) string {
+	if d.Cmdline != "" && !strings.HasPrefix(d.Cmdline, "+") {
+		return d.Cmdline
+	}
+
+	base := defaultCmdline
+	if configCmdline != "" {
+		base = configCmdline
+	}
+	log.Debugf("Base cmdline: %s", base)
+
+	if strings.HasPrefix(d.Cmdline, "+") {
+		return fmt.Sprintf("%s %s", base, d.Cmdline[1:])
+	}
+	return base
 }
 
 // InvalidPortNumberError implements the Error interface.
@@ -512,57 +1003,98 @@ func (d *Driver) setupNFSShare() error {
 		return err
 	}
 
-	hostIP, err := GetNetAddr()
+	hostIP, err := d.hostAddress()
 	if err != nil {
 		return err
 	}
 
-	mountCommands := "#/bin/bash\\n"
-	// TODO(jandubois) nfs-client utils are not running by default on TinyCoreLinux (boot2docker)
-	mountCommands += "[ -f /usr/local/etc/init.d/nfs-client ] && sudo /usr/local/etc/init.d/nfs-client start\\n"
 	log.Info(d.IPAddress)
 
 	exportsAddCmd := []string{"nfs-exports", "add", user.Username}
 
-	for _, share := range d.NFSShares {
-		sharePaths := strings.Split(share, ":")
-		localPath := sharePaths[0]
-		if !path.IsAbs(localPath) {
-			localPath = d.ResolveStorePath(localPath)
-		}
-		localPath, err := filepath.EvalSymlinks(localPath)
+	shares, err := d.shares()
+	if err != nil {
+		return err
+	}
+	var mounts []guestMount
+	for _, share := range shares {
+		exportOptions, mountOptions, err := ParseNFSOptions(share.Options)
 		if err != nil {
-			log.Errorf("cannot evaluate symlinks in share path '%s': %v", sharePaths[0], err)
 			return err
 		}
-		// nfsExportIdentifier() is called with `share` and not `localPath` to keep the exports cleanup code simple
-		exportsAddCmd = append(exportsAddCmd, d.nfsExportIdentifier(share), localPath, d.IPAddress)
-
-		var mountPoint string
-		if len(sharePaths) < 2 {
-			mountPoint = filepath.Join(d.NFSSharesRoot, localPath)
-		} else {
-			// TODO(jandubois) Should we validate that the mountpoint is an absolute path?
-			mountPoint = sharePaths[1]
-		}
-		mountCommands += fmt.Sprintf("sudo mkdir -p %s\\n", mountPoint)
-		mountCommands += fmt.Sprintf("sudo mount -t nfs -o vers=3,noacl,async '%s:%s' %s\\n", hostIP, localPath, mountPoint)
+		// nfsExportIdentifier() is called with the spec and not the local path to keep the exports cleanup code simple
+		exportsAddCmd = append(exportsAddCmd, d.nfsExportIdentifier(share.Spec), share.LocalPath, d.IPAddress,
+			strings.Join(exportOptions, ","))
+
+		mounts = append(mounts, guestMount{
+			Source:     fmt.Sprintf("%s:%s", hostIP, share.LocalPath),
+			MountPoint: share.MountPoint,
+			FSType:     "nfs",
+			Options:    nfsMountOptionString(mountOptions),
+		})
 	}
 
 	if _, err := self(exportsAddCmd...); err != nil {
 		return err
 	}
 
-	writeScriptCmd := fmt.Sprintf("echo -e \"%s\" | sh", mountCommands)
-	if _, err := drivers.RunSSHCommandFromDriver(d, writeScriptCmd); err != nil {
+	// TODO(jandubois) nfs-client utils are not running by default on TinyCoreLinux (boot2docker)
+	return d.mountShares(nfsClientStart, mounts)
+}
+
+func (d *Driver) nfsExportIdentifier(path string) string {
+	return fmt.Sprintf("docker-machine-driver-hyperkit %s-%s", d.MachineName, path)
+}
+
+// portForwards returns the ports forwarded while the machine is running: the published ports, and
+// with the vpnkit network SSH and Docker, because the guest can't be reached from the host otherwise
+func (d *Driver) portForwards() ([]PortForward, error) {
+	forwards, err := ParsePortForwards(d.Publish)
+	if err != nil {
+		return nil, err
+	}
+	if d.network() == NetworkVPNKit {
+		forwards = append(forwards,
+			PortForward{HostPort: d.SSHPort, GuestPort: 22, VSock: true},
+			PortForward{HostPort: d.DockerPort, GuestPort: 2376, VSock: true})
+	}
+	return forwards, nil
+}
+
+// startPortForwards starts a background process forwarding ports to the guest. The process gets
+// the IP address and SSH port explicitly, because the machine config with the current values is
+// only saved after Start returns.
+func (d *Driver) startPortForwards() error {
+	forwards, err := d.portForwards()
+	if err != nil || len(forwards) == 0 {
 		return err
 	}
+	sshPort, err := d.GetSSHPort()
+	if err != nil {
+		return err
+	}
+	args := []string{"port-forward", "--storage-path", d.StorePath, "--machine-name", d.MachineName,
+		"--guest-ip", d.IPAddress, "--ssh-port", strconv.Itoa(sshPort)}
+	for _, f := range forwards {
+		args = append(args, f.String())
+	}
+	return startDaemon(d.ResolveStorePath(portForwardPidFileName), d.ResolveStorePath(portForwardLogFileName), args...)
+}
 
-	return nil
+// stopPortForwards stops the port forwarding process, if it is running
+func (d *Driver) stopPortForwards() {
+	if err := stopDaemon(d.ResolveStorePath(portForwardPidFileName)); err != nil {
+		log.Warnf("Stopping port forwarding failed: %v", err)
+	}
 }
 
-func (d *Driver) nfsExportIdentifier(path string) string {
-	return fmt.Sprintf("docker-machine-driver-hyperkit %s-%s", d.MachineName, path)
+// removeHostsEntry removes the machine from the hosts file
+func (d *Driver) removeHostsEntry() {
+	if d.HostsEntry {
+		if out, err := self("hosts", "remove", d.MachineName); err != nil {
+			log.Warnf("Removing %s from %s failed: %v: %s", HostName(d.MachineName), HostsPath, err, out)
+		}
+	}
 }
 
 func (d *Driver) sendSignal(s os.Signal) error {
@@ -607,51 +1139,79 @@ func (d *Driver) cleanupNfsExports() {
 	}
 }
 
-// nfsexports.ReloadDaemon uses `sudo` which will prompt for a password; we are already running as root
-func reloadNFSDaemon() error {
+// nfsexports.ReloadDaemon uses `sudo` which will prompt for a password; we are already running as root.
+// It also restarts nfsd, which interrupts all NFS mounts of the host, so applyExports is used instead.
+func reloadNFSDaemon(backup *exportsBackup, start bool) error {
 	uid := syscall.Getuid()
 	syscall.Setuid(0)
-	out, err := exec.Command("/sbin/nfsd", "restart").Output()
+	err := applyExports(NFSDPath, backup, start)
 	syscall.Setreuid(uid, 0)
 	if err != nil {
-		return fmt.Errorf("Reloading nfsd failed: %s\n%s", err.Error(), out)
+		return fmt.Errorf("Reloading nfsd failed: %v", err)
 	}
 	return nil
 }
 
+// AddNFSExports adds exports to /etc/exports. The arguments are the user that accesses the files,
+// followed by the identifier, path, IP address and comma-separated export options of each export.
 func AddNFSExports(args ...string) error {
 	user := args[0]
 	args = args[1:]
 
-	if len(args)%3 != 0 {
-		return fmt.Errorf("there should be 3 arguments for each export")
+	if len(args)%4 != 0 {
+		return fmt.Errorf("there should be 4 arguments for each export")
+	}
+
+	backup, err := backupExports(ExportsPath)
+	if err != nil {
+		return err
 	}
 
 	for len(args) > 0 {
 		ident := args[0]
 		path := args[1]
 		ip := args[2]
-		args = args[3:]
+		var options []string
+		if args[3] != "" {
+			options = strings.Split(args[3], ",")
+		}
+		args = args[4:]
 
-		export := fmt.Sprintf("%q %s -alldirs -mapall=%s", path, ip, user)
-		if _, err := nfsexports.Add("", ident, export); err != nil {
+		// The identifier is written as a comment line
+		if strings.ContainsAny(ident, "\n\r") {
+			return fmt.Errorf("invalid export identifier %q", ident)
+		}
+		export, err := NFSExportLine(path, ip, user, options)
+		if err != nil {
+			return err
+		}
+		if _, err := nfsexports.Add(ExportsPath, ident, export); err != nil {
 			if strings.Contains(err.Error(), "conflicts with existing export") {
 				fmt.Fprintf(os.Stderr, "Conflicting NFS Share not setup and ignored: %v", err)
 				continue
 			}
+			if restoreErr := backup.restore(); restoreErr != nil {
+				fmt.Fprintf(os.Stderr, "failed restoring %s: %v", ExportsPath, restoreErr)
+			}
 			return err
 		}
 	}
-	return reloadNFSDaemon()
+	return reloadNFSDaemon(backup, true)
 }
 
+// RemoveNFSExports removes the exports with the given identifiers from /etc/exports
 func RemoveNFSExports(args ...string) error {
+	backup, err := backupExports(ExportsPath)
+	if err != nil {
+		return err
+	}
 	for _, ident := range args {
-		if _, err := nfsexports.Remove("", ident); err != nil {
+		if _, err := nfsexports.Remove(ExportsPath, ident); err != nil {
 			fmt.Fprintf(os.Stderr, "failed removing nfs share (%s): %v", ident, err)
 		}
 	}
-	if err := reloadNFSDaemon(); err != nil {
+	// There is no need to start nfsd just to stop exporting shares
+	if err := reloadNFSDaemon(backup, false); err != nil {
 		fmt.Fprintf(os.Stderr, "failed to reload the nfs daemon: %v", err)
 	}
 	return nil
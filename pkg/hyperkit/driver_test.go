@@ -19,7 +19,13 @@ limitations under the License.
 package hyperkit
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
+
+	"github.com/docker/machine/libmachine/drivers"
 )
 
 func Test_portExtraction(t *testing.T) {
@@ -84,3 +90,217 @@ func testEq(a, b []int) bool {
 
 	return true
 }
+
+func Test_network(t *testing.T) {
+	tests := []struct {
+		name       string
+		network    string
+		vpnkitSock string
+		want       string
+		wantVMNet  bool
+		wantVPNKit bool
+	}{
+		{"default", "", "", NetworkVMNet, true, false},
+		{"legacy vpnkit socket", "", "auto", NetworkBoth, true, true},
+		{"vmnet", NetworkVMNet, "", NetworkVMNet, true, false},
+		{"vpnkit", NetworkVPNKit, "", NetworkVPNKit, false, true},
+		{"both", NetworkBoth, "/tmp/vpnkit.sock", NetworkBoth, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDriver("", "")
+			d.Network = tt.network
+			d.VpnKitSock = tt.vpnkitSock
+			if got := d.network(); got != tt.want {
+				t.Errorf("network() = %q, want %q", got, tt.want)
+			}
+			if got := d.usesVMNet(); got != tt.wantVMNet {
+				t.Errorf("usesVMNet() = %v, want %v", got, tt.wantVMNet)
+			}
+			if got := d.usesVPNKit(); got != tt.wantVPNKit {
+				t.Errorf("usesVPNKit() = %v, want %v", got, tt.wantVPNKit)
+			}
+		})
+	}
+}
+
+func TestPreCreateCheck_vpnkit(t *testing.T) {
+	tests := []struct {
+		name       string
+		guestVSock bool
+		shareType  string
+		shares     []string
+		wantErr    bool
+	}{
+		{name: "without guest vsock", wantErr: true},
+		{name: "with guest vsock", guestVSock: true},
+		{name: "nfs shares", guestVSock: true, shares: []string{"/Users"}, wantErr: true},
+		{name: "9p shares", guestVSock: true, shareType: Share9P, shares: []string{"/Users"}},
+		{name: "sshfs shares", guestVSock: true, shareType: ShareSSHFS, shares: []string{"/Users"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDriver("", "")
+			d.Network = NetworkVPNKit
+			d.GuestVSock = tt.guestVSock
+			d.ShareType = tt.shareType
+			d.NFSShares = tt.shares
+			if err := d.PreCreateCheck(); (err != nil) != tt.wantErr {
+				t.Errorf("PreCreateCheck() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestGetURL(t *testing.T) {
+	d := NewDriver("", "")
+	d.BaseDriver = &drivers.BaseDriver{IPAddress: "192.168.64.5"}
+	if got, _ := d.GetURL(); got != "tcp://192.168.64.5:2376" {
+		t.Errorf("GetURL() = %q for vmnet", got)
+	}
+
+	d.Network = NetworkVPNKit
+	d.IPAddress = "127.0.0.1"
+	d.DockerPort = 52376
+	if got, _ := d.GetURL(); got != "tcp://127.0.0.1:52376" {
+		t.Errorf("GetURL() = %q for vpnkit", got)
+	}
+}
+
+func Test_portForwards(t *testing.T) {
+	d := NewDriver("", "")
+	d.BaseDriver = &drivers.BaseDriver{}
+	d.Publish = []string{"8080:80"}
+	forwards, err := d.portForwards()
+	if err != nil {
+		t.Fatalf("portForwards() error = %v", err)
+	}
+	if want := []PortForward{{HostPort: 8080, GuestPort: 80}}; !reflect.DeepEqual(forwards, want) {
+		t.Errorf("portForwards() = %+v for vmnet, want %+v", forwards, want)
+	}
+
+	d.Network = NetworkVPNKit
+	if err := d.allocateLocalPorts(); err != nil {
+		t.Fatalf("allocateLocalPorts() error = %v", err)
+	}
+	if d.SSHPort == 0 || d.DockerPort == 0 || d.SSHPort == d.DockerPort {
+		t.Fatalf("allocateLocalPorts() allocated SSH port %d and Docker port %d", d.SSHPort, d.DockerPort)
+	}
+	forwards, err = d.portForwards()
+	if err != nil {
+		t.Fatalf("portForwards() error = %v", err)
+	}
+	want := []PortForward{
+		{HostPort: 8080, GuestPort: 80},
+		{HostPort: d.SSHPort, GuestPort: 22, VSock: true},
+		{HostPort: d.DockerPort, GuestPort: 2376, VSock: true},
+	}
+	if !reflect.DeepEqual(forwards, want) {
+		t.Errorf("portForwards() = %+v for vpnkit, want %+v", forwards, want)
+	}
+}
+
+func Test_copyBootFiles(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "")
+	if err != nil {
+		t.Fatalf("TempDir() error = %v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	d := NewDriver("", "")
+	d.BaseDriver = &drivers.BaseDriver{MachineName: "test", StorePath: tmpdir}
+	machineDir := d.ResolveStorePath(".")
+	// The boot files have the same name, and the name of a machine file
+	kernelDir, initrdDir := filepath.Join(tmpdir, "kernel"), filepath.Join(tmpdir, "initrd")
+	for _, dir := range []string{kernelDir, initrdDir, machineDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, configFileName), []byte(dir), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d.Kernel = filepath.Join(kernelDir, configFileName)
+	d.Initrd = filepath.Join(initrdDir, configFileName)
+
+	if err := d.copyBootFiles(); err != nil {
+		t.Fatalf("copyBootFiles() error = %v", err)
+	}
+	for path, want := range map[string]string{
+		d.BootKernel:                       kernelDir,
+		d.BootInitrd:                       initrdDir,
+		d.ResolveStorePath(configFileName): machineDir,
+	} {
+		if got, err := ioutil.ReadFile(path); err != nil || string(got) != want {
+			t.Errorf("%s contains %q, %v, want %q", path, got, err, want)
+		}
+	}
+
+	// Copying the boot files again from the machine directory would remove them
+	kernel := d.BootKernel
+	d.Kernel = kernel
+	if err := d.copyBootFiles(); err == nil {
+		t.Errorf("copyBootFiles() of a boot file in the machine directory succeeded")
+	}
+	if _, err := os.Stat(kernel); err != nil {
+		t.Errorf("kernel was removed: %v", err)
+	}
+}
+
+type testFlags map[string]interface{}
+
+func (f testFlags) String(key string) string {
+	v, _ := f[key].(string)
+	return v
+}
+
+func (f testFlags) StringSlice(key string) []string {
+	v, _ := f[key].([]string)
+	return v
+}
+
+func (f testFlags) Int(key string) int {
+	v, _ := f[key].(int)
+	return v
+}
+
+func (f testFlags) Bool(key string) bool {
+	v, _ := f[key].(bool)
+	return v
+}
+
+func TestSetConfigFromFlags_nics(t *testing.T) {
+	d := NewDriver("", "")
+	flags := testFlags{"hyperkit-nic": []string{"vmnet", "vpnkit:192.168.65.10"}}
+	if err := d.SetConfigFromFlags(flags); err != nil {
+		t.Fatalf("SetConfigFromFlags() error = %v", err)
+	}
+	want := []NIC{{Network: NetworkVMNet}, {Network: NetworkVPNKit, IPAddress: "192.168.65.10"}}
+	if !reflect.DeepEqual(d.ExtraNICs, want) {
+		t.Errorf("ExtraNICs = %v, want %v", d.ExtraNICs, want)
+	}
+
+	d = NewDriver("", "")
+	flags = testFlags{"hyperkit-nic": []string{"vmnet:192.168.64.10"}}
+	if err := d.SetConfigFromFlags(flags); err == nil {
+		t.Errorf("SetConfigFromFlags() of an invalid interface succeeded")
+	}
+}
+
+func TestSetConfigFromFlags_dataDisks(t *testing.T) {
+	d := NewDriver("", "")
+	flags := testFlags{"hyperkit-data-disk": []string{"data:1024", "logs:512"}}
+	if err := d.SetConfigFromFlags(flags); err != nil {
+		t.Fatalf("SetConfigFromFlags() error = %v", err)
+	}
+	want := []DataDisk{{Name: "data", Size: 1024}, {Name: "logs", Size: 512}}
+	if !reflect.DeepEqual(d.ExtraDisks, want) {
+		t.Errorf("ExtraDisks = %v, want %v", d.ExtraDisks, want)
+	}
+
+	d = NewDriver("", "")
+	flags = testFlags{"hyperkit-data-disk": []string{"data"}}
+	if err := d.SetConfigFromFlags(flags); err == nil {
+		t.Errorf("SetConfigFromFlags() of an invalid data disk succeeded")
+	}
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hyperkit
+
+import (
+	"os"
+	"time"
+
+	"github.com/docker/machine/libmachine/log"
+)
+
+// fileWatcher reports possible changes of a file. Spurious notifications are allowed;
+// consumers are expected to re-read the file after each one.
+type fileWatcher interface {
+	Changes() <-chan struct{}
+	Close() error
+}
+
+// newFileWatcher returns a watcher for path that uses file system notifications if they are
+// available, and polls the file every pollInterval otherwise. path doesn't need to exist yet.
+func newFileWatcher(path string, pollInterval time.Duration) fileWatcher {
+	w, err := newNotifyWatcher(path)
+	if err == nil {
+		return w
+	}
+	log.Debugf("Cannot watch %s for changes, falling back to polling: %v", path, err)
+	return newPollWatcher(path, pollInterval)
+}
+
+// pollWatcher detects changes by comparing the size, modification time and inode of a file
+type pollWatcher struct {
+	changes chan struct{}
+	done    chan struct{}
+}
+
+func newPollWatcher(path string, interval time.Duration) *pollWatcher {
+	w := &pollWatcher{changes: make(chan struct{}, 1), done: make(chan struct{})}
+	last, _ := os.Stat(path)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-w.done:
+				return
+			case <-ticker.C:
+			}
+			current, _ := os.Stat(path)
+			if fileChanged(last, current) {
+				notify(w.changes)
+			}
+			last = current
+		}
+	}()
+	return w
+}
+
+func (w *pollWatcher) Changes() <-chan struct{} {
+	return w.changes
+}
+
+func (w *pollWatcher) Close() error {
+	close(w.done)
+	return nil
+}
+
+// fileChanged compares two results of os.Stat, which are nil for missing files
+func fileChanged(last, current os.FileInfo) bool {
+	if last == nil || current == nil {
+		return last != current
+	}
+	return !os.SameFile(last, current) || last.Size() != current.Size() || !last.ModTime().Equal(current.ModTime())
+}
+
+// notify sends a notification unless one is pending already
+func notify(changes chan struct{}) {
+	select {
+	case changes <- struct{}{}:
+	default:
+	}
+}
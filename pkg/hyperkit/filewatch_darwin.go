@@ -0,0 +1,134 @@
+// +build darwin
+
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hyperkit
+
+import (
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	kqueueFileEvents = unix.NOTE_WRITE | unix.NOTE_EXTEND | unix.NOTE_ATTRIB | unix.NOTE_DELETE | unix.NOTE_RENAME
+	// kqueueWaitTimeout bounds how long Close has to wait for the event loop to finish
+	kqueueWaitTimeout = 250 * time.Millisecond
+)
+
+// kqueueWatcher watches a file and its directory with kqueue. The directory is watched as well,
+// so that the file is picked up when it is created, or replaced by renaming another file.
+type kqueueWatcher struct {
+	path    string
+	kq      int
+	dirFd   int
+	fileFd  int
+	changes chan struct{}
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+func newNotifyWatcher(path string) (fileWatcher, error) {
+	kq, err := unix.Kqueue()
+	if err != nil {
+		return nil, err
+	}
+	w := &kqueueWatcher{path: path, kq: kq, dirFd: -1, fileFd: -1, changes: make(chan struct{}, 1), done: make(chan struct{})}
+	if w.dirFd, err = w.register(filepath.Dir(path), unix.NOTE_WRITE); err != nil {
+		unix.Close(kq)
+		return nil, err
+	}
+	w.watchFile()
+
+	w.wg.Add(1)
+	go w.loop()
+	return w, nil
+}
+
+// register opens path for event notifications only and adds it to the kqueue
+func (w *kqueueWatcher) register(path string, fflags uint32) (int, error) {
+	fd, err := unix.Open(path, unix.O_EVTONLY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return -1, err
+	}
+	var ev unix.Kevent_t
+	unix.SetKevent(&ev, fd, unix.EVFILT_VNODE, unix.EV_ADD|unix.EV_CLEAR)
+	ev.Fflags = fflags
+	if _, err := unix.Kevent(w.kq, []unix.Kevent_t{ev}, nil, nil); err != nil {
+		unix.Close(fd)
+		return -1, err
+	}
+	return fd, nil
+}
+
+// watchFile (re-)registers the watched file, which may have been replaced or may not exist yet
+func (w *kqueueWatcher) watchFile() {
+	if w.fileFd >= 0 {
+		// Closing the descriptor removes it from the kqueue
+		unix.Close(w.fileFd)
+	}
+	w.fileFd, _ = w.register(w.path, kqueueFileEvents)
+}
+
+func (w *kqueueWatcher) loop() {
+	defer w.wg.Done()
+	events := make([]unix.Kevent_t, 8)
+	timeout := unix.NsecToTimespec(int64(kqueueWaitTimeout))
+	for {
+		select {
+		case <-w.done:
+			return
+		default:
+		}
+		n, err := unix.Kevent(w.kq, nil, events, &timeout)
+		if err != nil {
+			if err == unix.EINTR {
+				continue
+			}
+			// Keep the consumer going; it will re-read the file on every notification
+			notify(w.changes)
+			time.Sleep(kqueueWaitTimeout)
+			continue
+		}
+		changed := false
+		for _, ev := range events[:n] {
+			if int(ev.Ident) == w.dirFd || ev.Fflags&(unix.NOTE_DELETE|unix.NOTE_RENAME) != 0 {
+				w.watchFile()
+			}
+			changed = true
+		}
+		if changed {
+			notify(w.changes)
+		}
+	}
+}
+
+func (w *kqueueWatcher) Changes() <-chan struct{} {
+	return w.changes
+}
+
+func (w *kqueueWatcher) Close() error {
+	close(w.done)
+	w.wg.Wait()
+	if w.fileFd >= 0 {
+		unix.Close(w.fileFd)
+	}
+	unix.Close(w.dirFd)
+	return unix.Close(w.kq)
+}
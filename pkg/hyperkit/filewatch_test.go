@@ -0,0 +1,77 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hyperkit
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileWatcher(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "")
+	if err != nil {
+		t.Fatalf("TempDir() error = %v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	path := filepath.Join(tmpdir, "leases")
+	for name, newWatcher := range map[string]func() fileWatcher{
+		"default": func() fileWatcher { return newFileWatcher(path, 20*time.Millisecond) },
+		"poll":    func() fileWatcher { return newPollWatcher(path, 20*time.Millisecond) },
+	} {
+		watcher := newWatcher()
+		t.Run(name, func(t *testing.T) {
+			defer watcher.Close()
+			expectChange := func(what string) {
+				select {
+				case <-watcher.Changes():
+				case <-time.After(5 * time.Second):
+					t.Errorf("no change notification after %s", what)
+				}
+			}
+
+			if err := ioutil.WriteFile(path, []byte("one"), 0644); err != nil {
+				t.Fatalf("WriteFile() error = %v", err)
+			}
+			expectChange("creating the file")
+
+			// Replace the file the way editors and daemons often do
+			tmp := path + ".tmp"
+			if err := ioutil.WriteFile(tmp, []byte("two, longer"), 0644); err != nil {
+				t.Fatalf("WriteFile() error = %v", err)
+			}
+			// Drain notifications about the temporary file in the same directory
+			time.Sleep(100 * time.Millisecond)
+			select {
+			case <-watcher.Changes():
+			default:
+			}
+			if err := os.Rename(tmp, path); err != nil {
+				t.Fatalf("Rename() error = %v", err)
+			}
+			expectChange("replacing the file")
+
+			if err := os.Remove(path); err != nil {
+				t.Fatalf("Remove() error = %v", err)
+			}
+			expectChange("removing the file")
+		})
+	}
+}
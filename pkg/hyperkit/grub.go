@@ -0,0 +1,122 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hyperkit
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// GrubEntry is a single menu entry of a grub configuration
+type GrubEntry struct {
+	Title   string
+	Kernel  string
+	Initrds []string
+	// Append is the kernel command line
+	Append string
+}
+
+// GrubConfig is the subset of a grub.cfg file needed to boot the default entry
+type GrubConfig struct {
+	Default string
+	Entries []*GrubEntry
+}
+
+// ParseGrubCfg parses a grub configuration. Only top-level "set default=..." and the "linux"
+// and "initrd" commands of menu entries are evaluated; grub scripting is not supported.
+func ParseGrubCfg(r io.Reader) (*GrubConfig, error) {
+	config := &GrubConfig{}
+	var entry *GrubEntry
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+
+		switch {
+		case fields[0] == "menuentry":
+			entry = &GrubEntry{Title: grubUnquote(strings.TrimSuffix(strings.TrimSpace(line[len("menuentry"):]), "{"))}
+			config.Entries = append(config.Entries, entry)
+		case fields[0] == "}":
+			entry = nil
+		case fields[0] == "set" && len(fields) > 1 && strings.HasPrefix(fields[1], "default="):
+			if entry == nil {
+				config.Default = grubUnquote(strings.TrimPrefix(strings.TrimSpace(line[len("set"):]), "default="))
+			}
+		case entry == nil:
+			continue
+		case fields[0] == "linux" || fields[0] == "linuxefi" || fields[0] == "linux16":
+			if len(fields) > 1 {
+				entry.Kernel = grubPath(fields[1])
+				entry.Append = strings.Join(fields[2:], " ")
+			}
+		case fields[0] == "initrd" || fields[0] == "initrdefi" || fields[0] == "initrd16":
+			for _, initrd := range fields[1:] {
+				entry.Initrds = append(entry.Initrds, grubPath(initrd))
+			}
+		}
+	}
+	return config, scanner.Err()
+}
+
+// grubUnquote returns the first word of value without surrounding quotes
+func grubUnquote(value string) string {
+	value = strings.TrimSpace(value)
+	if len(value) > 0 && (value[0] == '\'' || value[0] == '"') {
+		if end := strings.IndexByte(value[1:], value[0]); end >= 0 {
+			return value[1 : end+1]
+		}
+		return value[1:]
+	}
+	if fields := strings.Fields(value); len(fields) > 0 {
+		return fields[0]
+	}
+	return ""
+}
+
+// grubPath removes a device prefix like "($root)" or "(cd0)" from a grub file name
+func grubPath(name string) string {
+	if strings.HasPrefix(name, "(") {
+		if end := strings.IndexByte(name, ')'); end >= 0 {
+			return name[end+1:]
+		}
+	}
+	return name
+}
+
+// DefaultEntry returns the menu entry that grub would boot by default, or nil if there are no entries.
+func (c *GrubConfig) DefaultEntry() *GrubEntry {
+	if index, err := strconv.Atoi(c.Default); err == nil {
+		if index >= 0 && index < len(c.Entries) {
+			return c.Entries[index]
+		}
+	}
+	for _, entry := range c.Entries {
+		if entry.Title == c.Default {
+			return entry
+		}
+	}
+	if len(c.Entries) > 0 {
+		return c.Entries[0]
+	}
+	return nil
+}
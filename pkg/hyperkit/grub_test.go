@@ -0,0 +1,83 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hyperkit
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseGrubCfg(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  string
+		want *GrubEntry
+	}{
+		{
+			name: "first entry",
+			cfg: `set timeout=5
+menuentry 'Live' --class os {
+	linux ($root)/boot/vmlinuz boot=live quiet
+	initrd /boot/initrd.img /boot/ucode.img
+}
+menuentry 'Rescue' {
+	linux /boot/vmlinuz single
+}`,
+			want: &GrubEntry{Title: "Live", Kernel: "/boot/vmlinuz", Initrds: []string{"/boot/initrd.img", "/boot/ucode.img"}, Append: "boot=live quiet"},
+		},
+		{
+			name: "default index",
+			cfg: `set default="1"
+menuentry "Live" {
+	linux /boot/vmlinuz quiet
+}
+menuentry "Rescue" {
+	linuxefi /boot/vmlinuz single
+	initrdefi /boot/initrd
+}`,
+			want: &GrubEntry{Title: "Rescue", Kernel: "/boot/vmlinuz", Initrds: []string{"/boot/initrd"}, Append: "single"},
+		},
+		{
+			name: "default title",
+			cfg: `set default=Rescue
+menuentry Live {
+	linux /boot/vmlinuz quiet
+}
+menuentry Rescue {
+	linux /boot/vmlinuz single
+}`,
+			want: &GrubEntry{Title: "Rescue", Kernel: "/boot/vmlinuz", Append: "single"},
+		},
+		{
+			name: "no entries",
+			cfg:  "set timeout=5\n",
+			want: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := ParseGrubCfg(strings.NewReader(tt.cfg))
+			if err != nil {
+				t.Fatalf("ParseGrubCfg() error = %v", err)
+			}
+			if got := config.DefaultEntry(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DefaultEntry() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
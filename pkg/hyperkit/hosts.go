@@ -0,0 +1,80 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hyperkit
+
+import (
+	"fmt"
+	"net"
+	"os/exec"
+	"regexp"
+
+	"github.com/docker/machine/libmachine/log"
+)
+
+const (
+	// HostsPath is the path to the hosts file
+	HostsPath = "/etc/hosts"
+	// HostsDomain is the domain of the machine host names in the hosts file
+	HostsDomain = "hyperkit.local"
+)
+
+var hostLabelRegexp = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?$`)
+
+// ValidateHostLabel returns an error unless name can be used as the first label of a host name
+func ValidateHostLabel(name string) error {
+	if !hostLabelRegexp.MatchString(name) {
+		return fmt.Errorf("%q is not a valid host name; only letters, digits and inner hyphens are allowed", name)
+	}
+	return nil
+}
+
+// HostName returns the host name of the machine in the hosts file
+func HostName(machineName string) string {
+	return machineName + "." + HostsDomain
+}
+
+func hostsIdentifier(machineName string) string {
+	return "docker-machine-driver-hyperkit " + machineName
+}
+
+// AddHostsEntry maps the host name of the machine to ip in the hosts file at path,
+// replacing any previous entry of the machine
+func AddHostsEntry(path, machineName, ip string) error {
+	if err := ValidateHostLabel(machineName); err != nil {
+		return err
+	}
+	if net.ParseIP(ip) == nil {
+		return fmt.Errorf("invalid IP address %q", ip)
+	}
+	entry := fmt.Sprintf("%s\t%s", ip, HostName(machineName))
+	return updateManagedBlock(path, hostsIdentifier(machineName), entry, 0644)
+}
+
+// RemoveHostsEntry removes the entry of the machine from the hosts file at path, if there is one
+func RemoveHostsEntry(path, machineName string) error {
+	return updateManagedBlock(path, hostsIdentifier(machineName), "", 0644)
+}
+
+// FlushDNSCache makes macOS pick up changes to the hosts file right away
+func FlushDNSCache() {
+	if out, err := exec.Command("dscacheutil", "-flushcache").CombinedOutput(); err != nil {
+		log.Debugf("Flushing the DNS cache failed: %v: %s", err, out)
+	}
+	if out, err := exec.Command("killall", "-HUP", "mDNSResponder").CombinedOutput(); err != nil {
+		log.Debugf("Reloading mDNSResponder failed: %v: %s", err, out)
+	}
+}
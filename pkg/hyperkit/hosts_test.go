@@ -0,0 +1,88 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hyperkit
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHostsEntry(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "")
+	if err != nil {
+		t.Fatalf("TempDir() error = %v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	path := filepath.Join(tmpdir, "hosts")
+	existing := "127.0.0.1\tlocalhost\n255.255.255.255\tbroadcasthost\n"
+	if err := ioutil.WriteFile(path, []byte(existing), 0600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	if err := AddHostsEntry(path, "one", "192.168.64.10"); err != nil {
+		t.Fatalf("AddHostsEntry() error = %v", err)
+	}
+	if err := AddHostsEntry(path, "two", "192.168.64.11"); err != nil {
+		t.Fatalf("AddHostsEntry() error = %v", err)
+	}
+	// Replaces the previous entry of the machine
+	if err := AddHostsEntry(path, "one", "192.168.64.12"); err != nil {
+		t.Fatalf("AddHostsEntry() error = %v", err)
+	}
+	if err := AddHostsEntry(path, "one.two", "192.168.64.13"); err == nil {
+		t.Errorf("AddHostsEntry() should fail for an invalid host name")
+	}
+	if err := AddHostsEntry(path, "three", "192.168.64"); err == nil {
+		t.Errorf("AddHostsEntry() should fail for an invalid IP address")
+	}
+
+	want := existing +
+		"# BEGIN: docker-machine-driver-hyperkit one\n192.168.64.12\tone.hyperkit.local\n# END: docker-machine-driver-hyperkit one\n" +
+		"# BEGIN: docker-machine-driver-hyperkit two\n192.168.64.11\ttwo.hyperkit.local\n# END: docker-machine-driver-hyperkit two\n"
+	if got, _ := ioutil.ReadFile(path); string(got) != want {
+		t.Errorf("hosts = %q, want %q", got, want)
+	}
+
+	if err := RemoveHostsEntry(path, "two"); err != nil {
+		t.Fatalf("RemoveHostsEntry() error = %v", err)
+	}
+	if err := RemoveHostsEntry(path, "one"); err != nil {
+		t.Fatalf("RemoveHostsEntry() error = %v", err)
+	}
+	if got, _ := ioutil.ReadFile(path); string(got) != existing {
+		t.Errorf("hosts = %q, want %q", got, existing)
+	}
+	// The permissions of an existing file are kept
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("hosts file mode = %v, want 0600", info.Mode().Perm())
+	}
+	// Removing an entry that doesn't exist doesn't create the file
+	missing := filepath.Join(tmpdir, "missing")
+	if err := RemoveHostsEntry(missing, "one"); err != nil {
+		t.Fatalf("RemoveHostsEntry() error = %v", err)
+	}
+	if _, err := os.Stat(missing); !os.IsNotExist(err) {
+		t.Errorf("RemoveHostsEntry() created %s", missing)
+	}
+}
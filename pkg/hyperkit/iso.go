@@ -17,74 +17,337 @@ limitations under the License.
 package hyperkit
 
 import (
+	"bytes"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
 
-	"github.com/hooklift/iso9660"
+	"github.com/docker/machine/libmachine/log"
 )
 
-var kernelRegexp = regexp.MustCompile(`(vmlinu[xz]|bz[iI]mage)[\d]*`)
+// combinedInitrdFilename is the name of the initrd created by concatenating multiple initrds
+const combinedInitrdFilename = "initrd-combined.img"
+
+var (
+	kernelRegexp = regexp.MustCompile(`^(vmlinu[xz]|bzimage)`)
+	initrdRegexp = regexp.MustCompile(`^(initrd|initramfs)`)
+	// ignoredBootFileRegexp matches checksums, signatures, bootloader modules and configs that
+	// have names like boot files but cannot be booted
+	ignoredBootFileRegexp = regexp.MustCompile(`\.(md5|sha\d*|sig|asc|gpg|txt|efi|c32|cfg)$`)
+	isoLinuxCfgRegexp     = regexp.MustCompile(`^(iso|sys)linux\.cfg$`)
+	grubCfgRegexp         = regexp.MustCompile(`^grub\.cfg$`)
+)
+
+// maxIsoLinuxIncludeDepth limits nested INCLUDE directives in isolinux configs
+const maxIsoLinuxIncludeDepth = 8
 
 type ISOBootFiles struct {
 	InitrdPath      string
 	KernelPath      string
 	IsoLinuxCfgPath string
+	// Cmdline holds the boot options of the config entry the kernel was taken from
+	Cmdline string
+	// KernelCandidates and InitrdCandidates list all files in the image that look like a kernel or an initrd
+	KernelCandidates []string `json:"-"`
+	InitrdCandidates []string `json:"-"`
 }
 
-func ISOExtractBootFiles(isoPath, destDirPath string) (ISOBootFiles, error) {
-	bootFiles := ISOBootFiles{}
-	iso, err := os.Open(isoPath)
-	if err != nil {
-		return bootFiles, err
+// MissingBootFileError is returned when the kernel or an initrd cannot be found in an ISO image
+type MissingBootFileError struct {
+	ISOPath string
+	// File is either "kernel" or "initrd"
+	File string
+	// Path and ConfigPath are set when a file referenced by a boot config is missing from the image
+	Path       string
+	ConfigPath string
+	Candidates []string
+}
+
+func (e *MissingBootFileError) Error() string {
+	msg := fmt.Sprintf("no %s found in %s", e.File, e.ISOPath)
+	if e.Path != "" {
+		msg = fmt.Sprintf("%s %s referenced by %s not found in %s", e.File, e.Path, e.ConfigPath, e.ISOPath)
 	}
-	defer iso.Close()
+	if len(e.Candidates) > 0 {
+		msg += fmt.Sprintf(" (candidates: %s)", strings.Join(e.Candidates, ", "))
+	}
+	return msg
+}
 
-	r, err := iso9660.NewReader(iso)
+// isoImage is an opened ISO image with an index of its files
+type isoImage struct {
+	file  *os.File
+	files []isoFile
+	// byPath maps lower case paths to files, because plain ISO 9660 names are upper case
+	// while boot configs may refer to them in any case
+	byPath map[string]isoFile
+}
+
+func openISOImage(isoPath string) (*isoImage, error) {
+	f, err := os.Open(isoPath)
 	if err != nil {
-		return bootFiles, err
+		return nil, err
+	}
+	files, err := readISOFiles(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("reading %s: %v", isoPath, err)
+	}
+	image := &isoImage{file: f, files: files, byPath: make(map[string]isoFile)}
+	for _, file := range files {
+		image.byPath[strings.ToLower(file.Path)] = file
 	}
+	return image, nil
+}
+
+func (i *isoImage) Close() error {
+	return i.file.Close()
+}
+
+func (i *isoImage) lookup(filePath string) (isoFile, bool) {
+	file, ok := i.byPath[strings.ToLower(path.Clean("/"+filePath))]
+	return file, ok
+}
+
+func (i *isoImage) reader(file isoFile) io.Reader {
+	return io.NewSectionReader(i.file, file.Offset, file.Size)
+}
 
-	for {
-		f, err := r.Next()
-		if err == io.EOF {
-			break
+// extract writes the content of the files, concatenated, to destPath
+func (i *isoImage) extract(destPath string, files ...isoFile) error {
+	dst, err := os.Create(destPath)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if _, err := io.Copy(dst, i.reader(file)); err != nil {
+			dst.Close()
+			return err
 		}
+	}
+	return dst.Close()
+}
 
-		if f.IsDir() {
+// candidates returns the paths of all bootable looking files whose name matches re,
+// with the most likely candidate (in a boot directory, least nested) first
+func (i *isoImage) candidates(re *regexp.Regexp) []string {
+	var paths []string
+	for _, file := range i.files {
+		lower := strings.ToLower(file.Path)
+		name := path.Base(lower)
+		if strings.Contains(lower, "/efi/") || ignoredBootFileRegexp.MatchString(name) || !re.MatchString(name) {
 			continue
 		}
+		paths = append(paths, file.Path)
+	}
+	rank := func(p string) int {
+		if strings.Contains(strings.ToLower(p), "/boot/") || strings.Contains(strings.ToLower(p), "/isolinux/") {
+			return 0
+		}
+		return 1
+	}
+	sort.SliceStable(paths, func(a, b int) bool {
+		if rank(paths[a]) != rank(paths[b]) {
+			return rank(paths[a]) < rank(paths[b])
+		}
+		if strings.Count(paths[a], "/") != strings.Count(paths[b], "/") {
+			return strings.Count(paths[a], "/") < strings.Count(paths[b], "/")
+		}
+		return paths[a] < paths[b]
+	})
+	return paths
+}
 
-		// For some reason file paths in the ISO sometimes contain a '.' character at the end, so strip that off.
-		destPath := filepath.Join(destDirPath, filepath.Base(strings.TrimSuffix(f.Name(), ".")))
-
-		if kernelRegexp.MatchString(f.Name()) {
-			bootFiles.KernelPath = destPath
-		} else if strings.Contains(f.Name(), "initrd") {
-			bootFiles.InitrdPath = destPath
-		} else if strings.Contains(f.Name(), "isolinux.cfg") {
-			bootFiles.IsoLinuxCfgPath = destPath
-		} else {
-			continue
+// configs returns the paths of all files whose name matches re, least nested first
+func (i *isoImage) configs(re *regexp.Regexp) []string {
+	var paths []string
+	for _, file := range i.files {
+		if re.MatchString(strings.ToLower(path.Base(file.Path))) {
+			paths = append(paths, file.Path)
 		}
+	}
+	sort.SliceStable(paths, func(a, b int) bool {
+		return strings.Count(paths[a], "/") < strings.Count(paths[b], "/")
+	})
+	return paths
+}
 
-		err = func() error {
-			dst, err := os.Create(destPath)
+// readIsoLinuxCfg returns the content of an isolinux config with INCLUDE directives expanded
+func (i *isoImage) readIsoLinuxCfg(cfgPath string, depth int) ([]byte, error) {
+	file, ok := i.lookup(cfgPath)
+	if !ok {
+		return nil, fmt.Errorf("%s not found", cfgPath)
+	}
+	data, err := ioutil.ReadAll(i.reader(file))
+	if err != nil || depth >= maxIsoLinuxIncludeDepth {
+		return data, err
+	}
+	var expanded bytes.Buffer
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && strings.ToLower(fields[0]) == "include" {
+			included, err := i.readIsoLinuxCfg(i.resolve(path.Dir(cfgPath), fields[1]), depth+1)
 			if err != nil {
-				return err
+				log.Debugf("Ignoring include of %s: %v", fields[1], err)
+				continue
 			}
-			defer dst.Close()
+			expanded.Write(included)
+			expanded.WriteString("\n")
+			continue
+		}
+		expanded.WriteString(line)
+		expanded.WriteString("\n")
+	}
+	return expanded.Bytes(), nil
+}
 
-			_, err = io.Copy(dst, f.Sys().(io.Reader))
-			return err
-		}()
+// resolve returns the path of name in the image; relative names are looked up in dir first
+func (i *isoImage) resolve(dir, name string) string {
+	if !strings.HasPrefix(name, "/") {
+		if _, ok := i.lookup(path.Join(dir, name)); ok {
+			return path.Join(dir, name)
+		}
+	}
+	return path.Clean("/" + name)
+}
 
+// bootEntry is the default boot entry of an isolinux or grub config
+type bootEntry struct {
+	configPath string
+	// dir is used to resolve relative kernel and initrd paths
+	dir     string
+	kernel  string
+	initrds []string
+	append  string
+}
+
+// findBootEntry returns the default entry of the first isolinux or grub config that boots a kernel
+func (i *isoImage) findBootEntry() *bootEntry {
+	for _, cfgPath := range i.configs(isoLinuxCfgRegexp) {
+		data, err := i.readIsoLinuxCfg(cfgPath, 0)
+		if err != nil {
+			log.Warnf("Cannot read %s: %v", cfgPath, err)
+			continue
+		}
+		config, err := ParseIsoLinuxCfg(bytes.NewReader(data))
 		if err != nil {
+			log.Warnf("Cannot parse %s: %v", cfgPath, err)
+			continue
+		}
+		if label := config.DefaultLabel(); label != nil && label.Kernel != "" {
+			return &bootEntry{configPath: cfgPath, dir: path.Dir(cfgPath), kernel: label.Kernel, initrds: label.Initrds, append: label.Append}
+		}
+	}
+	for _, cfgPath := range i.configs(grubCfgRegexp) {
+		file, _ := i.lookup(cfgPath)
+		config, err := ParseGrubCfg(i.reader(file))
+		if err != nil {
+			log.Warnf("Cannot parse %s: %v", cfgPath, err)
+			continue
+		}
+		if entry := config.DefaultEntry(); entry != nil && entry.Kernel != "" {
+			return &bootEntry{configPath: cfgPath, dir: "/", kernel: entry.Kernel, initrds: entry.Initrds, append: entry.Append}
+		}
+	}
+	return nil
+}
+
+// ISOExtractBootFiles extracts the kernel and initrd from the ISO image into destDirPath.
+// Files referenced by the default entry of an isolinux or grub config are preferred; otherwise
+// the most likely looking kernel and initrd files are used. Multiple initrds are concatenated
+// into a single file. A *MissingBootFileError is returned when no kernel (or a referenced
+// initrd) can be found.
+func ISOExtractBootFiles(isoPath, destDirPath string) (ISOBootFiles, error) {
+	bootFiles := ISOBootFiles{}
+	image, err := openISOImage(isoPath)
+	if err != nil {
+		return bootFiles, err
+	}
+	defer image.Close()
+
+	bootFiles.KernelCandidates = image.candidates(kernelRegexp)
+	bootFiles.InitrdCandidates = image.candidates(initrdRegexp)
+
+	var kernel isoFile
+	var initrds []isoFile
+	if entry := image.findBootEntry(); entry != nil {
+		log.Debugf("Using boot entry from %s: kernel %s, initrds %v", entry.configPath, entry.kernel, entry.initrds)
+		var ok bool
+		if kernel, ok = image.lookup(image.resolve(entry.dir, entry.kernel)); !ok {
+			return bootFiles, &MissingBootFileError{ISOPath: isoPath, File: "kernel", Path: entry.kernel,
+				ConfigPath: entry.configPath, Candidates: bootFiles.KernelCandidates}
+		}
+		for _, name := range entry.initrds {
+			initrd, ok := image.lookup(image.resolve(entry.dir, name))
+			if !ok {
+				return bootFiles, &MissingBootFileError{ISOPath: isoPath, File: "initrd", Path: name,
+					ConfigPath: entry.configPath, Candidates: bootFiles.InitrdCandidates}
+			}
+			initrds = append(initrds, initrd)
+		}
+		bootFiles.Cmdline = entry.append
+		if isoLinuxCfgRegexp.MatchString(strings.ToLower(path.Base(entry.configPath))) {
+			cfg, _ := image.lookup(entry.configPath)
+			bootFiles.IsoLinuxCfgPath = filepath.Join(destDirPath, "isolinux.cfg")
+			if err := image.extract(bootFiles.IsoLinuxCfgPath, cfg); err != nil {
+				return bootFiles, err
+			}
+		}
+	} else {
+		if len(bootFiles.KernelCandidates) == 0 {
+			return bootFiles, &MissingBootFileError{ISOPath: isoPath, File: "kernel"}
+		}
+		if len(bootFiles.KernelCandidates) > 1 || len(bootFiles.InitrdCandidates) > 1 {
+			log.Infof("No boot config found in %s; kernel candidates: %v, initrd candidates: %v",
+				isoPath, bootFiles.KernelCandidates, bootFiles.InitrdCandidates)
+		}
+		kernel, _ = image.lookup(bootFiles.KernelCandidates[0])
+		if len(bootFiles.InitrdCandidates) > 0 {
+			initrd, _ := image.lookup(bootFiles.InitrdCandidates[0])
+			initrds = append(initrds, initrd)
+		}
+	}
+
+	bootFiles.KernelPath = filepath.Join(destDirPath, path.Base(kernel.Path))
+	if err := image.extract(bootFiles.KernelPath, kernel); err != nil {
+		return bootFiles, err
+	}
+	switch len(initrds) {
+	case 0:
+	case 1:
+		bootFiles.InitrdPath = filepath.Join(destDirPath, path.Base(initrds[0].Path))
+	default:
+		// The kernel unpacks all cpio archives it finds in the initrd, so they can simply be concatenated
+		bootFiles.InitrdPath = filepath.Join(destDirPath, combinedInitrdFilename)
+	}
+	if bootFiles.InitrdPath != "" {
+		if err := image.extract(bootFiles.InitrdPath, initrds...); err != nil {
 			return bootFiles, err
 		}
 	}
+	return bootFiles, nil
+}
 
-	return bootFiles, err
+// ExtractFile extracts the file at srcPath in the ISO image to destPath
+func ExtractFile(isoPath, srcPath, destPath string) error {
+	if srcPath == "" {
+		return fmt.Errorf("no file to extract from %s", isoPath)
+	}
+	image, err := openISOImage(isoPath)
+	if err != nil {
+		return err
+	}
+	defer image.Close()
+
+	file, ok := image.lookup(srcPath)
+	if !ok {
+		return fmt.Errorf("%s not found in %s", srcPath, isoPath)
+	}
+	return image.extract(destPath, file)
 }
@@ -19,6 +19,8 @@ package hyperkit
 import (
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
 )
 
@@ -94,3 +96,66 @@ func TestExtractFile(t *testing.T) {
 		})
 	}
 }
+
+func TestISOExtractBootFiles(t *testing.T) {
+	testDir, err := ioutil.TempDir(os.TempDir(), "")
+	if err != nil {
+		t.Fatalf("TempDir() error = %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	// iso_boot_test.iso has Rock Ridge and Joliet names. Its isolinux.cfg includes menu.cfg,
+	// which boots the kernel with two initrds, and it contains decoys like initrd.md5,
+	// EFI/BOOT/bzImage.efi and docs/vmlinuz-notes.txt.
+	files, err := ISOExtractBootFiles("iso_boot_test.iso", testDir)
+	if err != nil {
+		t.Fatalf("ISOExtractBootFiles() error = %v", err)
+	}
+	if want := filepath.Join(testDir, "vmlinuz-5.10.0-custom"); files.KernelPath != want {
+		t.Errorf("KernelPath = %q, want %q", files.KernelPath, want)
+	}
+	if want := filepath.Join(testDir, combinedInitrdFilename); files.InitrdPath != want {
+		t.Errorf("InitrdPath = %q, want %q", files.InitrdPath, want)
+	}
+	initrd, err := ioutil.ReadFile(files.InitrdPath)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if want := "first\nsecond\n"; string(initrd) != want {
+		t.Errorf("initrd content = %q, want %q", initrd, want)
+	}
+	if want := "console=ttyS0 quiet"; files.Cmdline != want {
+		t.Errorf("Cmdline = %q, want %q", files.Cmdline, want)
+	}
+	if want := []string{"/boot/vmlinuz-5.10.0-custom"}; !reflect.DeepEqual(files.KernelCandidates, want) {
+		t.Errorf("KernelCandidates = %v, want %v", files.KernelCandidates, want)
+	}
+	if want := []string{"/boot/initrd.img"}; !reflect.DeepEqual(files.InitrdCandidates, want) {
+		t.Errorf("InitrdCandidates = %v, want %v", files.InitrdCandidates, want)
+	}
+
+	_, err = ISOExtractBootFiles("iso_test.iso", testDir)
+	if missing, ok := err.(*MissingBootFileError); !ok || missing.File != "kernel" {
+		t.Errorf("ISOExtractBootFiles() error = %v, want a missing kernel error", err)
+	}
+}
+
+func TestExtractFileLongName(t *testing.T) {
+	testDir, err := ioutil.TempDir(os.TempDir(), "")
+	if err != nil {
+		t.Fatalf("TempDir() error = %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	destPath := filepath.Join(testDir, "kernel")
+	if err := ExtractFile("iso_boot_test.iso", "/BOOT/vmlinuz-5.10.0-CUSTOM", destPath); err != nil {
+		t.Fatalf("ExtractFile() error = %v", err)
+	}
+	data, err := ioutil.ReadFile(destPath)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if string(data) != "kernel\n" {
+		t.Errorf("extracted content = %q, want %q", data, "kernel\n")
+	}
+}
@@ -0,0 +1,300 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hyperkit
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"unicode/utf16"
+)
+
+// This file implements just enough of ISO 9660 (ECMA-119) with the Rock Ridge and Joliet
+// extensions to list the files of an image under their long names and read their content.
+
+const (
+	isoSectorSize         = 2048
+	isoFirstVolumeSector  = 16
+	isoMaxVolumeSectors   = 64
+	isoMaxDirectoryDepth  = 32
+	isoDirectoryFlag      = 0x02
+	isoVolumePrimary      = 1
+	isoVolumeSupplemental = 2
+	isoVolumeTerminator   = 255
+)
+
+// isoFile is a regular file inside an ISO image
+type isoFile struct {
+	// Path is the absolute path of the file, using "/" as separator
+	Path   string
+	Offset int64
+	Size   int64
+}
+
+// isoDirRecord is the part of an ISO 9660 directory record needed to locate a file
+type isoDirRecord struct {
+	extent    uint32
+	size      uint32
+	flags     byte
+	name      []byte
+	systemUse []byte
+}
+
+// isoNameDecoder turns the raw name and system use area of a directory record into a file name
+type isoNameDecoder func(r io.ReaderAt, record isoDirRecord) (string, error)
+
+// readISOFiles returns all regular files in the ISO image. It prefers Rock Ridge names, then
+// Joliet names, and falls back to the plain ISO 9660 names (lower case, without version suffix).
+func readISOFiles(r io.ReaderAt) ([]isoFile, error) {
+	var primary, joliet *isoDirRecord
+	for sector := int64(isoFirstVolumeSector); sector < isoFirstVolumeSector+isoMaxVolumeSectors; sector++ {
+		descriptor := make([]byte, isoSectorSize)
+		if _, err := r.ReadAt(descriptor, sector*isoSectorSize); err != nil {
+			return nil, fmt.Errorf("reading volume descriptor: %v", err)
+		}
+		if string(descriptor[1:6]) != "CD001" {
+			return nil, fmt.Errorf("not an ISO 9660 image")
+		}
+		switch descriptor[0] {
+		case isoVolumePrimary:
+			if primary == nil {
+				root, _, err := parseISODirRecord(descriptor[156:190])
+				if err != nil {
+					return nil, err
+				}
+				primary = &root
+			}
+		case isoVolumeSupplemental:
+			// Joliet is identified by one of the UCS-2 escape sequences
+			escape := string(descriptor[88:91])
+			if joliet == nil && (escape == "%/@" || escape == "%/C" || escape == "%/E") {
+				root, _, err := parseISODirRecord(descriptor[156:190])
+				if err != nil {
+					return nil, err
+				}
+				joliet = &root
+			}
+		}
+		if descriptor[0] == isoVolumeTerminator {
+			break
+		}
+	}
+	if primary == nil {
+		return nil, fmt.Errorf("no primary volume descriptor found")
+	}
+
+	hasRockRidge, err := isoHasRockRidge(r, *primary)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case hasRockRidge:
+		return walkISODirectory(r, *primary, "/", 0, rockRidgeName, map[uint32]bool{})
+	case joliet != nil:
+		return walkISODirectory(r, *joliet, "/", 0, jolietName, map[uint32]bool{})
+	default:
+		return walkISODirectory(r, *primary, "/", 0, plainISOName, map[uint32]bool{})
+	}
+}
+
+// parseISODirRecord parses the directory record at the start of data and returns it with its length.
+// A length of 0 marks the unused space at the end of a sector.
+func parseISODirRecord(data []byte) (isoDirRecord, int, error) {
+	var record isoDirRecord
+	if len(data) == 0 || data[0] == 0 {
+		return record, 0, nil
+	}
+	length := int(data[0])
+	if length < 34 || length > len(data) {
+		return record, 0, fmt.Errorf("invalid directory record length %d", length)
+	}
+	nameLen := int(data[32])
+	if 33+nameLen > length {
+		return record, 0, fmt.Errorf("invalid file identifier length %d", nameLen)
+	}
+	record.extent = binary.LittleEndian.Uint32(data[2:6])
+	record.size = binary.LittleEndian.Uint32(data[10:14])
+	record.flags = data[25]
+	record.name = data[33 : 33+nameLen]
+	// The file identifier is padded to an even length
+	systemUse := 33 + nameLen
+	if nameLen%2 == 0 {
+		systemUse++
+	}
+	if systemUse < length {
+		record.systemUse = data[systemUse:length]
+	}
+	return record, length, nil
+}
+
+// readISODirectory returns the records of the directory, without the "." and ".." entries
+func readISODirectory(r io.ReaderAt, dir isoDirRecord) ([]isoDirRecord, error) {
+	data := make([]byte, dir.size)
+	if _, err := r.ReadAt(data, int64(dir.extent)*isoSectorSize); err != nil {
+		return nil, fmt.Errorf("reading directory at sector %d: %v", dir.extent, err)
+	}
+	var records []isoDirRecord
+	for offset := 0; offset < len(data); {
+		record, length, err := parseISODirRecord(data[offset:])
+		if err != nil {
+			return nil, err
+		}
+		if length == 0 {
+			// Records don't cross sector boundaries; continue with the next sector
+			offset = (offset/isoSectorSize + 1) * isoSectorSize
+			continue
+		}
+		offset += length
+		if len(record.name) == 1 && (record.name[0] == 0 || record.name[0] == 1) {
+			continue
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+func walkISODirectory(r io.ReaderAt, dir isoDirRecord, dirPath string, depth int, decode isoNameDecoder, visited map[uint32]bool) ([]isoFile, error) {
+	if depth > isoMaxDirectoryDepth || visited[dir.extent] {
+		return nil, fmt.Errorf("directory loop at %s", dirPath)
+	}
+	visited[dir.extent] = true
+
+	records, err := readISODirectory(r, dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []isoFile
+	for _, record := range records {
+		name, err := decode(r, record)
+		if err != nil {
+			return nil, err
+		}
+		if name == "" {
+			continue
+		}
+		filePath := path.Join(dirPath, name)
+		if record.flags&isoDirectoryFlag != 0 {
+			children, err := walkISODirectory(r, record, filePath, depth+1, decode, visited)
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, children...)
+		} else {
+			files = append(files, isoFile{Path: filePath, Offset: int64(record.extent) * isoSectorSize, Size: int64(record.size)})
+		}
+	}
+	return files, nil
+}
+
+// plainISOName strips the version suffix and the trailing dot of files without extension
+func plainISOName(r io.ReaderAt, record isoDirRecord) (string, error) {
+	name := string(record.name)
+	if i := strings.IndexByte(name, ';'); i >= 0 {
+		name = name[:i]
+	}
+	return strings.ToLower(strings.TrimSuffix(name, ".")), nil
+}
+
+// jolietName decodes the UCS-2 big endian file identifier of the Joliet directory tree
+func jolietName(r io.ReaderAt, record isoDirRecord) (string, error) {
+	units := make([]uint16, len(record.name)/2)
+	for i := range units {
+		units[i] = binary.BigEndian.Uint16(record.name[2*i:])
+	}
+	name := string(utf16.Decode(units))
+	if i := strings.IndexByte(name, ';'); i >= 0 {
+		name = name[:i]
+	}
+	return name, nil
+}
+
+// rockRidgeName returns the name from the Rock Ridge NM entries, or the plain name if there are none
+func rockRidgeName(r io.ReaderAt, record isoDirRecord) (string, error) {
+	var name []byte
+	found := false
+	err := walkSUSPEntries(r, record.systemUse, func(signature string, data []byte) {
+		// NM entries consist of a flags byte and (part of) the name; bit 0 means "continued"
+		if signature == "NM" && len(data) > 0 && data[0]&0x06 == 0 {
+			name = append(name, data[1:]...)
+			found = true
+		}
+	})
+	if err != nil {
+		return "", err
+	}
+	if !found {
+		return plainISOName(r, record)
+	}
+	return string(name), nil
+}
+
+// isoHasRockRidge reports whether the root directory of the tree has Rock Ridge entries
+func isoHasRockRidge(r io.ReaderAt, root isoDirRecord) (bool, error) {
+	data := make([]byte, isoSectorSize)
+	if _, err := r.ReadAt(data, int64(root.extent)*isoSectorSize); err != nil {
+		return false, fmt.Errorf("reading root directory: %v", err)
+	}
+	// The "." entry of the root directory carries the SUSP "SP" and Rock Ridge "ER"/"RR" entries
+	dot, _, err := parseISODirRecord(data)
+	if err != nil {
+		return false, err
+	}
+	found := false
+	err = walkSUSPEntries(r, dot.systemUse, func(signature string, data []byte) {
+		if signature == "ER" || signature == "RR" {
+			found = true
+		}
+	})
+	return found, err
+}
+
+// walkSUSPEntries calls fn for every System Use Sharing Protocol entry, following continuation areas
+func walkSUSPEntries(r io.ReaderAt, data []byte, fn func(signature string, data []byte)) error {
+	for continuations := 0; continuations < 16; continuations++ {
+		var next []byte
+		for len(data) >= 4 {
+			length := int(data[2])
+			if length < 4 || length > len(data) {
+				break
+			}
+			signature := string(data[0:2])
+			entry := data[4:length]
+			if signature == "ST" {
+				break
+			}
+			if signature == "CE" && len(entry) >= 24 {
+				block := binary.LittleEndian.Uint32(entry[0:4])
+				offset := binary.LittleEndian.Uint32(entry[8:12])
+				size := binary.LittleEndian.Uint32(entry[16:20])
+				next = make([]byte, size)
+				if _, err := r.ReadAt(next, int64(block)*isoSectorSize+int64(offset)); err != nil {
+					return fmt.Errorf("reading continuation area: %v", err)
+				}
+			} else {
+				fn(signature, entry)
+			}
+			data = data[length:]
+		}
+		if next == nil {
+			return nil
+		}
+		data = next
+	}
+	return fmt.Errorf("too many continuation areas")
+}
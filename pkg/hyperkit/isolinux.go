@@ -0,0 +1,135 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hyperkit
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strings"
+)
+
+// IsoLinuxLabel is a single boot entry of an isolinux configuration
+type IsoLinuxLabel struct {
+	Name    string
+	Kernel  string
+	Initrds []string
+	// Append is the kernel command line, without any initrd= options
+	Append string
+}
+
+// IsoLinuxConfig is the subset of an isolinux.cfg file needed to boot the default entry
+type IsoLinuxConfig struct {
+	Default string
+	Labels  []*IsoLinuxLabel
+	// menuDefault is the label marked with "MENU DEFAULT", if any
+	menuDefault *IsoLinuxLabel
+}
+
+// ParseIsoLinuxCfgFile parses the isolinux configuration file at path
+func ParseIsoLinuxCfgFile(path string) (*IsoLinuxConfig, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ParseIsoLinuxCfg(file)
+}
+
+// ParseIsoLinuxCfg parses an isolinux configuration. Directives that are not relevant
+// for booting a kernel directly (menus, timeouts, ...) are ignored.
+func ParseIsoLinuxCfg(r io.Reader) (*IsoLinuxConfig, error) {
+	config := &IsoLinuxConfig{}
+	var label *IsoLinuxLabel
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		keyword := strings.ToLower(fields[0])
+		value := strings.TrimSpace(line[len(fields[0]):])
+
+		switch keyword {
+		case "default":
+			config.Default = value
+		case "label":
+			label = &IsoLinuxLabel{Name: value}
+			config.Labels = append(config.Labels, label)
+		case "kernel", "linux":
+			if label != nil {
+				label.Kernel = value
+			}
+		case "initrd":
+			if label != nil {
+				label.Initrds = append(label.Initrds, splitInitrds(value)...)
+			}
+		case "append":
+			if label != nil && value != "-" {
+				label.Append, label.Initrds = parseAppend(value, label.Initrds)
+			}
+		case "menu":
+			if label != nil && len(fields) > 1 && strings.ToLower(fields[1]) == "default" {
+				config.menuDefault = label
+			}
+		}
+	}
+	return config, scanner.Err()
+}
+
+// splitInitrds splits a comma separated list of initrd files
+func splitInitrds(value string) []string {
+	var initrds []string
+	for _, initrd := range strings.Split(value, ",") {
+		if initrd != "" {
+			initrds = append(initrds, initrd)
+		}
+	}
+	return initrds
+}
+
+// parseAppend removes initrd= options from the append line and adds them to the list of initrds
+func parseAppend(value string, initrds []string) (string, []string) {
+	var options []string
+	for _, option := range strings.Fields(value) {
+		if strings.HasPrefix(option, "initrd=") {
+			initrds = append(initrds, splitInitrds(strings.TrimPrefix(option, "initrd="))...)
+		} else {
+			options = append(options, option)
+		}
+	}
+	return strings.Join(options, " "), initrds
+}
+
+// DefaultLabel returns the boot entry that isolinux would boot by default, or nil if there are no entries.
+func (c *IsoLinuxConfig) DefaultLabel() *IsoLinuxLabel {
+	for _, label := range c.Labels {
+		if label.Name == c.Default {
+			return label
+		}
+	}
+	// DEFAULT may also name a menu module (e.g. vesamenu.c32), in which case the menu picks the entry
+	if c.menuDefault != nil {
+		return c.menuDefault
+	}
+	if len(c.Labels) > 0 {
+		return c.Labels[0]
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hyperkit
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseIsoLinuxCfg(t *testing.T) {
+	tests := []struct {
+		name        string
+		cfg         string
+		wantLabel   string
+		wantKernel  string
+		wantInitrds []string
+		wantAppend  string
+	}{
+		{
+			name: "boot2docker",
+			cfg: `display boot.msg
+default boot2docker
+label boot2docker
+	kernel /boot/vmlinuz
+	initrd /boot/initrd.img
+	append loglevel=3 console=ttyS0 console=tty0 noembed nomodeset norestore base
+
+# see http://www.syslinux.org/wiki/index.php/SYSLINUX
+implicit 0
+prompt 1
+timeout 1
+`,
+			wantLabel:   "boot2docker",
+			wantKernel:  "/boot/vmlinuz",
+			wantInitrds: []string{"/boot/initrd.img"},
+			wantAppend:  "loglevel=3 console=ttyS0 console=tty0 noembed nomodeset norestore base",
+		},
+		{
+			name: "initrd in append line",
+			cfg: `DEFAULT second
+LABEL first
+  KERNEL /boot/vmlinuz-old
+  APPEND quiet
+LABEL second
+  LINUX /boot/vmlinuz
+  APPEND initrd=/boot/initrd.gz,/boot/modules.gz root=/dev/ram0 initrd=/boot/extra.gz console=ttyS0
+`,
+			wantLabel:   "second",
+			wantKernel:  "/boot/vmlinuz",
+			wantInitrds: []string{"/boot/initrd.gz", "/boot/modules.gz", "/boot/extra.gz"},
+			wantAppend:  "root=/dev/ram0 console=ttyS0",
+		},
+		{
+			name: "menu default",
+			cfg: `UI vesamenu.c32
+DEFAULT vesamenu.c32
+LABEL live
+  KERNEL /live/vmlinuz
+  APPEND boot=live
+LABEL install
+  MENU LABEL Install
+  MENU DEFAULT
+  KERNEL /install/vmlinuz
+  INITRD /install/initrd.gz
+  APPEND -
+`,
+			wantLabel:   "install",
+			wantKernel:  "/install/vmlinuz",
+			wantInitrds: []string{"/install/initrd.gz"},
+			wantAppend:  "",
+		},
+		{
+			name: "first label",
+			cfg: `label only
+kernel vmlinuz
+`,
+			wantLabel:  "only",
+			wantKernel: "vmlinuz",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := ParseIsoLinuxCfg(strings.NewReader(tt.cfg))
+			if err != nil {
+				t.Fatalf("ParseIsoLinuxCfg() error = %v", err)
+			}
+			label := config.DefaultLabel()
+			if label == nil {
+				t.Fatalf("DefaultLabel() = nil, want %q", tt.wantLabel)
+			}
+			if label.Name != tt.wantLabel {
+				t.Errorf("DefaultLabel() = %q, want %q", label.Name, tt.wantLabel)
+			}
+			if label.Kernel != tt.wantKernel {
+				t.Errorf("Kernel = %q, want %q", label.Kernel, tt.wantKernel)
+			}
+			if !reflect.DeepEqual(label.Initrds, tt.wantInitrds) {
+				t.Errorf("Initrds = %q, want %q", label.Initrds, tt.wantInitrds)
+			}
+			if label.Append != tt.wantAppend {
+				t.Errorf("Append = %q, want %q", label.Append, tt.wantAppend)
+			}
+		})
+	}
+
+	config, err := ParseIsoLinuxCfg(strings.NewReader("default foo\n"))
+	if err != nil {
+		t.Fatalf("ParseIsoLinuxCfg() error = %v", err)
+	}
+	if label := config.DefaultLabel(); label != nil {
+		t.Errorf("DefaultLabel() = %v, want nil", label)
+	}
+}
@@ -0,0 +1,268 @@
+// +build darwin
+
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hyperkit
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/machine/libmachine/log"
+	"github.com/pkg/errors"
+	pkgdrivers "github.com/rancher-sandbox/docker-machine-driver-hyperkit/pkg/drivers"
+)
+
+// LeasesParseError describes a malformed line of the leases file
+type LeasesParseError struct {
+	// Line is the 1-based line number
+	Line   int
+	Text   string
+	Reason string
+}
+
+func (e *LeasesParseError) Error() string {
+	return fmt.Sprintf("dhcp leases file line %d: %s: %q", e.Line, e.Reason, e.Text)
+}
+
+// parseDHCPdLeasesFile parses the bootpd leases file. Entries with malformed lines are skipped,
+// and the first malformed line is returned as a *LeasesParseError along with all valid entries.
+// bootpd may be rewriting the file while it is read, so an unterminated entry at the end of the
+// file is dropped without error.
+func parseDHCPdLeasesFile(file io.Reader) ([]DHCPEntry, error) {
+	var (
+		dhcpEntry   *DHCPEntry
+		dhcpEntries []DHCPEntry
+		firstErr    error
+		// entryErr is the first malformed line of the current entry; it is only reported
+		// when the entry is complete, because a truncated file can end in the middle of a line
+		entryErr error
+	)
+	fail := func(err error) {
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	scanner := bufio.NewScanner(file)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+			continue
+		case line == "{":
+			if dhcpEntry != nil {
+				// The previous entry is incomplete; keep parsing with the new one
+				fail(&LeasesParseError{Line: lineNum, Text: line, Reason: "unexpected start of entry"})
+			}
+			dhcpEntry = new(DHCPEntry)
+			entryErr = nil
+			continue
+		case line == "}":
+			if dhcpEntry == nil {
+				fail(&LeasesParseError{Line: lineNum, Text: line, Reason: "unexpected end of entry"})
+			} else if entryErr != nil {
+				fail(entryErr)
+			} else {
+				dhcpEntries = append(dhcpEntries, *dhcpEntry)
+			}
+			dhcpEntry = nil
+			continue
+		case dhcpEntry == nil:
+			fail(&LeasesParseError{Line: lineNum, Text: line, Reason: "field outside of entry"})
+			continue
+		}
+
+		split := strings.SplitN(line, "=", 2)
+		if len(split) != 2 || split[0] == "" {
+			if entryErr == nil {
+				entryErr = &LeasesParseError{Line: lineNum, Text: line, Reason: "expected key=value"}
+			}
+			continue
+		}
+		key, val := split[0], split[1]
+		switch key {
+		case "name":
+			dhcpEntry.Name = val
+		case "ip_address":
+			dhcpEntry.IPAddress = val
+		case "hw_address":
+			// The hardware type precedes the mac address, e.g. "1,a1:b2:c3:d4:e5:f6"
+			if i := strings.IndexByte(val, ','); i >= 0 {
+				val = val[i+1:]
+			}
+			dhcpEntry.HWAddress = val
+		case "identifier":
+			dhcpEntry.ID = val
+		case "lease":
+			dhcpEntry.Lease = val
+			dhcpEntry.Expiry = parseLeaseExpiry(val)
+		default:
+			if dhcpEntry.Extra == nil {
+				dhcpEntry.Extra = map[string]string{}
+			}
+			dhcpEntry.Extra[key] = val
+		}
+	}
+	if dhcpEntry != nil {
+		log.Debugf("Ignoring unterminated entry at the end of the dhcp leases file: %+v", *dhcpEntry)
+	}
+	if err := scanner.Err(); err != nil {
+		return dhcpEntries, err
+	}
+	return dhcpEntries, firstErr
+}
+
+// parseLeaseExpiry parses the lease expiry, which bootpd stores as a hex encoded Unix timestamp
+func parseLeaseExpiry(lease string) time.Time {
+	seconds, err := strconv.ParseInt(strings.TrimPrefix(lease, "0x"), 16, 64)
+	if err != nil {
+		log.Debugf("Invalid lease expiry %q: %v", lease, err)
+		return time.Time{}
+	}
+	return time.Unix(seconds, 0)
+}
+
+// writeDHCPdLeasesFile writes the entries in the format of the bootpd leases file.
+// Extra fields are written after the known ones, sorted by key.
+func writeDHCPdLeasesFile(w io.Writer, dhcpEntries []DHCPEntry) error {
+	bw := bufio.NewWriter(w)
+	for _, dhcpEntry := range dhcpEntries {
+		fmt.Fprintln(bw, "{")
+		if dhcpEntry.Name != "" {
+			fmt.Fprintf(bw, "\tname=%s\n", dhcpEntry.Name)
+		}
+		if dhcpEntry.IPAddress != "" {
+			fmt.Fprintf(bw, "\tip_address=%s\n", dhcpEntry.IPAddress)
+		}
+		if dhcpEntry.HWAddress != "" {
+			// The hardware type is always 1 (Ethernet); the parser strips it
+			fmt.Fprintf(bw, "\thw_address=1,%s\n", dhcpEntry.HWAddress)
+		}
+		if dhcpEntry.ID != "" {
+			fmt.Fprintf(bw, "\tidentifier=%s\n", dhcpEntry.ID)
+		}
+		if dhcpEntry.Lease != "" {
+			fmt.Fprintf(bw, "\tlease=%s\n", dhcpEntry.Lease)
+		}
+		keys := make([]string, 0, len(dhcpEntry.Extra))
+		for key := range dhcpEntry.Extra {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Fprintf(bw, "\t%s=%s\n", key, dhcpEntry.Extra[key])
+		}
+		fmt.Fprintln(bw, "}")
+	}
+	return bw.Flush()
+}
+
+// readDHCPdLeasesFile parses the leases file at path; a missing file has no entries
+func readDHCPdLeasesFile(path string) ([]DHCPEntry, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return parseDHCPdLeasesFile(file)
+}
+
+// RemoveDHCPLeases removes all leases of the given MAC addresses from the leases file at path.
+// A malformed file is left alone, because rewriting it would drop the malformed entries.
+func RemoveDHCPLeases(path string, macs ...string) error {
+	remove := map[string]bool{}
+	for _, mac := range macs {
+		mac, err := normalizeMacAddress(mac)
+		if err != nil {
+			return err
+		}
+		remove[mac] = true
+	}
+	dhcpEntries, err := readDHCPdLeasesFile(path)
+	if err != nil {
+		return errors.Wrapf(err, "reading %s", path)
+	}
+	var keep []DHCPEntry
+	for _, dhcpEntry := range dhcpEntries {
+		if mac, err := normalizeMacAddress(dhcpEntry.HWAddress); err == nil && remove[mac] {
+			log.Debugf("Removing lease %+v", dhcpEntry)
+			continue
+		}
+		keep = append(keep, dhcpEntry)
+	}
+	if len(keep) == len(dhcpEntries) {
+		return nil
+	}
+	var buf bytes.Buffer
+	if err := writeDHCPdLeasesFile(&buf, keep); err != nil {
+		return err
+	}
+	return pkgdrivers.WriteFileAtomic(path, buf.Bytes(), 0644)
+}
+
+// staleDHCPLeases returns the leases in the file at path whose MAC address is not in owned.
+// The keys of owned must be normalized MAC addresses. Leases without a valid MAC address are kept.
+func staleDHCPLeases(path string, owned map[string]bool) ([]DHCPEntry, error) {
+	dhcpEntries, err := readDHCPdLeasesFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading %s", path)
+	}
+	var stale []DHCPEntry
+	for _, dhcpEntry := range dhcpEntries {
+		mac, err := normalizeMacAddress(dhcpEntry.HWAddress)
+		if err == nil && !owned[mac] {
+			stale = append(stale, dhcpEntry)
+		}
+	}
+	return stale, nil
+}
+
+// PruneDHCPLeases removes all leases from the vmnet leases file whose MAC address is not one
+// of ownedMacs, and returns the removed leases. With dryRun the leases are only returned.
+func PruneDHCPLeases(ownedMacs []string, dryRun bool) ([]DHCPEntry, error) {
+	owned := map[string]bool{}
+	for _, mac := range ownedMacs {
+		mac, err := normalizeMacAddress(mac)
+		if err != nil {
+			return nil, err
+		}
+		owned[mac] = true
+	}
+	stale, err := staleDHCPLeases(LeasesPath, owned)
+	if err != nil || len(stale) == 0 || dryRun {
+		return stale, err
+	}
+	args := []string{"dhcp-leases", "remove"}
+	for _, dhcpEntry := range stale {
+		args = append(args, dhcpEntry.HWAddress)
+	}
+	if out, err := self(args...); err != nil {
+		return nil, fmt.Errorf("%v: %s", err, out)
+	}
+	return stale, nil
+}
@@ -0,0 +1,313 @@
+// +build darwin
+
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hyperkit
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_parseDHCPdLeasesFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		wantIPs  []string
+		wantLine int
+	}{
+		{
+			name:    "valid",
+			input:   string(validLeases),
+			wantIPs: []string{"1.2.3.4", "192.168.64.3", "192.168.64.4"},
+		},
+		{
+			name:    "unknown keys",
+			input:   "{\n\tname=foo\n\tip_address=1.2.3.4\n\tfuture_field=x=y\n}\n",
+			wantIPs: []string{"1.2.3.4"},
+		},
+		{
+			name:     "key before entry",
+			input:    "ip_address=1.2.3.4\n{\n\tip_address=1.2.3.5\n}\n",
+			wantIPs:  []string{"1.2.3.5"},
+			wantLine: 1,
+		},
+		{
+			name:     "malformed line skips entry",
+			input:    "{\n\tip_address=1.2.3.4\n\tgarbage\n}\n\n{\n\tip_address=1.2.3.5\n}\n",
+			wantIPs:  []string{"1.2.3.5"},
+			wantLine: 3,
+		},
+		{
+			name:     "unexpected end of entry",
+			input:    "{\n\tip_address=1.2.3.4\n}\n}\n",
+			wantIPs:  []string{"1.2.3.4"},
+			wantLine: 4,
+		},
+		{
+			name:     "nested entry",
+			input:    "{\n\tip_address=1.2.3.4\n{\n\tip_address=1.2.3.5\n}\n",
+			wantIPs:  []string{"1.2.3.5"},
+			wantLine: 3,
+		},
+		{
+			name:    "truncated",
+			input:   "{\n\tip_address=1.2.3.4\n}\n{\n\tip_addr",
+			wantIPs: []string{"1.2.3.4"},
+		},
+		{
+			name:    "empty",
+			input:   "",
+			wantIPs: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := parseDHCPdLeasesFile(strings.NewReader(tt.input))
+			var ips []string
+			for _, entry := range entries {
+				ips = append(ips, entry.IPAddress)
+			}
+			if !reflect.DeepEqual(ips, tt.wantIPs) {
+				t.Errorf("parseDHCPdLeasesFile() IPs = %v, want %v", ips, tt.wantIPs)
+			}
+			var parseErr *LeasesParseError
+			switch {
+			case tt.wantLine == 0 && err != nil:
+				t.Errorf("parseDHCPdLeasesFile() error = %v", err)
+			case tt.wantLine != 0 && !errors.As(err, &parseErr):
+				t.Errorf("parseDHCPdLeasesFile() error = %v, want *LeasesParseError", err)
+			case tt.wantLine != 0 && parseErr.Line != tt.wantLine:
+				t.Errorf("parseDHCPdLeasesFile() error line = %d, want %d", parseErr.Line, tt.wantLine)
+			}
+		})
+	}
+
+	entries, _ := parseDHCPdLeasesFile(strings.NewReader(tests[1].input))
+	if want := map[string]string{"future_field": "x=y"}; !reflect.DeepEqual(entries[0].Extra, want) {
+		t.Errorf("Extra = %v, want %v", entries[0].Extra, want)
+	}
+}
+
+// randomLeases returns random, well-formed entries as they would be written by bootpd
+func randomLeases(rnd *rand.Rand) []DHCPEntry {
+	word := func() string {
+		const letters = "abcdefghijklmnopqrstuvwxyz0123456789-_."
+		b := make([]byte, 1+rnd.Intn(12))
+		for i := range b {
+			b[i] = letters[rnd.Intn(len(letters))]
+		}
+		return string(b)
+	}
+	mac := func() string {
+		parts := make([]string, 6)
+		for i := range parts {
+			parts[i] = fmt.Sprintf("%x", rnd.Intn(256))
+		}
+		return strings.Join(parts, ":")
+	}
+	entries := make([]DHCPEntry, rnd.Intn(8))
+	for i := range entries {
+		entry := DHCPEntry{
+			Name:      word(),
+			IPAddress: fmt.Sprintf("192.168.64.%d", rnd.Intn(256)),
+			HWAddress: mac(),
+			ID:        "1," + mac(),
+			Lease:     fmt.Sprintf("0x%x", rnd.Int31()),
+		}
+		entry.Expiry = parseLeaseExpiry(entry.Lease)
+		for n := rnd.Intn(3); n > 0; n-- {
+			if entry.Extra == nil {
+				entry.Extra = map[string]string{}
+			}
+			entry.Extra["x_"+word()] = word()
+		}
+		entries[i] = entry
+	}
+	return entries
+}
+
+func Test_parseDHCPdLeasesFile_roundTrip(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 200; i++ {
+		entries := randomLeases(rnd)
+		var buf bytes.Buffer
+		if err := writeDHCPdLeasesFile(&buf, entries); err != nil {
+			t.Fatal(err)
+		}
+		data := buf.Bytes()
+
+		parsed, err := parseDHCPdLeasesFile(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("parseDHCPdLeasesFile() error = %v for\n%s", err, data)
+		}
+		if len(entries) == 0 && len(parsed) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(parsed, entries) {
+			t.Fatalf("parseDHCPdLeasesFile() = %+v, want %+v", parsed, entries)
+		}
+
+		// A file truncated anywhere yields the complete entries before the cut
+		for cut := 0; cut < len(data); cut++ {
+			truncated, err := parseDHCPdLeasesFile(bytes.NewReader(data[:cut]))
+			if err != nil {
+				t.Fatalf("parseDHCPdLeasesFile() error = %v for truncated file\n%s", err, data[:cut])
+			}
+			if len(truncated) > 0 && !reflect.DeepEqual(truncated, entries[:len(truncated)]) {
+				t.Fatalf("parseDHCPdLeasesFile() = %+v for truncated file\n%s", truncated, data[:cut])
+			}
+		}
+	}
+}
+
+func Test_parseDHCPdLeasesFile_garbage(t *testing.T) {
+	fragments := []string{"{", "}", "", "name=foo", "hw_address=1", "hw_address=", "=", "=x", "garbage", " { ", "lease=0xzz", "\x00\xff"}
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 2000; i++ {
+		lines := make([]string, rnd.Intn(20))
+		for j := range lines {
+			if rnd.Intn(10) == 0 {
+				b := make([]byte, rnd.Intn(20))
+				rnd.Read(b)
+				lines[j] = string(b)
+			} else {
+				lines[j] = fragments[rnd.Intn(len(fragments))]
+			}
+		}
+		input := strings.Join(lines, "\n")
+		entries, err := parseDHCPdLeasesFile(strings.NewReader(input))
+		if err == nil {
+			continue
+		}
+		var parseErr *LeasesParseError
+		if !errors.As(err, &parseErr) {
+			t.Fatalf("parseDHCPdLeasesFile() error = %v, want *LeasesParseError for\n%q", err, input)
+		}
+		if lineCount := strings.Count(input, "\n") + 1; parseErr.Line < 1 || parseErr.Line > lineCount {
+			t.Fatalf("parseDHCPdLeasesFile() error line %d out of range 1-%d for\n%q", parseErr.Line, lineCount, input)
+		}
+		if len(entries) > strings.Count(input, "}") {
+			t.Fatalf("parseDHCPdLeasesFile() returned %d entries for\n%q", len(entries), input)
+		}
+	}
+}
+
+func Test_writeDHCPdLeasesFile(t *testing.T) {
+	entries, err := parseDHCPdLeasesFile(bytes.NewReader(validLeases))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := writeDHCPdLeasesFile(&buf, entries); err != nil {
+		t.Fatalf("writeDHCPdLeasesFile() error = %v", err)
+	}
+	if got, want := buf.String(), string(validLeases)+"\n"; got != want {
+		t.Errorf("writeDHCPdLeasesFile() =\n%s\nwant\n%s", got, want)
+	}
+	reparsed, err := parseDHCPdLeasesFile(&buf)
+	if err != nil {
+		t.Fatalf("parse written file: %v", err)
+	}
+	if !reflect.DeepEqual(reparsed, entries) {
+		t.Errorf("round trip = %+v, want %+v", reparsed, entries)
+	}
+}
+
+func leaseMacs(t *testing.T, path string) []string {
+	entries, err := readDHCPdLeasesFile(path)
+	if err != nil {
+		t.Fatalf("readDHCPdLeasesFile: %v", err)
+	}
+	var macs []string
+	for _, entry := range entries {
+		macs = append(macs, entry.HWAddress)
+	}
+	return macs
+}
+
+func TestRemoveDHCPLeases(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "leases")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	path := filepath.Join(tmpdir, "dhcpd_leases")
+
+	if err := RemoveDHCPLeases(path, "a1:b2:c3:d4:e5:f6"); err != nil {
+		t.Errorf("RemoveDHCPLeases() on missing file error = %v", err)
+	}
+	if err := ioutil.WriteFile(path, validLeases, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := RemoveDHCPLeases(path, "not-a-mac"); err == nil {
+		t.Error("RemoveDHCPLeases() with invalid MAC address succeeded")
+	}
+	malformed := filepath.Join(tmpdir, "malformed")
+	if err := ioutil.WriteFile(malformed, append([]byte("garbage\n"), validLeases...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := RemoveDHCPLeases(malformed, "a1:b2:c3:d4:e5:f6"); err == nil {
+		t.Error("RemoveDHCPLeases() of malformed file succeeded")
+	}
+	// MAC addresses are matched regardless of case and leading zeros
+	if err := RemoveDHCPLeases(path, "A1:B2:C3:D4:E5:F6", "a5:b6:c7:d8:e9:01"); err != nil {
+		t.Fatalf("RemoveDHCPLeases() error = %v", err)
+	}
+	if got, want := leaseMacs(t, path), []string{"a4:b5:c6:d7:e8:f9", "a5:b6:c7:d8:e9:f1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("remaining leases = %v, want %v", got, want)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0644 {
+		t.Errorf("mode = %v, want 0644", info.Mode().Perm())
+	}
+}
+
+func Test_staleDHCPLeases(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "leases")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	path := filepath.Join(tmpdir, "dhcpd_leases")
+	if err := ioutil.WriteFile(path, validLeases, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	stale, err := staleDHCPLeases(path, map[string]bool{"a4:b5:c6:d7:e8:f9": true})
+	if err != nil {
+		t.Fatalf("staleDHCPLeases() error = %v", err)
+	}
+	var got []string
+	for _, entry := range stale {
+		got = append(got, entry.IPAddress)
+	}
+	if want := []string{"1.2.3.4", "192.168.64.4"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("staleDHCPLeases() = %v, want %v", got, want)
+	}
+}
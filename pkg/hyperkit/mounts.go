@@ -0,0 +1,147 @@
+// +build darwin
+
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hyperkit
+
+import (
+	"encoding/base64"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/docker/machine/libmachine/log"
+)
+
+const (
+	// bootlocalPath is the boot script of boot2docker that is kept on the data disk
+	bootlocalPath = "/var/lib/boot2docker/bootlocal.sh"
+	// fstabPath is where other distributions get their mounts from at boot
+	fstabPath = "/etc/fstab"
+	// mountsIdentifier identifies the managed block of the share mounts in the guest
+	mountsIdentifier = "docker-machine-driver-hyperkit shares"
+	// nfsClientStart starts the NFS client services of boot2docker, which aren't running by default
+	nfsClientStart = "[ -f /usr/local/etc/init.d/nfs-client ] && sudo /usr/local/etc/init.d/nfs-client start"
+)
+
+// guestMount is the mount of a share in the guest
+type guestMount struct {
+	Source     string
+	MountPoint string
+	FSType     string
+	Options    string
+}
+
+// command returns the shell command mounting m, unless something is mounted there already
+func (m guestMount) command() string {
+	return fmt.Sprintf("sudo mkdir -p %[2]s && { mountpoint -q %[2]s || sudo mount -t %[3]s -o %[4]s '%[1]s' %[2]s; }",
+		m.Source, m.MountPoint, m.FSType, m.Options)
+}
+
+// fstabEntry returns the fstab line of m. The boot doesn't fail when the host doesn't serve the share.
+func (m guestMount) fstabEntry() string {
+	escape := strings.NewReplacer(" ", `\040`, "\t", `\011`)
+	return fmt.Sprintf("%s %s %s %s,nofail 0 0", escape.Replace(m.Source), escape.Replace(m.MountPoint), m.FSType, m.Options)
+}
+
+// mountScript returns the shell script running prelude and mounting mounts
+func mountScript(prelude string, mounts []guestMount) string {
+	lines := []string{}
+	if prelude != "" {
+		lines = append(lines, prelude)
+	}
+	for _, m := range mounts {
+		lines = append(lines, m.command())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// guestIDs returns the user and group id of the SSH user in the guest
+func (d *Driver) guestIDs() (uid, gid int, err error) {
+	out, err := drivers.RunSSHCommandFromDriver(d, "id -u; id -g")
+	if err != nil {
+		return 0, 0, err
+	}
+	fields := strings.Fields(out)
+	if len(fields) != 2 {
+		return 0, 0, fmt.Errorf("unexpected output of id: %q", out)
+	}
+	if uid, err = strconv.Atoi(fields[0]); err != nil {
+		return 0, 0, err
+	}
+	gid, err = strconv.Atoi(fields[1])
+	return uid, gid, err
+}
+
+// mountShares mounts the shares in the guest, and writes the mounts to the guest's boot
+// configuration so that they are restored when the guest reboots by itself
+func (d *Driver) mountShares(prelude string, mounts []guestMount) error {
+	writeScriptCmd := fmt.Sprintf("echo -e \"%s\" | sh", strings.ReplaceAll(mountScript(prelude, mounts), "\n", "\\n"))
+	if _, err := drivers.RunSSHCommandFromDriver(d, writeScriptCmd); err != nil {
+		return err
+	}
+	if err := d.persistMounts(prelude, mounts); err != nil {
+		log.Warnf("Making the %s mounts persistent failed: %v", d.shareType(), err)
+	}
+	return nil
+}
+
+// persistMounts replaces the mounts in the boot script of boot2docker, or in fstab on other
+// distributions, with mounts. Mounts that are no longer shared are removed. BootMounts records
+// whether the guest has mounts to remove later.
+func (d *Driver) persistMounts(prelude string, mounts []guestMount) error {
+	_, err := drivers.RunSSHCommandFromDriver(d, "test -d "+path.Dir(bootlocalPath))
+	boot2docker := err == nil
+
+	file := fstabPath
+	var entries []string
+	if boot2docker {
+		file = bootlocalPath
+		if len(mounts) > 0 {
+			// The boot script runs in the background, so mounting doesn't delay the boot
+			entries = append(entries, mountScript(prelude, mounts))
+		}
+	} else {
+		for _, m := range mounts {
+			entries = append(entries, m.fstabEntry())
+		}
+	}
+
+	content, err := drivers.RunSSHCommandFromDriver(d, fmt.Sprintf("sudo cat %s 2>/dev/null || true", file))
+	if err != nil {
+		return err
+	}
+	updated := setManagedBlock(content, mountsIdentifier, strings.Join(entries, "\n"))
+	if boot2docker && content == "" && updated != "" {
+		updated = "#!/bin/sh\n" + updated
+	}
+	if updated != content {
+		log.Debugf("Updating the mounts in %s", file)
+		writeCmd := fmt.Sprintf("echo %s | base64 -d | sudo tee %s >/dev/null",
+			base64.StdEncoding.EncodeToString([]byte(updated)), file)
+		if boot2docker {
+			writeCmd += " && sudo chmod +x " + file
+		}
+		if _, err := drivers.RunSSHCommandFromDriver(d, writeCmd); err != nil {
+			return err
+		}
+	}
+	d.BootMounts = len(mounts) > 0
+	return nil
+}
@@ -0,0 +1,60 @@
+// +build darwin
+
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hyperkit
+
+import "testing"
+
+func Test_guestMount(t *testing.T) {
+	m := guestMount{Source: "192.168.64.1:/Users/me/My Files", MountPoint: "/mnt/files", FSType: "nfs", Options: "vers=3,noacl,async"}
+	want := "sudo mkdir -p /mnt/files && { mountpoint -q /mnt/files || sudo mount -t nfs -o vers=3,noacl,async '192.168.64.1:/Users/me/My Files' /mnt/files; }"
+	if got := m.command(); got != want {
+		t.Errorf("command() = %q, want %q", got, want)
+	}
+	want = `192.168.64.1:/Users/me/My\040Files /mnt/files nfs vers=3,noacl,async,nofail 0 0`
+	if got := m.fstabEntry(); got != want {
+		t.Errorf("fstabEntry() = %q, want %q", got, want)
+	}
+}
+
+func Test_mountScript(t *testing.T) {
+	mounts := []guestMount{
+		{Source: "share0", MountPoint: "/a", FSType: "9p", Options: "trans=virtio"},
+		{Source: "share1", MountPoint: "/b", FSType: "9p", Options: "trans=virtio"},
+	}
+	want := "sudo mkdir -p /a && { mountpoint -q /a || sudo mount -t 9p -o trans=virtio 'share0' /a; }\n" +
+		"sudo mkdir -p /b && { mountpoint -q /b || sudo mount -t 9p -o trans=virtio 'share1' /b; }"
+	if got := mountScript("", mounts); got != want {
+		t.Errorf("mountScript() = %q, want %q", got, want)
+	}
+	if got := mountScript(nfsClientStart, mounts[:1]); got != nfsClientStart+"\n"+mounts[0].command() {
+		t.Errorf("mountScript() with prelude = %q", got)
+	}
+
+	// The script is kept in a managed block of the boot script, which is replaced when the shares change
+	content := "#!/bin/sh\necho custom\n"
+	content = setManagedBlock(content, mountsIdentifier, mountScript("", mounts))
+	content = setManagedBlock(content, mountsIdentifier, mountScript("", mounts[1:]))
+	want = "#!/bin/sh\necho custom\n# BEGIN: " + mountsIdentifier + "\n" + mounts[1].command() + "\n# END: " + mountsIdentifier + "\n"
+	if content != want {
+		t.Errorf("boot script = %q, want %q", content, want)
+	}
+	if content = setManagedBlock(content, mountsIdentifier, ""); content != "#!/bin/sh\necho custom\n" {
+		t.Errorf("boot script without shares = %q", content)
+	}
+}
@@ -19,16 +19,14 @@ limitations under the License.
 package hyperkit
 
 import (
-	"bufio"
 	"fmt"
-	"io"
 	"net"
 	"os"
-	"os/exec"
 	"regexp"
-	"strings"
+	"time"
 
 	"github.com/docker/machine/libmachine/log"
+	"github.com/pkg/errors"
 )
 
 const (
@@ -36,8 +34,15 @@ const (
 	LeasesPath = "/var/db/dhcpd_leases"
 	// VMNetDomain is the domain for vmnet
 	VMNetDomain = "/Library/Preferences/SystemConfiguration/com.apple.vmnet"
-	// SharedNetAddrKey is the key for the network address
-	SharedNetAddrKey = "Shared_Net_Address"
+	// VMNetPlistPath is the path of the vmnet configuration
+	VMNetPlistPath = VMNetDomain + ".plist"
+
+	// NetworkVMNet attaches the machine to the vmnet shared network
+	NetworkVMNet = "vmnet"
+	// NetworkVPNKit attaches the machine to vpnkit, which proxies the guest traffic in user space
+	NetworkVPNKit = "vpnkit"
+	// NetworkBoth attaches the machine to both vpnkit and vmnet
+	NetworkBoth = "both"
 )
 
 var (
@@ -51,6 +56,15 @@ type DHCPEntry struct {
 	HWAddress string
 	ID        string
 	Lease     string
+	// Expiry is the parsed Lease; it is the zero time if Lease cannot be parsed
+	Expiry time.Time
+	// Extra holds the fields that aren't known to the parser
+	Extra map[string]string
+}
+
+// Expired reports whether the lease has expired at the given time
+func (e DHCPEntry) Expired(now time.Time) bool {
+	return !e.Expiry.After(now)
 }
 
 // GetIPAddressByMACAddress gets the IP address of a MAC address
@@ -58,6 +72,41 @@ func GetIPAddressByMACAddress(mac string) (string, error) {
 	return getIPAddressFromFile(mac, LeasesPath)
 }
 
+// leasePollInterval is how often the machine state is checked while waiting for a lease,
+// and how often the leases file is polled when file system notifications are unavailable
+const leasePollInterval = 500 * time.Millisecond
+
+// waitForIPAddress waits until the leases file at path has a lease for mac and returns its IP address.
+// The file is only re-read when it changes. alive is called periodically and ends the wait with its error,
+// so that a crashed VM is detected right away instead of after the timeout.
+func waitForIPAddress(mac, path string, timeout time.Duration, alive func() error) (string, error) {
+	// The watcher is created before the first read, so that no change goes unnoticed
+	watcher := newFileWatcher(path, leasePollInterval)
+	defer watcher.Close()
+	ticker := time.NewTicker(leasePollInterval)
+	defer ticker.Stop()
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	if err := alive(); err != nil {
+		return "", err
+	}
+	ip, lastErr := getIPAddressFromFile(mac, path)
+	for lastErr != nil {
+		select {
+		case <-watcher.Changes():
+			ip, lastErr = getIPAddressFromFile(mac, path)
+		case <-ticker.C:
+			if err := alive(); err != nil {
+				return "", err
+			}
+		case <-deadline.C:
+			return "", fmt.Errorf("IP address never found in dhcp leases file within %s: %v", timeout, lastErr)
+		}
+	}
+	return ip, nil
+}
+
 func getIPAddressFromFile(mac, path string) (string, error) {
 	log.Debugf("Searching for %s in %s ...", mac, path)
 	file, err := os.Open(path)
@@ -66,60 +115,38 @@ func getIPAddressFromFile(mac, path string) (string, error) {
 	}
 	defer file.Close()
 
-	dhcpEntries, err := parseDHCPdLeasesFile(file)
-	if err != nil {
-		return "", err
+	// Malformed entries are skipped by the parser, so a lease may still be found in the others
+	dhcpEntries, parseErr := parseDHCPdLeasesFile(file)
+	if parseErr != nil {
+		log.Debugf("Error parsing %s: %v", path, parseErr)
 	}
 	log.Debugf("Found %d entries in %s!", len(dhcpEntries), path)
-	for _, dhcpEntry := range dhcpEntries {
-		log.Debugf("dhcp entry: %+v", dhcpEntry)
-		if dhcpEntry.HWAddress == mac {
-			log.Debugf("Found match: %s", mac)
-			return dhcpEntry.IPAddress, nil
-		}
+	if dhcpEntry := findLease(dhcpEntries, mac, time.Now()); dhcpEntry != nil {
+		log.Debugf("Found match: %+v", *dhcpEntry)
+		return dhcpEntry.IPAddress, nil
+	}
+	if parseErr != nil {
+		return "", parseErr
 	}
 	return "", fmt.Errorf("could not find an IP address for %s", mac)
 }
 
-func parseDHCPdLeasesFile(file io.Reader) ([]DHCPEntry, error) {
-	var (
-		dhcpEntry   *DHCPEntry
-		dhcpEntries []DHCPEntry
-	)
-
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line == "{" {
-			dhcpEntry = new(DHCPEntry)
-			continue
-		} else if line == "}" {
-			dhcpEntries = append(dhcpEntries, *dhcpEntry)
+// findLease returns the unexpired lease for mac that expires last. bootpd keeps old leases around,
+// so a MAC address can have several entries with different IPs; expired ones are skipped, because
+// a recreated machine would get the IP address of its predecessor instead of waiting for its own lease.
+func findLease(dhcpEntries []DHCPEntry, mac string, now time.Time) *DHCPEntry {
+	var best *DHCPEntry
+	for i := range dhcpEntries {
+		dhcpEntry := &dhcpEntries[i]
+		log.Debugf("dhcp entry: %+v", *dhcpEntry)
+		if dhcpEntry.HWAddress != mac || dhcpEntry.Expired(now) {
 			continue
 		}
-
-		split := strings.SplitN(line, "=", 2)
-		if len(split) != 2 {
-			return nil, fmt.Errorf("invalid line in dhcp leases file: %s", line)
-		}
-		key, val := split[0], split[1]
-		switch key {
-		case "name":
-			dhcpEntry.Name = val
-		case "ip_address":
-			dhcpEntry.IPAddress = val
-		case "hw_address":
-			// The mac addresses have a '1,' at the start.
-			dhcpEntry.HWAddress = val[2:]
-		case "identifier":
-			dhcpEntry.ID = val
-		case "lease":
-			dhcpEntry.Lease = val
-		default:
-			return dhcpEntries, fmt.Errorf("unable to parse line: %s", line)
+		if best == nil || dhcpEntry.Expiry.After(best.Expiry) {
+			best = dhcpEntry
 		}
 	}
-	return dhcpEntries, scanner.Err()
+	return best
 }
 
 // trimMacAddress trimming "0" of the ten's digit
@@ -127,19 +154,58 @@ func trimMacAddress(rawUUID string) string {
 	return leadingZeroRegexp.ReplaceAllString(rawUUID, "$1")
 }
 
+// ValidateNetwork returns an error unless network is one of the supported network modes
+func ValidateNetwork(network string) error {
+	switch network {
+	case NetworkVMNet, NetworkVPNKit, NetworkBoth:
+		return nil
+	}
+	return fmt.Errorf("invalid network %q; must be %s, %s or %s", network, NetworkVMNet, NetworkVPNKit, NetworkBoth)
+}
+
+// GetVMNetConfig reads the configuration of the vmnet shared network
+func GetVMNetConfig() (*VMNetConfig, error) {
+	return ReadVMNetConfig(VMNetPlistPath)
+}
+
 // GetNetAddr gets the network address for vmnet
 func GetNetAddr() (net.IP, error) {
-	plistPath := VMNetDomain + ".plist"
-	if _, err := os.Stat(plistPath); err != nil {
-		return nil, fmt.Errorf("stat: %v", err)
-	}
-	out, err := exec.Command("defaults", "read", VMNetDomain, SharedNetAddrKey).Output()
+	config, err := GetVMNetConfig()
 	if err != nil {
 		return nil, err
 	}
-	ip := net.ParseIP(strings.TrimSpace(string(out)))
-	if ip == nil {
-		return nil, fmt.Errorf("could not get the network address for vmnet")
+	return config.Address, nil
+}
+
+// ValidateStaticIP returns an error unless ip is a usable host address in the vmnet subnet
+func ValidateStaticIP(ip string) error {
+	config, err := GetVMNetConfig()
+	if err != nil {
+		return errors.Wrap(err, "getting vmnet configuration")
+	}
+	return checkStaticIP(ip, config.Address, config.Mask)
+}
+
+// checkStaticIP returns an error unless ip is in the subnet of the vmnet gateway address,
+// and is neither the gateway itself nor the network or broadcast address of the subnet.
+func checkStaticIP(ip string, gateway net.IP, mask net.IPMask) error {
+	addr := net.ParseIP(ip).To4()
+	if addr == nil {
+		return fmt.Errorf("invalid IPv4 address %q", ip)
+	}
+	subnet := net.IPNet{IP: gateway.To4().Mask(mask), Mask: mask}
+	if !subnet.Contains(addr) {
+		return fmt.Errorf("static IP %s is not in the vmnet subnet %s", ip, subnet.String())
 	}
-	return ip, nil
+	broadcast := make(net.IP, len(subnet.IP))
+	for i := range subnet.IP {
+		broadcast[i] = subnet.IP[i] | ^mask[i]
+	}
+	switch {
+	case addr.Equal(gateway):
+		return fmt.Errorf("static IP %s is the vmnet gateway address", ip)
+	case addr.Equal(subnet.IP), addr.Equal(broadcast):
+		return fmt.Errorf("static IP %s is not a host address of the vmnet subnet %s", ip, subnet.String())
+	}
+	return nil
 }
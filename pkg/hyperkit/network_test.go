@@ -19,33 +19,41 @@ limitations under the License.
 package hyperkit
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"io/ioutil"
+	"net"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 )
 
-var validLeases = []byte(`{
+// validLeaseExpiry is a day from now, because expired leases are ignored
+var validLeaseExpiry = time.Now().Add(24 * time.Hour).Unix()
+
+var validLeases = []byte(fmt.Sprintf(`{
 	name=foo
 	ip_address=1.2.3.4
 	hw_address=1,a1:b2:c3:d4:e5:f6
 	identifier=1,a2:b3:c4:d5:e6:f7
-	lease=0x597e1267
+	lease=0x%[1]x
 }
 {
 	name=bar
 	ip_address=192.168.64.3
 	hw_address=1,a4:b5:c6:d7:e8:f9
 	identifier=1,a0:b0:c0:d0:e0:f0
-	lease=0x597e1267
+	lease=0x%[1]x
 }
 {
 	name=bar
 	ip_address=192.168.64.4
 	hw_address=1,a5:b6:c7:d8:e9:f1
 	identifier=1,a5:b6:c7:d8:e9:f1
-	lease=0x597e1268
-}`)
+	lease=0x%[2]x
+}`, validLeaseExpiry, validLeaseExpiry+1))
 
 func Test_getIpAddressFromFile(t *testing.T) {
 	tmpdir, err := ioutil.TempDir(os.TempDir(), "")
@@ -111,3 +119,141 @@ func Test_getIpAddressFromFile(t *testing.T) {
 		})
 	}
 }
+
+func Test_waitForIPAddress(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "")
+	if err != nil {
+		t.Fatalf("TempDir() error = %v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	dhcpFile := filepath.Join(tmpdir, "dhcp")
+	alive := func() error { return nil }
+
+	// The leases file doesn't exist until the lease is handed out
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		_ = ioutil.WriteFile(dhcpFile, validLeases, 0644)
+	}()
+	ip, err := waitForIPAddress("a1:b2:c3:d4:e5:f6", dhcpFile, 5*time.Second, alive)
+	if err != nil || ip != "1.2.3.4" {
+		t.Errorf("waitForIPAddress() = %q, %v; want %q", ip, err, "1.2.3.4")
+	}
+
+	start := time.Now()
+	if _, err := waitForIPAddress("00:00:00:00:00:01", dhcpFile, 300*time.Millisecond, alive); err == nil {
+		t.Errorf("waitForIPAddress() should time out for an unknown MAC address")
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("waitForIPAddress() took %s to time out", elapsed)
+	}
+
+	crashed := errors.New("hyperkit crashed")
+	if _, err := waitForIPAddress("00:00:00:00:00:01", dhcpFile, 5*time.Second, func() error { return crashed }); err != crashed {
+		t.Errorf("waitForIPAddress() error = %v, want %v", err, crashed)
+	}
+
+	// A crash while waiting is detected by the periodic check, without changes of the file
+	calls := 0
+	crashLater := func() error {
+		if calls++; calls > 2 {
+			return crashed
+		}
+		return nil
+	}
+	if _, err := waitForIPAddress("00:00:00:00:00:01", dhcpFile, 5*time.Second, crashLater); err != crashed {
+		t.Errorf("waitForIPAddress() error = %v, want %v", err, crashed)
+	}
+}
+
+func Test_findLease(t *testing.T) {
+	now := time.Unix(0x60000000, 0)
+	leases := []byte(`{
+	name=old
+	ip_address=192.168.64.2
+	hw_address=1,a1:b2:c3:d4:e5:f6
+	identifier=1,a1:b2:c3:d4:e5:f6
+	lease=0x5fff0000
+}
+{
+	name=current
+	ip_address=192.168.64.5
+	hw_address=1,a1:b2:c3:d4:e5:f6
+	identifier=1,a1:b2:c3:d4:e5:f6
+	lease=0x60000e10
+}
+{
+	name=older
+	ip_address=192.168.64.3
+	hw_address=1,a1:b2:c3:d4:e5:f6
+	identifier=1,a1:b2:c3:d4:e5:f6
+	lease=0x5ffe0000
+}
+{
+	name=expired
+	ip_address=192.168.64.7
+	hw_address=1,a4:b5:c6:d7:e8:f9
+	identifier=1,a4:b5:c6:d7:e8:f9
+	lease=0x5ffe0000
+}
+{
+	name=expired-later
+	ip_address=192.168.64.8
+	hw_address=1,a4:b5:c6:d7:e8:f9
+	identifier=1,a4:b5:c6:d7:e8:f9
+	lease=0x5fff0000
+}`)
+	entries, err := parseDHCPdLeasesFile(bytes.NewReader(leases))
+	if err != nil {
+		t.Fatalf("parseDHCPdLeasesFile() error = %v", err)
+	}
+	if want := time.Unix(0x60000e10, 0); !entries[1].Expiry.Equal(want) {
+		t.Errorf("Expiry = %v, want %v", entries[1].Expiry, want)
+	}
+
+	tests := []struct {
+		name string
+		mac  string
+		want string
+	}{
+		{"active lease wins over newer expired ones", "a1:b2:c3:d4:e5:f6", "192.168.64.5"},
+		{"no lease if all have expired", "a4:b5:c6:d7:e8:f9", ""},
+		{"unknown", "00:00:00:00:00:01", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ""
+			if entry := findLease(entries, tt.mac, now); entry != nil {
+				got = entry.IPAddress
+			}
+			if got != tt.want {
+				t.Errorf("findLease() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_checkStaticIP(t *testing.T) {
+	gateway := net.ParseIP("192.168.64.1")
+	mask := net.CIDRMask(24, 32)
+	tests := []struct {
+		ip      string
+		wantErr bool
+	}{
+		{"192.168.64.10", false},
+		{"192.168.64.254", false},
+		{"192.168.64.1", true},
+		{"192.168.64.0", true},
+		{"192.168.64.255", true},
+		{"192.168.65.10", true},
+		{"fd00::1", true},
+		{"not an ip", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			if err := checkStaticIP(tt.ip, gateway, mask); (err != nil) != tt.wantErr {
+				t.Errorf("checkStaticIP() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hyperkit
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// ExportsPath is the path to the NFS exports file
+	ExportsPath = "/etc/exports"
+	// NFSDPath is the path to the nfsd control utility
+	NFSDPath = "/sbin/nfsd"
+)
+
+// exportsBackup is the content of an exports file before it is changed
+type exportsBackup struct {
+	path   string
+	data   []byte
+	exists bool
+}
+
+// backupExports saves the content of the exports file at path
+func backupExports(path string) (*exportsBackup, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &exportsBackup{path: path}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &exportsBackup{path: path, data: data, exists: true}, nil
+}
+
+// restore puts the saved content back, or removes the exports file if there was none
+func (b *exportsBackup) restore() error {
+	if !b.exists {
+		if err := os.Remove(b.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	return ioutil.WriteFile(b.path, b.data, 0644)
+}
+
+// nfsdStatus is the state of the NFS server reported by "nfsd status"
+type nfsdStatus struct {
+	Enabled bool
+	Running bool
+}
+
+// parseNFSDStatus parses the output of "nfsd status", which has lines like "nfsd service is enabled"
+// and "nfsd is running (pid 1234, 8 threads)"
+func parseNFSDStatus(out string) nfsdStatus {
+	var status nfsdStatus
+	for _, line := range strings.Split(out, "\n") {
+		switch {
+		case strings.HasPrefix(line, "nfsd service is enabled"):
+			status.Enabled = true
+		case strings.HasPrefix(line, "nfsd is running"):
+			status.Running = true
+		}
+	}
+	return status
+}
+
+// applyExports makes the NFS server use the changed exports file of backup. The file is verified
+// with "nfsd checkexports" first and restored from backup if it is invalid, so that a broken
+// file doesn't leave the host without NFS. A running server re-reads the file with "nfsd update",
+// which doesn't interrupt other mounts. Otherwise the server is started if start is set.
+func applyExports(nfsd string, backup *exportsBackup, start bool) error {
+	if out, err := exec.Command(nfsd, "-F", backup.path, "checkexports").CombinedOutput(); err != nil {
+		if restoreErr := backup.restore(); restoreErr != nil {
+			return errors.Wrapf(restoreErr, "restoring %s after invalid exports: %s", backup.path, out)
+		}
+		return fmt.Errorf("invalid exports in %s have been rolled back: %v\n%s", backup.path, err, out)
+	}
+
+	// nfsd status exits with an error when the server isn't running
+	out, err := exec.Command(nfsd, "status").CombinedOutput()
+	if err != nil && len(out) == 0 {
+		return errors.Wrap(err, "getting the nfsd status")
+	}
+	status := parseNFSDStatus(string(out))
+	var action string
+	switch {
+	case status.Running:
+		action = "update"
+	case !start:
+		return nil
+	case !status.Enabled:
+		// Enabling the service also starts it
+		action = "enable"
+	default:
+		action = "start"
+	}
+	if out, err := exec.Command(nfsd, action).CombinedOutput(); err != nil {
+		return fmt.Errorf("nfsd %s failed: %v\n%s", action, err, out)
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hyperkit
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeNFSD writes a script that logs its arguments instead of controlling nfsd
+func fakeNFSD(t *testing.T, dir, status string, checkExit int) (nfsd, logFile string) {
+	nfsd = filepath.Join(dir, "nfsd")
+	logFile = filepath.Join(dir, "nfsd.log")
+	script := fmt.Sprintf(`#!/bin/sh
+echo "$*" >> %s
+case "$*" in
+*checkexports) echo "exports: bad line" ; exit %d ;;
+status) printf '%s' ; exit 0 ;;
+esac
+`, logFile, checkExit, status)
+	if err := ioutil.WriteFile(nfsd, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return nfsd, logFile
+}
+
+func Test_applyExports(t *testing.T) {
+	const (
+		enabledRunning = "nfsd service is enabled\nnfsd is running (pid 1, 8 threads)\n"
+		enabledStopped = "nfsd service is enabled\nnfsd is not running\n"
+		disabled       = "nfsd service is disabled\nnfsd is not running\n"
+	)
+	tests := []struct {
+		name      string
+		status    string
+		checkExit int
+		start     bool
+		exists    bool
+		want      []string
+		wantErr   bool
+	}{
+		{name: "running", status: enabledRunning, start: true, exists: true, want: []string{"checkexports", "status", "update"}},
+		{name: "stopped", status: enabledStopped, start: true, exists: true, want: []string{"checkexports", "status", "start"}},
+		{name: "disabled", status: disabled, start: true, want: []string{"checkexports", "status", "enable"}},
+		{name: "remove stopped", status: enabledStopped, exists: true, want: []string{"checkexports", "status"}},
+		{name: "invalid", status: enabledRunning, checkExit: 1, start: true, exists: true, want: []string{"checkexports"}, wantErr: true},
+		{name: "invalid new file", status: enabledRunning, checkExit: 1, start: true, want: []string{"checkexports"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpdir, err := ioutil.TempDir("", "nfsd")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(tmpdir)
+			nfsd, logFile := fakeNFSD(t, tmpdir, tt.status, tt.checkExit)
+			exports := filepath.Join(tmpdir, "exports")
+			const original = "\"/Users/other\" 192.168.64.3 -alldirs\n"
+			if tt.exists {
+				if err := ioutil.WriteFile(exports, []byte(original), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			backup, err := backupExports(exports)
+			if err != nil {
+				t.Fatalf("backupExports() error = %v", err)
+			}
+			const changed = "\"/Users/me\" 192.168.64.2 -alldirs -bogus\n"
+			if err := ioutil.WriteFile(exports, []byte(changed), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := applyExports(nfsd, backup, tt.start); (err != nil) != tt.wantErr {
+				t.Fatalf("applyExports() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			out, err := ioutil.ReadFile(logFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var actions []string
+			for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+				fields := strings.Fields(line)
+				actions = append(actions, fields[len(fields)-1])
+			}
+			if strings.Join(actions, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("nfsd was run with %v, want %v", actions, tt.want)
+			}
+
+			data, err := ioutil.ReadFile(exports)
+			switch {
+			case !tt.wantErr:
+				if string(data) != changed {
+					t.Errorf("exports = %q, want the changes", data)
+				}
+			case tt.exists:
+				if string(data) != original {
+					t.Errorf("exports = %q, want %q restored", data, original)
+				}
+			default:
+				if !os.IsNotExist(err) {
+					t.Errorf("exports should have been removed, error = %v", err)
+				}
+			}
+		})
+	}
+}
+
+func Test_parseNFSDStatus(t *testing.T) {
+	tests := []struct {
+		out  string
+		want nfsdStatus
+	}{
+		{"nfsd service is enabled\nnfsd is running (pid 123, 8 threads)\n", nfsdStatus{Enabled: true, Running: true}},
+		{"nfsd service is enabled\nnfsd is not running\n", nfsdStatus{Enabled: true}},
+		{"nfsd service is disabled\nnfsd is not running\n", nfsdStatus{}},
+		{"", nfsdStatus{}},
+	}
+	for _, tt := range tests {
+		if got := parseNFSDStatus(tt.out); got != tt.want {
+			t.Errorf("parseNFSDStatus(%q) = %+v, want %+v", tt.out, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hyperkit
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// The options of an NFS share are given after the guest path, as in "local:guest:ro,maproot=0,sync".
+// Options for the export are written to /etc/exports by the privileged helper, so only the
+// options listed here are accepted, with values that can't break out of the export line.
+
+// nfsExportOptions are the share options that apply to the export, and whether they take a value
+var nfsExportOptions = map[string]bool{
+	"ro":      false,
+	"mapall":  true,
+	"maproot": true,
+}
+
+// nfsMountOptions are the share options that apply to the mount in the guest, and whether they take a value
+var nfsMountOptions = map[string]bool{
+	"ro":         false,
+	"rw":         false,
+	"sync":       false,
+	"async":      false,
+	"acl":        false,
+	"noacl":      false,
+	"ac":         false,
+	"noac":       false,
+	"atime":      false,
+	"noatime":    false,
+	"nodiratime": false,
+	"hard":       false,
+	"soft":       false,
+	"intr":       false,
+	"lock":       false,
+	"nolock":     false,
+	"tcp":        false,
+	"udp":        false,
+	"vers":       true,
+	"nfsvers":    true,
+	"proto":      true,
+	"rsize":      true,
+	"wsize":      true,
+	"timeo":      true,
+	"retrans":    true,
+	"actimeo":    true,
+	"acregmin":   true,
+	"acregmax":   true,
+	"acdirmin":   true,
+	"acdirmax":   true,
+}
+
+// defaultNFSMountOptions are the mount options used unless a share overrides them
+var defaultNFSMountOptions = []string{"vers=3", "noacl", "async"}
+
+// nfsMountOptionGroups are the mount options that override each other
+var nfsMountOptionGroups = [][]string{
+	{"vers", "nfsvers"},
+	{"acl", "noacl"},
+	{"sync", "async"},
+	{"ro", "rw"},
+}
+
+// nfsOptionValue matches option values: user and group names or ids separated by colons, and numbers
+var nfsOptionValue = regexp.MustCompile(`^[A-Za-z0-9_.-]+(:[A-Za-z0-9_.-]+)*$`)
+
+// ParseNFSOptions splits the comma-separated options of a share into export and mount options.
+// "ro" applies to both.
+func ParseNFSOptions(options string) (export []string, mount []string, err error) {
+	if options == "" {
+		return nil, nil, nil
+	}
+	mappings := 0
+	for _, option := range strings.Split(options, ",") {
+		name, _, _ := splitNFSOption(option)
+		if name == "mapall" || name == "maproot" {
+			if mappings++; mappings > 1 {
+				return nil, nil, fmt.Errorf("only one of the NFS options mapall and maproot can be used")
+			}
+		}
+		exportValue, isExport := nfsExportOptions[name]
+		mountValue, isMount := nfsMountOptions[name]
+		if !isExport && !isMount {
+			return nil, nil, fmt.Errorf("unsupported NFS option %q", option)
+		}
+		if err := validateNFSOption(option, exportValue || mountValue); err != nil {
+			return nil, nil, err
+		}
+		if isExport {
+			export = append(export, option)
+		}
+		if isMount {
+			mount = append(mount, option)
+		}
+	}
+	return export, mount, nil
+}
+
+// splitNFSOption splits an option into its name and value
+func splitNFSOption(option string) (name, value string, hasValue bool) {
+	i := strings.Index(option, "=")
+	if i < 0 {
+		return option, "", false
+	}
+	return option[:i], option[i+1:], true
+}
+
+// validateNFSOption checks that option has a valid value if it takes one, and none otherwise
+func validateNFSOption(option string, takesValue bool) error {
+	_, value, hasValue := splitNFSOption(option)
+	if hasValue != takesValue {
+		if takesValue {
+			return fmt.Errorf("NFS option %q requires a value", option)
+		}
+		return fmt.Errorf("NFS option %q doesn't take a value", option)
+	}
+	if hasValue && !nfsOptionValue.MatchString(value) {
+		return fmt.Errorf("invalid value in NFS option %q", option)
+	}
+	return nil
+}
+
+// nfsMountOptionString returns the mount options of a share: the defaults, overridden by the
+// share's options
+func nfsMountOptionString(mount []string) string {
+	overridden := map[string]bool{}
+	for _, option := range mount {
+		name, _, _ := splitNFSOption(option)
+		overridden[name] = true
+		for _, group := range nfsMountOptionGroups {
+			for _, member := range group {
+				if member == name {
+					for _, other := range group {
+						overridden[other] = true
+					}
+				}
+			}
+		}
+	}
+	var options []string
+	for _, option := range defaultNFSMountOptions {
+		if name, _, _ := splitNFSOption(option); !overridden[name] {
+			options = append(options, option)
+		}
+	}
+	return strings.Join(append(options, mount...), ",")
+}
+
+// NFSExportLine returns the /etc/exports line sharing path with ip. The files are accessed as
+// user, unless the options map the users differently. All arguments are validated, because they
+// are passed to the privileged helper.
+func NFSExportLine(path, ip, user string, options []string) (string, error) {
+	if !filepath.IsAbs(path) || strings.ContainsAny(path, "\"\\\n\r") {
+		return "", fmt.Errorf("invalid export path %q", path)
+	}
+	if net.ParseIP(ip) == nil {
+		return "", fmt.Errorf("invalid export IP address %q", ip)
+	}
+	if !nfsOptionValue.MatchString(user) {
+		return "", fmt.Errorf("invalid export user %q", user)
+	}
+	line := fmt.Sprintf("%q %s -alldirs", path, ip)
+	mapping := ""
+	for _, option := range options {
+		name, _, _ := splitNFSOption(option)
+		takesValue, ok := nfsExportOptions[name]
+		if !ok {
+			return "", fmt.Errorf("unsupported NFS export option %q", option)
+		}
+		if err := validateNFSOption(option, takesValue); err != nil {
+			return "", err
+		}
+		// -mapall and -maproot are mutually exclusive; they replace the default mapping
+		if name == "mapall" || name == "maproot" {
+			if mapping != "" {
+				return "", fmt.Errorf("only one of the NFS options mapall and maproot can be used")
+			}
+			mapping = "-" + option
+			continue
+		}
+		line += " -" + option
+	}
+	if mapping == "" {
+		mapping = "-mapall=" + user
+	}
+	return line + " " + mapping, nil
+}
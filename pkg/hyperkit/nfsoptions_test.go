@@ -0,0 +1,100 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hyperkit
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseNFSOptions(t *testing.T) {
+	tests := []struct {
+		options string
+		export  []string
+		mount   []string
+		wantErr bool
+	}{
+		{options: ""},
+		{options: "ro,maproot=0,sync", export: []string{"ro", "maproot=0"}, mount: []string{"ro", "sync"}},
+		{options: "mapall=nobody:staff,vers=4,rsize=65536", export: []string{"mapall=nobody:staff"}, mount: []string{"vers=4", "rsize=65536"}},
+		{options: "ro,", wantErr: true},
+		{options: "network=10.0.0.0", wantErr: true},
+		{options: "maproot", wantErr: true},
+		{options: "sync=1", wantErr: true},
+		{options: "maproot=0 -alldirs", wantErr: true},
+		{options: "maproot=$(id)", wantErr: true},
+		{options: "maproot=0,mapall=nobody", wantErr: true},
+	}
+	for _, tt := range tests {
+		export, mount, err := ParseNFSOptions(tt.options)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseNFSOptions(%q) error = %v, wantErr %v", tt.options, err, tt.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(export, tt.export) || !reflect.DeepEqual(mount, tt.mount) {
+			t.Errorf("ParseNFSOptions(%q) = %v, %v; want %v, %v", tt.options, export, mount, tt.export, tt.mount)
+		}
+	}
+}
+
+func Test_nfsMountOptionString(t *testing.T) {
+	tests := []struct {
+		mount []string
+		want  string
+	}{
+		{nil, "vers=3,noacl,async"},
+		{[]string{"ro", "sync"}, "vers=3,noacl,ro,sync"},
+		{[]string{"nfsvers=4", "acl", "rsize=65536"}, "async,nfsvers=4,acl,rsize=65536"},
+	}
+	for _, tt := range tests {
+		if got := nfsMountOptionString(tt.mount); got != tt.want {
+			t.Errorf("nfsMountOptionString(%v) = %q, want %q", tt.mount, got, tt.want)
+		}
+	}
+}
+
+func TestNFSExportLine(t *testing.T) {
+	tests := []struct {
+		path    string
+		ip      string
+		user    string
+		options []string
+		want    string
+		wantErr bool
+	}{
+		{path: "/Users/me", ip: "192.168.64.2", user: "me", want: `"/Users/me" 192.168.64.2 -alldirs -mapall=me`},
+		{path: "/Users/me", ip: "192.168.64.2", user: "me", options: []string{"ro", "maproot=0"}, want: `"/Users/me" 192.168.64.2 -alldirs -ro -maproot=0`},
+		{path: "/Users/me", ip: "192.168.64.2", user: "me", options: []string{"mapall=nobody:staff"}, want: `"/Users/me" 192.168.64.2 -alldirs -mapall=nobody:staff`},
+		{path: "/Users/me", ip: "192.168.64.2", user: "me", options: []string{"sync"}, wantErr: true},
+		{path: "/Users/me", ip: "192.168.64.2", user: "me", options: []string{"maproot=0", "mapall=me"}, wantErr: true},
+		{path: "/Users/me", ip: "192.168.64.2", user: "me", options: []string{"maproot=0\n/ -maproot=0"}, wantErr: true},
+		{path: "/Users/me", ip: "192.168.64.2 -network 0.0.0.0", user: "me", wantErr: true},
+		{path: "/Users/me", ip: "192.168.64.2", user: "me -maproot=0", wantErr: true},
+		{path: "Users/me", ip: "192.168.64.2", user: "me", wantErr: true},
+		{path: "/Users/me\"\n/ 192.168.64.2", ip: "192.168.64.2", user: "me", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := NFSExportLine(tt.path, tt.ip, tt.user, tt.options)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("NFSExportLine(%q, %q, %q, %v) error = %v, wantErr %v", tt.path, tt.ip, tt.user, tt.options, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("NFSExportLine(%q, %q, %q, %v) = %q, want %q", tt.path, tt.ip, tt.user, tt.options, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,312 @@
+// +build darwin
+
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hyperkit
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/docker/machine/libmachine/state"
+	"github.com/google/uuid"
+	hyperkit "github.com/moby/hyperkit/go"
+	"github.com/pkg/errors"
+)
+
+// NIC is an additional network interface of the machine. The interfaces are attached after the
+// primary one, so the guest sees them in the order they are configured.
+type NIC struct {
+	// Network is either NetworkVMNet or NetworkVPNKit
+	Network string
+	// IPAddress is the address requested from vpnkit; it is only used for vpnkit interfaces
+	IPAddress string `json:",omitempty"`
+}
+
+// ParseNIC parses a "vmnet", "vpnkit" or "vpnkit:IP" network interface specification
+func ParseNIC(spec string) (NIC, error) {
+	parts := strings.SplitN(spec, ":", 2)
+	nic := NIC{Network: parts[0]}
+	switch nic.Network {
+	case NetworkVMNet:
+		if len(parts) == 2 {
+			return NIC{}, fmt.Errorf("vmnet interface %q cannot request an IP address", spec)
+		}
+	case NetworkVPNKit:
+		if len(parts) == 2 {
+			if net.ParseIP(parts[1]).To4() == nil {
+				return NIC{}, fmt.Errorf("invalid IPv4 address for vpnkit interface %q", spec)
+			}
+			nic.IPAddress = parts[1]
+		}
+	default:
+		return NIC{}, fmt.Errorf("network interface %q must be %s, %s or %s:IP", spec, NetworkVMNet, NetworkVPNKit, NetworkVPNKit)
+	}
+	return nic, nil
+}
+
+func (n NIC) String() string {
+	if n.IPAddress != "" {
+		return n.Network + ":" + n.IPAddress
+	}
+	return n.Network
+}
+
+// Interface describes a network interface of a running machine
+type Interface struct {
+	Network    string
+	MACAddress string
+	// IPAddress is empty if the interface doesn't have an address (yet)
+	IPAddress string
+}
+
+// nicUUID returns the UUID of the extra network interface at index, which is derived from the VM UUID.
+// vpnkit uses it to hand out the same address to the interface every time the machine starts.
+func (d *Driver) nicUUID(index int) string {
+	return uuid.NewSHA1(uuid.Nil, []byte(fmt.Sprintf("%s/nic%d", d.vmUUID(), index))).String()
+}
+
+// nicMACAddress returns the MAC address of the extra vmnet interface at index. vmnet derives the
+// address of the primary interface from the VM UUID, so the extra interfaces use locally
+// administered addresses derived from their own UUIDs instead.
+func (d *Driver) nicMACAddress(index int) net.HardwareAddr {
+	id := uuid.MustParse(d.nicUUID(index))
+	mac := net.HardwareAddr(id[:6])
+	// Set the locally administered bit and clear the multicast bit
+	mac[0] = mac[0]&^0x01 | 0x02
+	return mac
+}
+
+// nicMACFile returns the path of the file vpnkit writes the MAC address of the extra interface at index to
+func (d *Driver) nicMACFile(index int) string {
+	return d.ResolveStorePath(fmt.Sprintf("nic%d.mac", index))
+}
+
+// MACAddresses returns the MAC addresses of all vmnet interfaces of the machine, primary one first
+func (d *Driver) MACAddresses() ([]string, error) {
+	var macs []string
+	if d.usesVMNet() {
+		mac, err := d.MACAddress()
+		if err != nil {
+			return nil, err
+		}
+		macs = append(macs, mac)
+	}
+	for i, nic := range d.ExtraNICs {
+		if nic.Network == NetworkVMNet {
+			macs = append(macs, trimMacAddress(d.nicMACAddress(i).String()))
+		}
+	}
+	return macs, nil
+}
+
+// hasVPNKitNIC reports whether any extra network interface is attached to vpnkit
+func (d *Driver) hasVPNKitNIC() bool {
+	for _, nic := range d.ExtraNICs {
+		if nic.Network == NetworkVPNKit {
+			return true
+		}
+	}
+	return false
+}
+
+// nicDevices returns the hyperkit devices of the extra network interfaces
+func (d *Driver) nicDevices(vpnkitSock string) []hyperkit.Disk {
+	var devices []hyperkit.Disk
+	for i, nic := range d.ExtraNICs {
+		device := &NetworkDevice{Network: nic.Network, UUID: d.nicUUID(i)}
+		if nic.Network == NetworkVMNet {
+			device.MACAddress = d.nicMACAddress(i).String()
+		} else {
+			device.MACFile = d.nicMACFile(i)
+			device.PreferredIPv4 = nic.IPAddress
+			device.VPNKitSock = vpnkitSock
+		}
+		devices = append(devices, device)
+	}
+	return devices
+}
+
+// Interfaces returns all network interfaces of the machine, primary one first. The addresses of
+// vmnet interfaces are looked up in the DHCP leases; the addresses of vpnkit interfaces are
+// queried from the guest, using the MAC address vpnkit assigned to them.
+func (d *Driver) Interfaces() ([]Interface, error) {
+	primary := Interface{Network: d.network(), IPAddress: d.IPAddress}
+	if d.usesVMNet() {
+		mac, err := d.MACAddress()
+		if err != nil {
+			return nil, err
+		}
+		primary.Network = NetworkVMNet
+		primary.MACAddress = mac
+	}
+	interfaces := []Interface{primary}
+	// vpnkit interfaces can only be queried while the machine is running
+	st, err := d.GetState()
+	if err != nil {
+		return nil, err
+	}
+	for i, nic := range d.ExtraNICs {
+		iface := Interface{Network: nic.Network}
+		if nic.Network == NetworkVMNet {
+			iface.MACAddress = trimMacAddress(d.nicMACAddress(i).String())
+			if ip, err := GetIPAddressByMACAddress(iface.MACAddress); err == nil {
+				iface.IPAddress = ip
+			}
+		} else {
+			data, err := ioutil.ReadFile(d.nicMACFile(i))
+			if err != nil && !os.IsNotExist(err) {
+				return nil, err
+			}
+			iface.MACAddress = strings.TrimSpace(string(data))
+			if iface.MACAddress != "" && st == state.Running {
+				ip, err := d.guestIPAddress(iface.MACAddress)
+				if err != nil {
+					return nil, errors.Wrapf(err, "getting address of %s", filepath.Base(d.nicMACFile(i)))
+				}
+				iface.IPAddress = ip
+			}
+		}
+		interfaces = append(interfaces, iface)
+	}
+	return interfaces, nil
+}
+
+// guestIPAddressScript prints the IPv4 addresses of the guest interface with the MAC address %[1]s
+const guestIPAddressScript = `for dev in /sys/class/net/*; do
+  if [ "$(cat $dev/address)" = "%[1]s" ]; then ip -4 -o addr show dev "${dev##*/}"; fi
+done`
+
+var inetRegexp = regexp.MustCompile(`\binet (\d+\.\d+\.\d+\.\d+)/`)
+
+// guestIPAddress returns the first IPv4 address of the guest interface with the given MAC address
+func (d *Driver) guestIPAddress(mac string) (string, error) {
+	hwAddr, err := net.ParseMAC(mac)
+	if err != nil {
+		return "", err
+	}
+	out, err := drivers.RunSSHCommandFromDriver(d, fmt.Sprintf(guestIPAddressScript, hwAddr.String()))
+	if err != nil {
+		return "", err
+	}
+	return parseInetAddress(out), nil
+}
+
+// parseInetAddress returns the first address in the output of "ip -4 -o addr show"
+func parseInetAddress(out string) string {
+	if match := inetRegexp.FindStringSubmatch(out); match != nil {
+		return match[1]
+	}
+	return ""
+}
+
+// NetworkDevice is an additional network interface passed to hyperkit. It is added to the disks
+// of the VM, because hyperkit only configures a single vmnet and vpnkit interface itself.
+type NetworkDevice struct {
+	// RawDisk provides the unexported methods of the hyperkit.Disk interface; they are never
+	// called because all exported ones are overridden.
+	*hyperkit.RawDisk `json:"-"`
+
+	Network       string `json:"nic"`
+	UUID          string `json:"uuid"`
+	MACAddress    string `json:"mac,omitempty"`
+	MACFile       string `json:"mac_file,omitempty"`
+	PreferredIPv4 string `json:"preferred_ipv4,omitempty"`
+	VPNKitSock    string `json:"vpnkit_sock,omitempty"`
+}
+
+// Validate returns an error unless all fields are well-formed, so that they can be passed
+// as hyperkit arguments safely
+func (n *NetworkDevice) Validate() error {
+	if _, err := uuid.Parse(n.UUID); err != nil {
+		return fmt.Errorf("invalid UUID %q", n.UUID)
+	}
+	switch n.Network {
+	case NetworkVMNet:
+		if mac, err := net.ParseMAC(n.MACAddress); err != nil || len(mac) != 6 {
+			return fmt.Errorf("invalid MAC address %q", n.MACAddress)
+		}
+	case NetworkVPNKit:
+		if n.PreferredIPv4 != "" && net.ParseIP(n.PreferredIPv4).To4() == nil {
+			return fmt.Errorf("invalid IPv4 address %q", n.PreferredIPv4)
+		}
+		for _, path := range []string{n.MACFile, n.VPNKitSock} {
+			if !filepath.IsAbs(path) || strings.Contains(path, ",") {
+				return fmt.Errorf("invalid path %q", path)
+			}
+		}
+	default:
+		return fmt.Errorf("invalid network %q", n.Network)
+	}
+	return nil
+}
+
+// AsArgument returns the hyperkit device configuration
+func (n *NetworkDevice) AsArgument() string {
+	if n.Network == NetworkVMNet {
+		// The first option is the name of the device, which vmnet ignores
+		return fmt.Sprintf("virtio-net,vmnet,mac=%s", n.MACAddress)
+	}
+	arg := fmt.Sprintf("virtio-vpnkit,path=%s,uuid=%s,macfile=%s", n.VPNKitSock, n.UUID, n.MACFile)
+	if n.PreferredIPv4 != "" {
+		arg += ",preferred_ipv4=" + n.PreferredIPv4
+	}
+	return arg
+}
+
+// GetPath returns a pseudo path, which keeps hyperkit from assigning a disk image path
+func (n *NetworkDevice) GetPath() string {
+	return "nic:" + n.UUID
+}
+
+// SetPath does nothing
+func (n *NetworkDevice) SetPath(string) {}
+
+// GetSize returns 0
+func (n *NetworkDevice) GetSize() int {
+	return 0
+}
+
+// GetCurrentSize returns 0
+func (n *NetworkDevice) GetCurrentSize() (int, error) {
+	return 0, nil
+}
+
+func (n *NetworkDevice) String() string {
+	return n.AsArgument()
+}
+
+// Exists returns true, because there is no image to create
+func (n *NetworkDevice) Exists() bool {
+	return true
+}
+
+// Ensure does nothing
+func (n *NetworkDevice) Ensure() error {
+	return nil
+}
+
+// Stop does nothing
+func (n *NetworkDevice) Stop() error {
+	return nil
+}
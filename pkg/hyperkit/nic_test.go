@@ -0,0 +1,165 @@
+// +build darwin
+
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hyperkit
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/docker/machine/libmachine/drivers"
+)
+
+func TestParseNIC(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    NIC
+		wantErr bool
+	}{
+		{"vmnet", NIC{Network: NetworkVMNet}, false},
+		{"vpnkit", NIC{Network: NetworkVPNKit}, false},
+		{"vpnkit:192.168.65.10", NIC{Network: NetworkVPNKit, IPAddress: "192.168.65.10"}, false},
+		{"vmnet:192.168.64.10", NIC{}, true},
+		{"vpnkit:fd00::1", NIC{}, true},
+		{"vpnkit:", NIC{}, true},
+		{"both", NIC{}, true},
+		{"", NIC{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := ParseNIC(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseNIC() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("ParseNIC() = %v, want %v", got, tt.want)
+			}
+			if err == nil && got.String() != tt.spec {
+				t.Errorf("String() = %q, want %q", got.String(), tt.spec)
+			}
+		})
+	}
+}
+
+func Test_nicMACAddress(t *testing.T) {
+	d := NewDriver("test", "/tmp/store")
+	d.UUID = "ab3c6dbd-b5cc-4d50-a4d3-ffd2bba12d9e"
+
+	first := d.nicMACAddress(0)
+	if first[0]&0x02 == 0 || first[0]&0x01 != 0 {
+		t.Errorf("nicMACAddress() = %s, want a locally administered unicast address", first)
+	}
+	if again := d.nicMACAddress(0); again.String() != first.String() {
+		t.Errorf("nicMACAddress() = %s, then %s; want a stable address", first, again)
+	}
+	if second := d.nicMACAddress(1); second.String() == first.String() {
+		t.Errorf("nicMACAddress() = %s for two interfaces", first)
+	}
+
+	clone := NewDriver("clone", "/tmp/store")
+	clone.UUID = "0f4f3a50-5e5b-4ad1-9be0-4d7e0a3f3a4c"
+	if other := clone.nicMACAddress(0); other.String() == first.String() {
+		t.Errorf("nicMACAddress() = %s for two machines", first)
+	}
+}
+
+func TestMACAddresses_vpnkit(t *testing.T) {
+	d := NewDriver("test", "/tmp/store")
+	d.UUID = "ab3c6dbd-b5cc-4d50-a4d3-ffd2bba12d9e"
+	d.Network = NetworkVPNKit
+	d.ExtraNICs = []NIC{{Network: NetworkVMNet}, {Network: NetworkVPNKit}}
+
+	// Without a primary vmnet interface, no privileged helper is needed to get the addresses
+	got, err := d.MACAddresses()
+	if err != nil {
+		t.Fatalf("MACAddresses() error = %v", err)
+	}
+	want := trimMacAddress(d.nicMACAddress(0).String())
+	if len(got) != 1 || got[0] != want {
+		t.Errorf("MACAddresses() = %v, want [%s]", got, want)
+	}
+}
+
+func TestNetworkDevice(t *testing.T) {
+	d := NewDriver("test", "/tmp/store")
+	d.BaseDriver = &drivers.BaseDriver{MachineName: "test", StorePath: "/tmp/store"}
+	d.UUID = "ab3c6dbd-b5cc-4d50-a4d3-ffd2bba12d9e"
+	d.ExtraNICs = []NIC{{Network: NetworkVMNet}, {Network: NetworkVPNKit, IPAddress: "192.168.65.10"}}
+
+	devices := d.nicDevices("/tmp/vpnkit.eth.sock")
+	if len(devices) != 2 {
+		t.Fatalf("nicDevices() returned %d devices, want 2", len(devices))
+	}
+	wantArgs := []string{
+		"virtio-net,vmnet,mac=" + d.nicMACAddress(0).String(),
+		"virtio-vpnkit,path=/tmp/vpnkit.eth.sock,uuid=" + d.nicUUID(1) + ",macfile=/tmp/store/machines/test/nic1.mac,preferred_ipv4=192.168.65.10",
+	}
+	for i, device := range devices {
+		if got := device.AsArgument(); got != wantArgs[i] {
+			t.Errorf("AsArgument() = %q, want %q", got, wantArgs[i])
+		}
+		if device.GetPath() == "" {
+			t.Errorf("GetPath() is empty, so hyperkit would assign a disk image")
+		}
+
+		// The privileged hyperkit command unmarshals and validates the devices
+		data, err := json.Marshal(device)
+		if err != nil {
+			t.Fatalf("Marshal() error = %v", err)
+		}
+		if strings.Contains(string(data), `"path"`) {
+			t.Errorf("Marshal() = %s, must not contain a disk path", data)
+		}
+		var nic NetworkDevice
+		if err := json.Unmarshal(data, &nic); err != nil {
+			t.Fatalf("Unmarshal() error = %v", err)
+		}
+		if err := nic.Validate(); err != nil {
+			t.Errorf("Validate() error = %v", err)
+		}
+		if got := nic.AsArgument(); got != wantArgs[i] {
+			t.Errorf("AsArgument() after unmarshaling = %q, want %q", got, wantArgs[i])
+		}
+	}
+
+	invalid := []NetworkDevice{
+		{Network: NetworkVMNet, UUID: "not a uuid", MACAddress: "02:00:00:00:00:01"},
+		{Network: NetworkVMNet, UUID: d.nicUUID(0), MACAddress: "02:00:00:00:00:01,extra"},
+		{Network: NetworkVPNKit, UUID: d.nicUUID(0), MACFile: "/tmp/nic0.mac", VPNKitSock: "/tmp/a,b"},
+		{Network: NetworkVPNKit, UUID: d.nicUUID(0), MACFile: "nic0.mac", VPNKitSock: "/tmp/sock"},
+		{Network: NetworkVPNKit, UUID: d.nicUUID(0), MACFile: "/tmp/nic0.mac", VPNKitSock: "/tmp/sock", PreferredIPv4: "10.0.0.1,x"},
+		{Network: "tap", UUID: d.nicUUID(0)},
+	}
+	for _, nic := range invalid {
+		if err := nic.Validate(); err == nil {
+			t.Errorf("Validate() should fail for %+v", nic)
+		}
+	}
+}
+
+func Test_parseInetAddress(t *testing.T) {
+	out := "3: eth1    inet 192.168.65.10/24 brd 192.168.65.255 scope global eth1\\       valid_lft forever preferred_lft forever\n"
+	if got := parseInetAddress(out); got != "192.168.65.10" {
+		t.Errorf("parseInetAddress() = %q, want %q", got, "192.168.65.10")
+	}
+	if got := parseInetAddress(""); got != "" {
+		t.Errorf("parseInetAddress() = %q, want an empty address", got)
+	}
+}
@@ -0,0 +1,610 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hyperkit
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/docker/go-p9p"
+	"github.com/docker/machine/libmachine/log"
+)
+
+// This file implements the files of a 9P2000 server with go-p9p. hyperkit's virtio-9p device
+// doesn't serve files itself; it forwards the requests of the guest to a unix socket.
+
+const (
+	// p9MaxMsize is the largest message size offered to the client
+	p9MaxMsize = p9p.DefaultMSize
+	// p9IOHeaderSize is the size of the Twrite header, which limits the payload of a message
+	p9IOHeaderSize = 24
+	// p9MaxWalk is the maximum number of path elements in a single walk
+	p9MaxWalk = 16
+	// p9DMSpecial are the mode bits of the file types that can't be created with 9P2000
+	p9DMSpecial = 0x0ff00000
+	// p9Unchanged is the value of the numeric wstat fields that are left unchanged
+	p9Unchanged = ^uint32(0)
+)
+
+var (
+	errP9NoAuth    = errors.New("authentication not required")
+	errP9Opened    = errors.New("fid already opened")
+	errP9NotOpened = errors.New("fid not opened")
+)
+
+// p9Fid is a file referenced by the client
+type p9Fid struct {
+	path string
+	file *os.File
+	// removeOnClunk is set when the file was opened with ORCLOSE
+	removeOnClunk bool
+	// readdir returns the entries of a directory being read
+	readdir *p9p.Readdir
+}
+
+// p9Session serves the files below root to a single connection
+type p9Session struct {
+	root string
+	// mu serializes the requests, which go-p9p handles concurrently
+	mu   sync.Mutex
+	fids map[p9p.Fid]*p9Fid
+}
+
+var _ p9p.Session = &p9Session{}
+
+// Serve9P serves the directory root to the connections accepted by l, until l is closed.
+// Clients can't access files outside of root, even through symbolic links.
+func Serve9P(l net.Listener, root string) error {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return err
+	}
+	if root, err = filepath.EvalSymlinks(root); err != nil {
+		return err
+	}
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			if err := serve9P(conn, root); err != nil {
+				log.Debugf("9p connection for %s ended: %v", root, err)
+			}
+		}()
+	}
+}
+
+// serve9P serves root to conn until the connection is closed. hyperkit connects when the machine
+// starts, but the guest only negotiates the version when it mounts the share, and go-p9p gives up
+// if the version request doesn't arrive within a second. So serving waits for the first request.
+func serve9P(conn net.Conn, root string) error {
+	r := bufio.NewReader(conn)
+	if _, err := r.Peek(1); err != nil {
+		if err == io.EOF {
+			return nil
+		}
+		return err
+	}
+	s := &p9Session{root: root, fids: map[p9p.Fid]*p9Fid{}}
+	defer s.clunkAll()
+	return p9p.ServeConn(context.Background(), bufferedConn{Conn: conn, r: r}, s.handler())
+}
+
+// bufferedConn is a connection whose reads start with the data buffered in r
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// handler dispatches the requests to the session. It answers the version requests that the guest
+// sends when it mounts the share again, because go-p9p only handles the first one. Errors are sent
+// with the messages that the Linux client maps back to error numbers.
+func (s *p9Session) handler() p9p.Handler {
+	dispatch := p9p.Dispatch(s)
+	return p9p.HandlerFunc(func(ctx context.Context, msg p9p.Message) (p9p.Message, error) {
+		if version, ok := msg.(p9p.MessageTversion); ok {
+			return s.version(version), nil
+		}
+		reply, err := dispatch.Handle(ctx, msg)
+		if err != nil {
+			return nil, p9p.MessageRerror{Ename: p9ErrorString(err)}
+		}
+		return reply, nil
+	})
+}
+
+func (s *p9Session) version(msg p9p.MessageTversion) p9p.MessageRversion {
+	// A version request aborts all outstanding I/O
+	s.clunkAll()
+	reply := p9p.MessageRversion{MSize: p9MaxMsize, Version: p9p.DefaultVersion}
+	if msg.MSize < reply.MSize {
+		reply.MSize = msg.MSize
+	}
+	if !strings.HasPrefix(msg.Version, p9p.DefaultVersion) {
+		reply.Version = "unknown"
+	}
+	return reply
+}
+
+// Version returns the message size and version offered to the client
+func (s *p9Session) Version() (int, string) {
+	return p9MaxMsize, p9p.DefaultVersion
+}
+
+// Auth is rejected, because the files are served to the guest only
+func (s *p9Session) Auth(ctx context.Context, afid p9p.Fid, uname, aname string) (p9p.Qid, error) {
+	return p9p.Qid{}, errP9NoAuth
+}
+
+// Attach makes fid refer to the root
+func (s *p9Session) Attach(ctx context.Context, fid, afid p9p.Fid, uname, aname string) (p9p.Qid, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if afid != p9p.NOFID {
+		return p9p.Qid{}, errP9NoAuth
+	}
+	if _, ok := s.fids[fid]; ok {
+		return p9p.Qid{}, p9p.ErrDupfid
+	}
+	fi, err := os.Stat(s.root)
+	if err != nil {
+		return p9p.Qid{}, err
+	}
+	s.fids[fid] = &p9Fid{path: s.root}
+	return p9QidOf(fi), nil
+}
+
+// Walk makes newFid refer to the file reached by walking names from fid
+func (s *p9Session) Walk(ctx context.Context, fid, newFid p9p.Fid, names ...string) ([]p9p.Qid, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(names) > p9MaxWalk {
+		return nil, p9p.ErrWalkLimit
+	}
+	f, ok := s.fids[fid]
+	if !ok {
+		return nil, p9p.ErrUnknownfid
+	}
+	if f.file != nil {
+		return nil, errP9Opened
+	}
+	if _, ok := s.fids[newFid]; ok && newFid != fid {
+		return nil, p9p.ErrDupfid
+	}
+
+	path := f.path
+	var qids []p9p.Qid
+	for i, name := range names {
+		next, fi, err := s.walkName(path, name)
+		if err != nil {
+			if i == 0 {
+				return nil, err
+			}
+			// A partial walk returns the qids of the elements that were found, and doesn't create newFid
+			break
+		}
+		path = next
+		qids = append(qids, p9QidOf(fi))
+	}
+	if len(qids) == len(names) {
+		s.fids[newFid] = &p9Fid{path: path}
+	}
+	return qids, nil
+}
+
+// walkName returns the path and info of the file name in the directory dir. Symbolic links are
+// resolved, and must point to a file below the root.
+func (s *p9Session) walkName(dir, name string) (string, os.FileInfo, error) {
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return "", nil, err
+	}
+	if !fi.IsDir() {
+		return "", nil, syscall.ENOTDIR
+	}
+	var path string
+	switch {
+	case name == "..":
+		path = dir
+		if dir != s.root {
+			path = filepath.Dir(dir)
+		}
+	case !validP9Name(name):
+		return "", nil, syscall.ENOENT
+	default:
+		path, err = filepath.EvalSymlinks(filepath.Join(dir, name))
+		if err != nil {
+			return "", nil, err
+		}
+		if !withinRoot(s.root, path) {
+			return "", nil, syscall.EACCES
+		}
+	}
+	fi, err = os.Stat(path)
+	return path, fi, err
+}
+
+// withinRoot reports whether path is root or below it
+func withinRoot(root, path string) bool {
+	return path == root || strings.HasPrefix(path, root+string(filepath.Separator))
+}
+
+// validP9Name reports whether name can be used as a directory entry
+func validP9Name(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, "/\x00")
+}
+
+// openFlags returns the os.OpenFile flags of a 9P open mode
+func openFlags(mode p9p.Flag) int {
+	flags := os.O_RDONLY
+	switch mode & 3 {
+	case p9p.OWRITE:
+		flags = os.O_WRONLY
+	case p9p.ORDWR:
+		flags = os.O_RDWR
+	}
+	if mode&p9p.OTRUNC != 0 {
+		flags |= os.O_TRUNC
+	}
+	return flags
+}
+
+// Open opens the file of fid
+func (s *p9Session) Open(ctx context.Context, fid p9p.Fid, mode p9p.Flag) (p9p.Qid, uint32, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.fids[fid]
+	if !ok {
+		return p9p.Qid{}, 0, p9p.ErrUnknownfid
+	}
+	if f.file != nil {
+		return p9p.Qid{}, 0, errP9Opened
+	}
+	file, err := os.OpenFile(f.path, openFlags(mode), 0)
+	if err != nil {
+		return p9p.Qid{}, 0, err
+	}
+	return s.opened(f, file, mode)
+}
+
+// opened stores the open file in f, and returns the qid and I/O unit of the open or create reply
+func (s *p9Session) opened(f *p9Fid, file *os.File, mode p9p.Flag) (p9p.Qid, uint32, error) {
+	fi, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return p9p.Qid{}, 0, err
+	}
+	f.file = file
+	f.removeOnClunk = mode&p9p.ORCLOSE != 0
+	return p9QidOf(fi), p9MaxMsize - p9IOHeaderSize, nil
+}
+
+// Create creates the file name in the directory of fid, which then refers to the new file
+func (s *p9Session) Create(ctx context.Context, fid p9p.Fid, name string, perm uint32, mode p9p.Flag) (p9p.Qid, uint32, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.fids[fid]
+	if !ok {
+		return p9p.Qid{}, 0, p9p.ErrUnknownfid
+	}
+	if f.file != nil {
+		return p9p.Qid{}, 0, errP9Opened
+	}
+	if !validP9Name(name) || perm&p9DMSpecial != 0 {
+		return p9p.Qid{}, 0, syscall.EINVAL
+	}
+	path := filepath.Join(f.path, name)
+	var file *os.File
+	var err error
+	if perm&p9p.DMDIR != 0 {
+		if err = os.Mkdir(path, os.FileMode(perm&0777)); err != nil {
+			return p9p.Qid{}, 0, err
+		}
+		file, err = os.Open(path)
+	} else {
+		file, err = os.OpenFile(path, openFlags(mode)|os.O_CREATE|os.O_EXCL, os.FileMode(perm&0777))
+	}
+	if err != nil {
+		return p9p.Qid{}, 0, err
+	}
+	f.path = path
+	return s.opened(f, file, mode)
+}
+
+// Read reads from the open file of fid; go-p9p limits p to the message size
+func (s *p9Session) Read(ctx context.Context, fid p9p.Fid, p []byte, offset int64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.fids[fid]
+	if !ok {
+		return 0, p9p.ErrUnknownfid
+	}
+	if f.file == nil {
+		return 0, errP9NotOpened
+	}
+	fi, err := f.file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if fi.IsDir() {
+		return s.readDir(ctx, f, p, offset)
+	}
+	n, err := f.file.ReadAt(p, offset)
+	if err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
+
+// readDir reads the directory entries of f. Directories can only be read sequentially, so offset
+// must be 0 or the end of the previous read.
+func (s *p9Session) readDir(ctx context.Context, f *p9Fid, p []byte, offset int64) (int, error) {
+	if offset == 0 {
+		infos, err := ioutil.ReadDir(f.path)
+		if err != nil {
+			return 0, err
+		}
+		var dirs []p9p.Dir
+		for _, fi := range infos {
+			if target, err := os.Stat(filepath.Join(f.path, fi.Name())); err == nil {
+				fi = target
+			}
+			dirs = append(dirs, p9StatOf(fi, fi.Name()))
+		}
+		f.readdir = p9p.NewFixedReaddir(p9p.NewCodec(), dirs)
+	}
+	if f.readdir == nil {
+		return 0, syscall.EINVAL
+	}
+	return f.readdir.Read(ctx, p, offset)
+}
+
+// Write writes to the open file of fid
+func (s *p9Session) Write(ctx context.Context, fid p9p.Fid, p []byte, offset int64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.fids[fid]
+	if !ok {
+		return 0, p9p.ErrUnknownfid
+	}
+	if f.file == nil {
+		return 0, errP9NotOpened
+	}
+	return f.file.WriteAt(p, offset)
+}
+
+// Clunk forgets fid
+func (s *p9Session) Clunk(ctx context.Context, fid p9p.Fid) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.fids[fid]
+	if !ok {
+		return p9p.ErrUnknownfid
+	}
+	delete(s.fids, fid)
+	return s.release(f)
+}
+
+// release closes the file of f, and removes it if it was opened with ORCLOSE
+func (s *p9Session) release(f *p9Fid) error {
+	var err error
+	if f.file != nil {
+		err = f.file.Close()
+	}
+	if f.removeOnClunk && f.path != s.root {
+		if removeErr := os.Remove(f.path); err == nil {
+			err = removeErr
+		}
+	}
+	return err
+}
+
+func (s *p9Session) clunkAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for fid, f := range s.fids {
+		_ = s.release(f)
+		delete(s.fids, fid)
+	}
+}
+
+// Remove removes the file of fid, and forgets fid even if that fails
+func (s *p9Session) Remove(ctx context.Context, fid p9p.Fid) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.fids[fid]
+	if !ok {
+		return p9p.ErrUnknownfid
+	}
+	delete(s.fids, fid)
+	f.removeOnClunk = false
+	_ = s.release(f)
+	if f.path == s.root {
+		return syscall.EACCES
+	}
+	return os.Remove(f.path)
+}
+
+// Stat returns the directory entry of the file of fid
+func (s *p9Session) Stat(ctx context.Context, fid p9p.Fid) (p9p.Dir, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.fids[fid]
+	if !ok {
+		return p9p.Dir{}, p9p.ErrUnknownfid
+	}
+	fi, err := os.Stat(f.path)
+	if err != nil {
+		return p9p.Dir{}, err
+	}
+	name := fi.Name()
+	if f.path == s.root {
+		name = "/"
+	}
+	return p9StatOf(fi, name), nil
+}
+
+// WStat changes the mode, length, times and name of the file of fid
+func (s *p9Session) WStat(ctx context.Context, fid p9p.Fid, dir p9p.Dir) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.fids[fid]
+	if !ok {
+		return p9p.ErrUnknownfid
+	}
+	fi, err := os.Stat(f.path)
+	if err != nil {
+		return err
+	}
+	// Fields with all bits set, and empty strings, are left unchanged
+	if dir.Mode != p9Unchanged {
+		if (dir.Mode&p9p.DMDIR != 0) != fi.IsDir() {
+			return syscall.EINVAL
+		}
+		if err := os.Chmod(f.path, os.FileMode(dir.Mode&0777)); err != nil {
+			return err
+		}
+	}
+	if dir.Length != ^uint64(0) {
+		if fi.IsDir() {
+			return syscall.EISDIR
+		}
+		if err := os.Truncate(f.path, int64(dir.Length)); err != nil {
+			return err
+		}
+	}
+	atimeUnchanged, mtimeUnchanged := p9TimeUnchanged(dir.AccessTime), p9TimeUnchanged(dir.ModTime)
+	if !atimeUnchanged || !mtimeUnchanged {
+		atime, mtime := fi.ModTime(), fi.ModTime()
+		if !atimeUnchanged {
+			atime = dir.AccessTime
+		}
+		if !mtimeUnchanged {
+			mtime = dir.ModTime
+		}
+		if err := os.Chtimes(f.path, atime, mtime); err != nil {
+			return err
+		}
+	}
+	if dir.Name != "" && dir.Name != fi.Name() {
+		if !validP9Name(dir.Name) || f.path == s.root {
+			return syscall.EINVAL
+		}
+		newPath := filepath.Join(filepath.Dir(f.path), dir.Name)
+		if err := os.Rename(f.path, newPath); err != nil {
+			return err
+		}
+		// Fids of the file, and of the files below it if it is a directory, refer to the new path
+		oldPath := f.path
+		for _, other := range s.fids {
+			if withinRoot(oldPath, other.path) {
+				other.path = newPath + strings.TrimPrefix(other.path, oldPath)
+			}
+		}
+	}
+	return nil
+}
+
+// p9TimeUnchanged reports whether a wstat time is the all bits set value of the protocol
+func p9TimeUnchanged(t time.Time) bool {
+	return t.Unix() == int64(p9Unchanged)
+}
+
+// p9QidOf returns the qid of a file; the path is the inode number
+func p9QidOf(fi os.FileInfo) p9p.Qid {
+	qid := p9p.Qid{Version: uint32(fi.ModTime().UnixNano()) ^ uint32(fi.Size())}
+	if sys, ok := fi.Sys().(*syscall.Stat_t); ok {
+		qid.Path = uint64(sys.Ino)
+	}
+	if fi.IsDir() {
+		qid.Type = p9p.QTDIR
+	}
+	return qid
+}
+
+func p9StatOf(fi os.FileInfo, name string) p9p.Dir {
+	dir := p9p.Dir{
+		Qid:        p9QidOf(fi),
+		Mode:       uint32(fi.Mode().Perm()),
+		AccessTime: fi.ModTime(),
+		ModTime:    fi.ModTime(),
+		Name:       name,
+	}
+	if fi.IsDir() {
+		dir.Mode |= p9p.DMDIR
+	} else {
+		dir.Length = uint64(fi.Size())
+	}
+	if sys, ok := fi.Sys().(*syscall.Stat_t); ok {
+		dir.UID = strconv.FormatUint(uint64(sys.Uid), 10)
+		dir.GID = strconv.FormatUint(uint64(sys.Gid), 10)
+		dir.MUID = dir.UID
+	}
+	return dir
+}
+
+// p9ErrorString returns the error message sent to the client. The Linux client maps the
+// messages of common errors back to error numbers, so those use the strerror text.
+func p9ErrorString(err error) string {
+	var rerror p9p.MessageRerror
+	if errors.As(err, &rerror) {
+		return rerror.Ename
+	}
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		switch errno {
+		case syscall.ENOENT:
+			return "No such file or directory"
+		case syscall.EPERM:
+			return "Operation not permitted"
+		case syscall.EACCES:
+			return "Permission denied"
+		case syscall.EEXIST:
+			return "File exists"
+		case syscall.ENOTDIR:
+			return "Not a directory"
+		case syscall.EISDIR:
+			return "Is a directory"
+		case syscall.EINVAL:
+			return "Invalid argument"
+		case syscall.ENOTEMPTY:
+			return "Directory not empty"
+		case syscall.ENOSPC:
+			return "No space left on device"
+		case syscall.EROFS:
+			return "Read-only file system"
+		}
+	}
+	return err.Error()
+}
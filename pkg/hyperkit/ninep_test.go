@@ -0,0 +1,311 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hyperkit
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/go-p9p"
+)
+
+// newP9Client returns a go-p9p client session of a server for root, after waiting for delay like
+// the guest waits until it mounts the share
+func newP9Client(t *testing.T, root string, delay time.Duration) (p9p.Session, func()) {
+	server, conn := net.Pipe()
+	go func() {
+		defer server.Close()
+		_ = serve9P(server, root)
+	}()
+	time.Sleep(delay)
+	session, err := p9p.NewSession(context.Background(), conn)
+	if err != nil {
+		conn.Close()
+		t.Fatalf("NewSession() error = %v", err)
+	}
+	return session, func() { conn.Close() }
+}
+
+// p9ErrorMessage returns the message of an Rerror, or "" if err is nil
+func p9ErrorMessage(err error) string {
+	if err == nil {
+		return ""
+	}
+	if rerror, ok := err.(p9p.MessageRerror); ok {
+		return rerror.Ename
+	}
+	return err.Error()
+}
+
+// p9UnchangedDir returns a wstat directory entry that doesn't change anything
+func p9UnchangedDir() p9p.Dir {
+	unchanged := time.Unix(int64(p9Unchanged), 0)
+	return p9p.Dir{Mode: p9Unchanged, Length: ^uint64(0), AccessTime: unchanged, ModTime: unchanged}
+}
+
+func p9Read(t *testing.T, c p9p.Session, fid p9p.Fid, offset int64, count int) []byte {
+	t.Helper()
+	p := make([]byte, count)
+	n, err := c.Read(context.Background(), fid, p, offset)
+	// The client returns io.EOF for an empty reply
+	if err != nil && err != io.EOF {
+		t.Fatalf("Read() error = %v", err)
+	}
+	return p[:n]
+}
+
+func TestServe9P(t *testing.T) {
+	ctx := context.Background()
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "")
+	if err != nil {
+		t.Fatalf("TempDir() error = %v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+	tmpdir, _ = filepath.EvalSymlinks(tmpdir)
+
+	root := filepath.Join(tmpdir, "share")
+	if err := os.MkdirAll(filepath.Join(root, "dir"), 0755); err != nil {
+		t.Fatalf("MkdirAll() error = %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "dir", "hello.txt"), []byte("hello world"), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(tmpdir, "secret"), []byte("secret"), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if err := os.Symlink(filepath.Join(tmpdir, "secret"), filepath.Join(root, "escape")); err != nil {
+		t.Fatalf("Symlink() error = %v", err)
+	}
+	if err := os.Symlink("dir/hello.txt", filepath.Join(root, "link")); err != nil {
+		t.Fatalf("Symlink() error = %v", err)
+	}
+
+	c, closeClient := newP9Client(t, root, 0)
+	defer closeClient()
+
+	if msize, version := c.Version(); msize != p9MaxMsize || version != p9p.DefaultVersion {
+		t.Errorf("Version() = %d %q, want %d %q", msize, version, p9MaxMsize, p9p.DefaultVersion)
+	}
+	if _, err := c.Attach(ctx, 0, 1, "docker", ""); err == nil {
+		t.Errorf("Attach() with authentication succeeded")
+	}
+	if qid, err := c.Attach(ctx, 0, p9p.NOFID, "docker", ""); err != nil || qid.Type != p9p.QTDIR {
+		t.Fatalf("Attach() = %v, %v, want a directory", qid, err)
+	}
+
+	// Reading a file
+	if qids, err := c.Walk(ctx, 0, 1, "dir", "hello.txt"); err != nil || len(qids) != 2 {
+		t.Fatalf("Walk() = %v, %v", qids, err)
+	}
+	if _, _, err := c.Open(ctx, 1, p9p.OREAD); err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	if got := string(p9Read(t, c, 1, 6, 100)); got != "world" {
+		t.Errorf("Read() = %q, want %q", got, "world")
+	}
+	c.Clunk(ctx, 1)
+
+	// Walks can't leave the root
+	// ".." of the root is the root itself, so "secret" is not found
+	if qids, err := c.Walk(ctx, 0, 2, "..", "..", "secret"); err != nil || len(qids) != 2 {
+		t.Errorf("Walk() outside of the root = %v, %v, want a partial walk", qids, err)
+	}
+	if _, err := c.Walk(ctx, 0, 2, "secret"); p9ErrorMessage(err) != "No such file or directory" {
+		t.Errorf("Walk() error = %v", err)
+	}
+	if _, err := c.Walk(ctx, 0, 2, "escape"); p9ErrorMessage(err) != "Permission denied" {
+		t.Errorf("Walk() through a symlink outside of the root error = %v", err)
+	}
+	if _, err := c.Walk(ctx, 0, 2, "link"); err != nil {
+		t.Errorf("Walk() through a symlink inside the root error = %v", err)
+	}
+	c.Clunk(ctx, 2)
+
+	// A partial walk returns the qids found so far and doesn't create the new fid
+	if qids, err := c.Walk(ctx, 0, 3, "dir", "missing"); err != nil || len(qids) != 1 {
+		t.Errorf("partial Walk() = %v, %v", qids, err)
+	}
+	if _, err := c.Stat(ctx, 3); err == nil {
+		t.Errorf("partial Walk() created the new fid")
+	}
+
+	// Creating and writing a file
+	c.Walk(ctx, 0, 4, "dir")
+	if _, _, err := c.Create(ctx, 4, "new.txt", 0600, p9p.ORDWR); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if n, err := c.Write(ctx, 4, []byte("data"), 0); err != nil || n != 4 {
+		t.Errorf("Write() = %d, %v", n, err)
+	}
+	c.Clunk(ctx, 4)
+	if got, _ := ioutil.ReadFile(filepath.Join(root, "dir", "new.txt")); string(got) != "data" {
+		t.Errorf("created file contains %q, want %q", got, "data")
+	}
+
+	// Renaming and truncating it
+	c.Walk(ctx, 0, 5, "dir", "new.txt")
+	dir := p9UnchangedDir()
+	dir.Length = 2
+	dir.Name = "renamed.txt"
+	if err := c.WStat(ctx, 5, dir); err != nil {
+		t.Fatalf("WStat() error = %v", err)
+	}
+	if got, _ := ioutil.ReadFile(filepath.Join(root, "dir", "renamed.txt")); string(got) != "da" {
+		t.Errorf("renamed file contains %q, want %q", got, "da")
+	}
+	if st, err := c.Stat(ctx, 5); err != nil || st.Name != "renamed.txt" || st.Length != 2 || st.Mode != 0600 {
+		t.Errorf("Stat() = %v, %v", st, err)
+	}
+
+	// Reading the directory in small chunks
+	c.Walk(ctx, 0, 6, "dir")
+	c.Open(ctx, 6, p9p.OREAD)
+	var names []string
+	var offset int64
+	codec := p9p.NewCodec()
+	for {
+		data := p9Read(t, c, 6, offset, 128)
+		if len(data) == 0 {
+			break
+		}
+		offset += int64(len(data))
+		for len(data) > 0 {
+			size := 2 + int(binary.LittleEndian.Uint16(data))
+			var entry p9p.Dir
+			if err := codec.Unmarshal(data[:size], &entry); err != nil {
+				t.Fatalf("decoding directory entries failed: %v", err)
+			}
+			names = append(names, entry.Name)
+			data = data[size:]
+		}
+	}
+	sort.Strings(names)
+	if got := strings.Join(names, ","); got != "hello.txt,renamed.txt" {
+		t.Errorf("directory entries = %q", got)
+	}
+
+	// Removing the file
+	if err := c.Remove(ctx, 5); err != nil {
+		t.Errorf("Remove() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "dir", "renamed.txt")); !os.IsNotExist(err) {
+		t.Errorf("Remove() didn't remove the file: %v", err)
+	}
+	if err := c.Remove(ctx, 0); err == nil {
+		t.Errorf("Remove() of the root should fail")
+	}
+}
+
+func TestServe9P_mountLater(t *testing.T) {
+	root, err := ioutil.TempDir(os.TempDir(), "")
+	if err != nil {
+		t.Fatalf("TempDir() error = %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	// hyperkit connects when the machine starts, long before the guest mounts the share
+	c, closeClient := newP9Client(t, root, 1500*time.Millisecond)
+	defer closeClient()
+	if _, err := c.Attach(context.Background(), 0, p9p.NOFID, "docker", ""); err != nil {
+		t.Errorf("Attach() error = %v", err)
+	}
+}
+
+func TestServe9P_version(t *testing.T) {
+	root, err := ioutil.TempDir(os.TempDir(), "")
+	if err != nil {
+		t.Fatalf("TempDir() error = %v", err)
+	}
+	defer os.RemoveAll(root)
+	s := &p9Session{root: root, fids: map[p9p.Fid]*p9Fid{}}
+	if _, err := s.Attach(context.Background(), 0, p9p.NOFID, "docker", ""); err != nil {
+		t.Fatalf("Attach() error = %v", err)
+	}
+
+	// Mounting the share again sends another version request, which clunks all fids
+	reply, err := s.handler().Handle(context.Background(), p9p.MessageTversion{MSize: 8192, Version: "9P2000.u"})
+	if err != nil {
+		t.Fatalf("Handle(Tversion) error = %v", err)
+	}
+	if want := (p9p.MessageRversion{MSize: 8192, Version: p9p.DefaultVersion}); reply != want {
+		t.Errorf("Handle(Tversion) = %v, want %v", reply, want)
+	}
+	if len(s.fids) != 0 {
+		t.Errorf("version request didn't clunk the fids")
+	}
+}
+
+func TestServe9P_renameDirectory(t *testing.T) {
+	ctx := context.Background()
+	root, err := ioutil.TempDir(os.TempDir(), "")
+	if err != nil {
+		t.Fatalf("TempDir() error = %v", err)
+	}
+	defer os.RemoveAll(root)
+	root, _ = filepath.EvalSymlinks(root)
+	if err := os.MkdirAll(filepath.Join(root, "old", "sub"), 0755); err != nil {
+		t.Fatalf("MkdirAll() error = %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "old", "sub", "child.txt"), []byte("child"), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	// A directory whose name has the renamed directory as a prefix isn't below it
+	if err := ioutil.WriteFile(filepath.Join(root, "older"), []byte("older"), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	c, closeClient := newP9Client(t, root, 0)
+	defer closeClient()
+	if _, err := c.Attach(ctx, 0, p9p.NOFID, "docker", ""); err != nil {
+		t.Fatalf("Attach() error = %v", err)
+	}
+
+	c.Walk(ctx, 0, 1, "old")
+	c.Walk(ctx, 0, 2, "old", "sub", "child.txt")
+	c.Walk(ctx, 0, 3, "older")
+	dir := p9UnchangedDir()
+	dir.Name = "new"
+	if err := c.WStat(ctx, 1, dir); err != nil {
+		t.Fatalf("WStat() error = %v", err)
+	}
+
+	// A file created at the old path must not be confused with the renamed one
+	if err := os.MkdirAll(filepath.Join(root, "old", "sub"), 0755); err != nil {
+		t.Fatalf("MkdirAll() error = %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "old", "sub", "child.txt"), []byte("impostor"), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	c.Open(ctx, 2, p9p.OREAD)
+	if got := string(p9Read(t, c, 2, 0, 100)); got != "child" {
+		t.Errorf("Read() of the child of the renamed directory = %q, want %q", got, "child")
+	}
+	c.Open(ctx, 3, p9p.OREAD)
+	if got := string(p9Read(t, c, 3, 0, 100)); got != "older" {
+		t.Errorf("Read() of a file next to the renamed directory = %q, want %q", got, "older")
+	}
+}
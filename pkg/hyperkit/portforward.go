@@ -0,0 +1,236 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hyperkit
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/docker/machine/libmachine/log"
+	"github.com/pkg/errors"
+)
+
+const (
+	// vsockGuestCID is the context ID of the guest; hyperkit uses 3 unless configured otherwise
+	vsockGuestCID = 3
+	// vsockConnectSocket is the unix socket in the hyperkit state directory for connections to the guest
+	vsockConnectSocket = "connect"
+	// portForwardSuffixVSock marks port forwards that connect to a vsock port instead of a TCP port
+	portForwardSuffixVSock = "/vsock"
+)
+
+// PortForward forwards connections to a TCP port on localhost to a port in the guest
+type PortForward struct {
+	HostPort  int
+	GuestPort int
+	// VSock means that GuestPort is a vsock port instead of a TCP port
+	VSock bool
+}
+
+// ParsePortForward parses a port forward specification of the form HOSTPORT:GUESTPORT[/vsock]
+func ParsePortForward(spec string) (PortForward, error) {
+	var f PortForward
+	ports := spec
+	if strings.HasSuffix(ports, portForwardSuffixVSock) {
+		f.VSock = true
+		ports = strings.TrimSuffix(ports, portForwardSuffixVSock)
+	}
+	split := strings.Split(ports, ":")
+	if len(split) != 2 {
+		return f, fmt.Errorf("port forward %q must have the format HOSTPORT:GUESTPORT[%s]", spec, portForwardSuffixVSock)
+	}
+	var err error
+	if f.HostPort, err = parsePort(split[0]); err != nil {
+		return f, errors.Wrapf(err, "port forward %q", spec)
+	}
+	if f.GuestPort, err = parsePort(split[1]); err != nil {
+		return f, errors.Wrapf(err, "port forward %q", spec)
+	}
+	return f, nil
+}
+
+// ParsePortForwards parses a list of port forward specifications, which must use distinct host ports
+func ParsePortForwards(specs []string) ([]PortForward, error) {
+	var forwards []PortForward
+	hostPorts := map[int]bool{}
+	for _, spec := range specs {
+		f, err := ParsePortForward(spec)
+		if err != nil {
+			return nil, err
+		}
+		if hostPorts[f.HostPort] {
+			return nil, fmt.Errorf("host port %d is forwarded more than once", f.HostPort)
+		}
+		hostPorts[f.HostPort] = true
+		forwards = append(forwards, f)
+	}
+	return forwards, nil
+}
+
+// freeLocalPorts returns n distinct TCP ports on localhost that are not in use at the moment
+func freeLocalPorts(n int) ([]int, error) {
+	var ports []int
+	for i := 0; i < n; i++ {
+		// Keep listening until all ports are allocated, so that no port is returned twice
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return nil, err
+		}
+		defer l.Close()
+		ports = append(ports, l.Addr().(*net.TCPAddr).Port)
+	}
+	return ports, nil
+}
+
+// localPortsAvailable reports whether all ports can be listened on at the moment
+func localPortsAvailable(ports ...int) bool {
+	for _, port := range ports {
+		if port == 0 {
+			return false
+		}
+		l, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+		if err != nil {
+			return false
+		}
+		l.Close()
+	}
+	return true
+}
+
+func parsePort(port string) (int, error) {
+	p, err := strconv.Atoi(port)
+	if err != nil || p < 1 || p > 65535 {
+		return 0, fmt.Errorf("invalid port %q", port)
+	}
+	return p, nil
+}
+
+func (f PortForward) String() string {
+	spec := fmt.Sprintf("%d:%d", f.HostPort, f.GuestPort)
+	if f.VSock {
+		spec += portForwardSuffixVSock
+	}
+	return spec
+}
+
+// guestAddr describes the guest side of the port forward for messages
+func (f PortForward) guestAddr() string {
+	if f.VSock {
+		return fmt.Sprintf("vsock port %d", f.GuestPort)
+	}
+	return fmt.Sprintf("port %d", f.GuestPort)
+}
+
+// VSockConnectPath returns the path of the hyperkit socket for connections to guest vsock ports.
+// The socket only exists while a machine with vsock enabled is running.
+func VSockConnectPath(stateDir string) string {
+	return filepath.Join(stateDir, vsockConnectSocket)
+}
+
+// DialVSock connects to a vsock port of the guest through the connect socket of hyperkit in stateDir
+func DialVSock(stateDir string, port int) (net.Conn, error) {
+	conn, err := net.Dial("unix", VSockConnectPath(stateDir))
+	if err != nil {
+		return nil, errors.Wrap(err, "connecting to hyperkit vsock socket")
+	}
+	// hyperkit expects the address as "CID.PORT\n", both as 8 hex digits
+	if _, err := fmt.Fprintf(conn, "%08x.%08x\n", vsockGuestCID, port); err != nil {
+		conn.Close()
+		return nil, errors.Wrap(err, "requesting vsock connection")
+	}
+	return conn, nil
+}
+
+// ForwardPorts listens on localhost for all forwards and copies every accepted connection to a
+// connection to the guest created by dial. It returns when stop is closed, or when a listener fails.
+func ForwardPorts(forwards []PortForward, dial func(PortForward) (net.Conn, error), stop <-chan struct{}) error {
+	var listeners []net.Listener
+	defer func() {
+		for _, l := range listeners {
+			l.Close()
+		}
+	}()
+	for _, f := range forwards {
+		l, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(f.HostPort)))
+		if err != nil {
+			return errors.Wrapf(err, "forwarding %s", f)
+		}
+		log.Infof("Forwarding localhost:%d to guest %s", f.HostPort, f.guestAddr())
+		listeners = append(listeners, l)
+	}
+
+	errc := make(chan error, len(listeners))
+	for i, l := range listeners {
+		go func(l net.Listener, f PortForward) {
+			errc <- acceptForwards(l, f, dial)
+		}(l, forwards[i])
+	}
+	select {
+	case <-stop:
+		return nil
+	case err := <-errc:
+		return err
+	}
+}
+
+func acceptForwards(l net.Listener, f PortForward, dial func(PortForward) (net.Conn, error)) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				log.Debugf("Accepting connection on port %d: %v", f.HostPort, err)
+				continue
+			}
+			return errors.Wrapf(err, "accepting connections on port %d", f.HostPort)
+		}
+		go func() {
+			defer conn.Close()
+			guest, err := dial(f)
+			if err != nil {
+				log.Warnf("Cannot connect to guest %s: %v", f.guestAddr(), err)
+				return
+			}
+			defer guest.Close()
+			log.Debugf("Forwarding connection from %s to guest %s", conn.RemoteAddr(), f.guestAddr())
+			proxy(conn, guest)
+		}()
+	}
+}
+
+// proxy copies data in both directions until both sides are done
+func proxy(a, b net.Conn) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	halfCopy := func(dst, src net.Conn) {
+		defer wg.Done()
+		_, _ = io.Copy(dst, src)
+		// Propagate EOF to the other side if the connection supports half-close
+		if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+			_ = cw.CloseWrite()
+		} else {
+			_ = dst.Close()
+		}
+	}
+	go halfCopy(a, b)
+	go halfCopy(b, a)
+	wg.Wait()
+}
@@ -0,0 +1,204 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hyperkit
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestParsePortForward(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    PortForward
+		wantErr bool
+	}{
+		{spec: "8080:80", want: PortForward{HostPort: 8080, GuestPort: 80}},
+		{spec: "2375:2375/vsock", want: PortForward{HostPort: 2375, GuestPort: 2375, VSock: true}},
+		{spec: "8080", wantErr: true},
+		{spec: "8080:80:1", wantErr: true},
+		{spec: "0:80", wantErr: true},
+		{spec: "8080:65536", wantErr: true},
+		{spec: "8080:http", wantErr: true},
+		{spec: "8080:80/udp", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := ParsePortForward(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParsePortForward() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got != tt.want {
+				t.Errorf("ParsePortForward() = %+v, want %+v", got, tt.want)
+			}
+			if got.String() != tt.spec {
+				t.Errorf("String() = %q, want %q", got.String(), tt.spec)
+			}
+		})
+	}
+
+	if _, err := ParsePortForwards([]string{"8080:80", "8080:81/vsock"}); err == nil {
+		t.Error("ParsePortForwards() should fail for a duplicate host port")
+	}
+}
+
+func TestForwardPorts(t *testing.T) {
+	// The "guest" is an upper-casing echo server
+	guest, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer guest.Close()
+	go func() {
+		for {
+			conn, err := guest.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				line, _ := bufio.NewReader(conn).ReadString('\n')
+				_, _ = io.WriteString(conn, "echo "+line)
+			}()
+		}
+	}()
+
+	ports, err := freeLocalPorts(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostPort := ports[0]
+	forwards := []PortForward{{HostPort: hostPort, GuestPort: guest.Addr().(*net.TCPAddr).Port}}
+	dialed := make(chan PortForward, 1)
+	dial := func(f PortForward) (net.Conn, error) {
+		dialed <- f
+		return net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(f.GuestPort)))
+	}
+	stop := make(chan struct{})
+	done := make(chan error, 1)
+	go func() { done <- ForwardPorts(forwards, dial, stop) }()
+
+	var conn net.Conn
+	for i := 0; i < 100; i++ {
+		if conn, err = net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(hostPort))); err == nil {
+			break
+		}
+		select {
+		case err := <-done:
+			t.Fatalf("ForwardPorts() error = %v", err)
+		default:
+		}
+	}
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer conn.Close()
+	if _, err := io.WriteString(conn, "hello\n"); err != nil {
+		t.Fatal(err)
+	}
+	reply, err := ioutil.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(reply) != "echo hello\n" {
+		t.Errorf("reply = %q, want %q", reply, "echo hello\n")
+	}
+	if f := <-dialed; f != forwards[0] {
+		t.Errorf("dialed %+v, want %+v", f, forwards[0])
+	}
+
+	close(stop)
+	if err := <-done; err != nil {
+		t.Errorf("ForwardPorts() error = %v", err)
+	}
+	// The host port is released
+	l, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(hostPort)))
+	if err != nil {
+		t.Errorf("host port still in use: %v", err)
+	} else {
+		l.Close()
+	}
+}
+
+func Test_localPortsAvailable(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	used := l.Addr().(*net.TCPAddr).Port
+	ports, err := freeLocalPorts(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !localPortsAvailable(ports...) {
+		t.Errorf("localPortsAvailable(%v) = false, want true", ports)
+	}
+	if localPortsAvailable(ports[0], used) {
+		t.Errorf("localPortsAvailable(%d, %d) = true for port in use, want false", ports[0], used)
+	}
+	if localPortsAvailable(0, ports[1]) {
+		t.Errorf("localPortsAvailable(0, %d) = true for unallocated port, want false", ports[1])
+	}
+}
+
+func TestDialVSock(t *testing.T) {
+	stateDir, err := ioutil.TempDir("", "vsock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(stateDir)
+
+	if _, err := DialVSock(stateDir, 2375); err == nil {
+		t.Error("DialVSock() should fail without a connect socket")
+	}
+
+	l, err := net.Listen("unix", VSockConnectPath(stateDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	request := make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			request <- err.Error()
+			return
+		}
+		defer conn.Close()
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		request <- line
+	}()
+
+	conn, err := DialVSock(stateDir, 2375)
+	if err != nil {
+		t.Fatalf("DialVSock() error = %v", err)
+	}
+	defer conn.Close()
+	if got, want := <-request, "00000003.00000947\n"; got != want {
+		t.Errorf("connect request = %q, want %q", got, want)
+	}
+}
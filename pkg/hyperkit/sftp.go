@@ -0,0 +1,294 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hyperkit
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+// ServeSFTP serves the directory root over SFTP on rwc until the client disconnects. Paths are
+// confined to root: symbolic links that point outside of it can't be followed.
+func ServeSFTP(rwc io.ReadWriteCloser, root string) error {
+	root, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return err
+	}
+	h := &sftpHandler{root: root}
+	server := sftp.NewRequestServer(rwc, sftp.Handlers{FileGet: h, FilePut: h, FileCmd: h, FileList: h})
+	defer server.Close()
+	if err := server.Serve(); err != io.EOF {
+		return err
+	}
+	return nil
+}
+
+// sftpHandler implements the handlers of the SFTP request server on top of the host filesystem
+type sftpHandler struct {
+	root string
+}
+
+// local returns the host path of an SFTP path without resolving its last element, so that
+// operations on a symbolic link apply to the link itself
+func (h *sftpHandler) local(name string) (string, error) {
+	p := filepath.Join(h.root, filepath.FromSlash(path.Clean("/"+name)))
+	if p == h.root {
+		return p, nil
+	}
+	dir, err := filepath.EvalSymlinks(filepath.Dir(p))
+	if err != nil {
+		return "", err
+	}
+	if !withinRoot(h.root, dir) {
+		return "", syscall.EACCES
+	}
+	return filepath.Join(dir, filepath.Base(p)), nil
+}
+
+// resolve returns the host path of an SFTP path with all symbolic links resolved. A path that
+// doesn't exist is returned as is, so that it can be created.
+func (h *sftpHandler) resolve(name string) (string, error) {
+	p, err := h.local(name)
+	if err != nil {
+		return "", err
+	}
+	resolved, err := filepath.EvalSymlinks(p)
+	if os.IsNotExist(err) {
+		// Creating the file would follow a dangling symbolic link, which may point anywhere
+		if _, err := os.Lstat(p); err == nil {
+			return "", syscall.EACCES
+		}
+		return p, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if !withinRoot(h.root, resolved) {
+		return "", syscall.EACCES
+	}
+	return resolved, nil
+}
+
+// Fileread opens a file for reading
+func (h *sftpHandler) Fileread(r *sftp.Request) (io.ReaderAt, error) {
+	p, err := h.resolve(r.Filepath)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(p)
+}
+
+// Filewrite opens a file for writing
+func (h *sftpHandler) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	return h.openFile(r)
+}
+
+// OpenFile opens a file for reading and writing
+func (h *sftpHandler) OpenFile(r *sftp.Request) (sftp.WriterAtReaderAt, error) {
+	return h.openFile(r)
+}
+
+func (h *sftpHandler) openFile(r *sftp.Request) (*os.File, error) {
+	p, err := h.resolve(r.Filepath)
+	if err != nil {
+		return nil, err
+	}
+	pflags := r.Pflags()
+	flags := os.O_WRONLY
+	if pflags.Read {
+		flags = os.O_RDWR
+	}
+	if pflags.Creat {
+		flags |= os.O_CREATE
+	}
+	if pflags.Trunc {
+		flags |= os.O_TRUNC
+	}
+	if pflags.Excl {
+		flags |= os.O_EXCL
+	}
+	// O_APPEND isn't passed on, because os.File.WriteAt rejects it; the client sends the offsets
+	return os.OpenFile(p, flags, 0644)
+}
+
+// Filecmd runs the commands that modify the filesystem
+func (h *sftpHandler) Filecmd(r *sftp.Request) error {
+	switch r.Method {
+	case "Setstat":
+		return h.setstat(r)
+	case "Mkdir":
+		p, err := h.local(r.Filepath)
+		if err != nil {
+			return err
+		}
+		return os.Mkdir(p, 0755)
+	case "Rename":
+		// SFTP renames don't replace existing files; clients use PosixRename for that
+		return h.rename(r, false)
+	case "Remove", "Rmdir":
+		p, err := h.local(r.Filepath)
+		if err != nil {
+			return err
+		}
+		if p == h.root {
+			return syscall.EACCES
+		}
+		if r.Method == "Rmdir" {
+			return syscall.Rmdir(p)
+		}
+		return syscall.Unlink(p)
+	}
+	// Links aren't supported: the request server passes the target of a symbolic link as an
+	// absolute path, which would break relative links
+	return sftp.ErrSSHFxOpUnsupported
+}
+
+// PosixRename renames a file, replacing the target if it exists
+func (h *sftpHandler) PosixRename(r *sftp.Request) error {
+	return h.rename(r, true)
+}
+
+func (h *sftpHandler) rename(r *sftp.Request, replace bool) error {
+	from, err := h.local(r.Filepath)
+	if err != nil {
+		return err
+	}
+	to, err := h.local(r.Target)
+	if err != nil {
+		return err
+	}
+	if from == h.root || to == h.root {
+		return syscall.EACCES
+	}
+	if !replace {
+		if _, err := os.Lstat(to); err == nil {
+			return syscall.EEXIST
+		}
+	}
+	return os.Rename(from, to)
+}
+
+func (h *sftpHandler) setstat(r *sftp.Request) error {
+	p, err := h.resolve(r.Filepath)
+	if err != nil {
+		return err
+	}
+	flags := r.AttrFlags()
+	attrs := r.Attributes()
+	// The owner can't be changed: the files belong to the host user running the server
+	if flags.Size {
+		if err := os.Truncate(p, int64(attrs.Size)); err != nil {
+			return err
+		}
+	}
+	if flags.Permissions {
+		if err := os.Chmod(p, attrs.FileMode().Perm()); err != nil {
+			return err
+		}
+	}
+	if flags.Acmodtime {
+		if err := os.Chtimes(p, time.Unix(int64(attrs.Atime), 0), time.Unix(int64(attrs.Mtime), 0)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Filelist lists directories, stats files and reads symbolic links
+func (h *sftpHandler) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
+	switch r.Method {
+	case "List":
+		p, err := h.resolve(r.Filepath)
+		if err != nil {
+			return nil, err
+		}
+		files, err := ioutil.ReadDir(p)
+		if err != nil {
+			return nil, err
+		}
+		return sftpLister(files), nil
+	case "Stat":
+		p, err := h.resolve(r.Filepath)
+		if err != nil {
+			return nil, err
+		}
+		fi, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		return sftpLister{fi}, nil
+	case "Readlink":
+		p, err := h.local(r.Filepath)
+		if err != nil {
+			return nil, err
+		}
+		target, err := os.Readlink(p)
+		if err != nil {
+			return nil, err
+		}
+		fi, err := os.Lstat(p)
+		if err != nil {
+			return nil, err
+		}
+		return sftpLister{linkTarget{FileInfo: fi, target: target}}, nil
+	}
+	return nil, sftp.ErrSSHFxOpUnsupported
+}
+
+// Lstat stats a file without following symbolic links
+func (h *sftpHandler) Lstat(r *sftp.Request) (sftp.ListerAt, error) {
+	p, err := h.local(r.Filepath)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Lstat(p)
+	if err != nil {
+		return nil, err
+	}
+	return sftpLister{fi}, nil
+}
+
+// sftpLister returns the results of Filelist
+type sftpLister []os.FileInfo
+
+func (l sftpLister) ListAt(files []os.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(l)) {
+		return 0, io.EOF
+	}
+	n := copy(files, l[offset:])
+	if n < len(files) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// linkTarget is the result of a Readlink request, which returns the target as the file name
+type linkTarget struct {
+	os.FileInfo
+	target string
+}
+
+func (l linkTarget) Name() string {
+	return l.target
+}
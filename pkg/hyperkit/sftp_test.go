@@ -0,0 +1,170 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hyperkit
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/pkg/sftp"
+)
+
+// sftpConn is one end of the connection between the SFTP client and server
+type sftpConn struct {
+	io.Reader
+	io.WriteCloser
+}
+
+func newSFTPClient(t *testing.T, root string) *sftp.Client {
+	serverReader, clientWriter := io.Pipe()
+	clientReader, serverWriter := io.Pipe()
+	go func() {
+		_ = ServeSFTP(sftpConn{Reader: serverReader, WriteCloser: serverWriter}, root)
+	}()
+	client, err := sftp.NewClientPipe(clientReader, clientWriter)
+	if err != nil {
+		t.Fatalf("NewClientPipe() error = %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestServeSFTP(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "sftp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	root := filepath.Join(tmp, "root")
+	if err := os.MkdirAll(filepath.Join(root, "dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "dir", "file"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(tmp, "secret"), []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("dir/file", filepath.Join(root, "link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("../secret", filepath.Join(root, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("..", filepath.Join(root, "up")); err != nil {
+		t.Fatal(err)
+	}
+
+	client := newSFTPClient(t, root)
+
+	entries, err := client.ReadDir("/")
+	if err != nil {
+		t.Fatalf("ReadDir() error = %v", err)
+	}
+	var names []string
+	for _, fi := range entries {
+		names = append(names, fi.Name())
+	}
+	sort.Strings(names)
+	if want := []string{"dir", "escape", "link", "up"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("ReadDir() = %v, want %v", names, want)
+	}
+
+	readFile := func(name string) (string, error) {
+		f, err := client.Open(name)
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		data, err := ioutil.ReadAll(f)
+		return string(data), err
+	}
+	if got, err := readFile("/link"); err != nil || got != "hello" {
+		t.Errorf("reading link = %q, %v; want %q", got, err, "hello")
+	}
+	if target, err := client.ReadLink("/link"); err != nil || target != "dir/file" {
+		t.Errorf("ReadLink() = %q, %v; want %q", target, err, "dir/file")
+	}
+	if fi, err := client.Lstat("/link"); err != nil || fi.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("Lstat() = %v, %v; want a symbolic link", fi, err)
+	}
+
+	// Paths outside of the root can't be reached
+	for _, name := range []string{"/escape", "/up/secret", "/../secret", "/dir/../../secret"} {
+		if got, err := readFile(name); err == nil {
+			t.Errorf("reading %s = %q, want an error", name, got)
+		}
+	}
+	if _, err := client.Create("/up/created"); err == nil {
+		t.Errorf("creating a file through a link to the parent succeeded")
+	}
+	if _, err := os.Stat(filepath.Join(tmp, "created")); !os.IsNotExist(err) {
+		t.Errorf("file created outside of the root")
+	}
+
+	// Writing, renaming and removing files
+	f, err := client.Create("/dir/new")
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if _, err := f.Write([]byte("new content")); err != nil {
+		t.Errorf("Write() error = %v", err)
+	}
+	f.Close()
+	if err := client.Truncate("/dir/new", 3); err != nil {
+		t.Errorf("Truncate() error = %v", err)
+	}
+	if err := client.Chmod("/dir/new", 0600); err != nil {
+		t.Errorf("Chmod() error = %v", err)
+	}
+	fi, err := os.Stat(filepath.Join(root, "dir", "new"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() != 3 || fi.Mode().Perm() != 0600 {
+		t.Errorf("file size %d, mode %v; want 3, -rw-------", fi.Size(), fi.Mode())
+	}
+	if err := client.Rename("/dir/new", "/dir/file"); err == nil {
+		t.Errorf("Rename() replaced an existing file")
+	}
+	if err := client.PosixRename("/dir/new", "/dir/file"); err != nil {
+		t.Errorf("PosixRename() error = %v", err)
+	}
+	if got, err := readFile("/dir/file"); err != nil || got != "new" {
+		t.Errorf("reading renamed file = %q, %v; want %q", got, err, "new")
+	}
+	if err := client.Mkdir("/sub"); err != nil {
+		t.Errorf("Mkdir() error = %v", err)
+	}
+	if err := client.Remove("/sub"); err != nil {
+		t.Errorf("Remove() error = %v", err)
+	}
+	if err := client.Remove("/dir/file"); err != nil {
+		t.Errorf("Remove() error = %v", err)
+	}
+	if err := client.RemoveDirectory("/"); err == nil {
+		t.Errorf("RemoveDirectory() removed the root")
+	}
+	if _, err := os.Stat(filepath.Join(root, "dir", "file")); !os.IsNotExist(err) {
+		t.Errorf("removed file still exists")
+	}
+}
@@ -0,0 +1,244 @@
+// +build darwin
+
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hyperkit
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/docker/machine/libmachine/log"
+	hyperkit "github.com/moby/hyperkit/go"
+)
+
+const (
+	// ShareNFS shares directories over NFS, which requires root to edit /etc/exports and restart nfsd
+	ShareNFS = "nfs"
+	// Share9P shares directories over hyperkit's virtio-9p devices, served by the driver as the current user
+	Share9P = "9p"
+	// ShareSSHFS shares directories with sshfs in the guest, which gets the files from an SFTP server
+	// run by the driver as the current user over SSH
+	ShareSSHFS = "sshfs"
+
+	ninePPidFileName = "9p.pid"
+	ninePLogFileName = "9p.log"
+	sshfsPidFileName = "sshfs.pid"
+	sshfsLogFileName = "sshfs.log"
+	// ninePMsize is the maximum message size the guest requests; it is the size the server offers
+	ninePMsize = p9MaxMsize
+	// unixPathMax is the maximum length of a unix socket path on macOS
+	unixPathMax = 103
+)
+
+// ValidateShareType returns an error unless shareType is one of the supported share types
+func ValidateShareType(shareType string) error {
+	switch shareType {
+	case ShareNFS, Share9P, ShareSSHFS:
+		return nil
+	}
+	return fmt.Errorf("invalid share type %q; must be %s, %s or %s", shareType, ShareNFS, Share9P, ShareSSHFS)
+}
+
+// Share is a host directory mounted in the guest
+type Share struct {
+	// Spec is the "local[:guest[:options]]" specification of the share from NFSShares
+	Spec string
+	// LocalPath is the absolute host path, with symbolic links resolved
+	LocalPath string
+	// MountPoint is the guest path
+	MountPoint string
+	// Options are the comma-separated NFS options of the share
+	Options string
+}
+
+// shareType returns the share type; machines created before share types existed use NFS
+func (d *Driver) shareType() string {
+	if d.ShareType == "" {
+		return ShareNFS
+	}
+	return d.ShareType
+}
+
+// splitShareSpec splits a "local[:guest[:options]]" share specification into its parts
+func splitShareSpec(spec string) (local, guest, options string, err error) {
+	parts := strings.Split(spec, ":")
+	if len(parts) > 3 {
+		return "", "", "", fmt.Errorf("invalid share %q; must be local[:guest[:options]]", spec)
+	}
+	parts = append(parts, "", "")
+	return parts[0], parts[1], parts[2], nil
+}
+
+// validateShares checks the syntax of the shares and their options, which only NFS supports
+func (d *Driver) validateShares() error {
+	for _, spec := range d.NFSShares {
+		_, _, options, err := splitShareSpec(spec)
+		if err != nil {
+			return err
+		}
+		if options == "" {
+			continue
+		}
+		if d.shareType() != ShareNFS {
+			return fmt.Errorf("share options in %q are only supported with share type %s", spec, ShareNFS)
+		}
+		if _, _, err := ParseNFSOptions(options); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// shares returns the shares of NFSShares. Relative local paths are relative to the machine
+// directory; the mount point defaults to the local path below NFSSharesRoot.
+func (d *Driver) shares() ([]Share, error) {
+	var shares []Share
+	for _, spec := range d.NFSShares {
+		sharePath, mountPoint, options, err := splitShareSpec(spec)
+		if err != nil {
+			return nil, err
+		}
+		localPath := sharePath
+		if !path.IsAbs(localPath) {
+			localPath = d.ResolveStorePath(localPath)
+		}
+		localPath, err = filepath.EvalSymlinks(localPath)
+		if err != nil {
+			log.Errorf("cannot evaluate symlinks in share path '%s': %v", sharePath, err)
+			return nil, err
+		}
+		share := Share{Spec: spec, LocalPath: localPath, MountPoint: mountPoint, Options: options}
+		if mountPoint == "" {
+			share.MountPoint = filepath.Join(d.NFSSharesRoot, localPath)
+		}
+		// TODO(jandubois) Should we validate that the mountpoint is an absolute path?
+		shares = append(shares, share)
+	}
+	return shares, nil
+}
+
+// ninePSocket returns the path of the socket serving the 9p share at index
+func (d *Driver) ninePSocket(index int) string {
+	return d.ResolveStorePath(fmt.Sprintf("9p%d.sock", index))
+}
+
+// ninePTag returns the tag the guest uses to mount the 9p share at index
+func ninePTag(index int) string {
+	return fmt.Sprintf("share%d", index)
+}
+
+// ninePSockets returns the virtio-9p devices of the shares
+func (d *Driver) ninePSockets() []hyperkit.Socket9P {
+	var sockets []hyperkit.Socket9P
+	for i := range d.NFSShares {
+		sockets = append(sockets, hyperkit.Socket9P{Path: d.ninePSocket(i), Tag: ninePTag(i)})
+	}
+	return sockets
+}
+
+// start9PServer starts a background process serving the shares on the sockets of the virtio-9p
+// devices. It must be running before hyperkit starts, because hyperkit doesn't reconnect.
+func (d *Driver) start9PServer() error {
+	shares, err := d.shares()
+	if err != nil {
+		return err
+	}
+	args := []string{"9p-server"}
+	for i, share := range shares {
+		socket := d.ninePSocket(i)
+		if len(socket) > unixPathMax {
+			return fmt.Errorf("9p socket path %s is longer than %d characters; use a shorter storage path", socket, unixPathMax)
+		}
+		args = append(args, socket, share.LocalPath)
+	}
+	return startDaemon(d.ResolveStorePath(ninePPidFileName), d.ResolveStorePath(ninePLogFileName), args...)
+}
+
+// stop9PServer stops the 9p server process, if it is running
+func (d *Driver) stop9PServer() {
+	if err := stopDaemon(d.ResolveStorePath(ninePPidFileName)); err != nil {
+		log.Warnf("Stopping the 9p server failed: %v", err)
+	}
+}
+
+// setup9PShare mounts the virtio-9p shares in the guest. The files are owned by the SSH user,
+// because the server accesses them as the host user.
+func (d *Driver) setup9PShare() error {
+	shares, err := d.shares()
+	if err != nil {
+		return err
+	}
+	uid, gid, err := d.guestIDs()
+	if err != nil {
+		return err
+	}
+	var mounts []guestMount
+	for i, share := range shares {
+		mounts = append(mounts, guestMount{
+			Source:     ninePTag(i),
+			MountPoint: share.MountPoint,
+			FSType:     "9p",
+			Options:    fmt.Sprintf("trans=virtio,version=9p2000,msize=%d,dfltuid=%d,dfltgid=%d", ninePMsize, uid, gid),
+		})
+	}
+	return d.mountShares("", mounts)
+}
+
+// startSSHFS starts a background process that mounts the shares in the guest with sshfs and serves
+// their files over SSH. Like startPortForwards, it passes the IP address and SSH port explicitly.
+func (d *Driver) startSSHFS() error {
+	shares, err := d.shares()
+	if err != nil {
+		return err
+	}
+	sshPort, err := d.GetSSHPort()
+	if err != nil {
+		return err
+	}
+	args := []string{"sshfs-server", "--storage-path", d.StorePath, "--machine-name", d.MachineName,
+		"--guest-ip", d.IPAddress, "--ssh-port", strconv.Itoa(sshPort)}
+	for _, share := range shares {
+		args = append(args, share.LocalPath, share.MountPoint)
+	}
+	return startDaemon(d.ResolveStorePath(sshfsPidFileName), d.ResolveStorePath(sshfsLogFileName), args...)
+}
+
+// stopSSHFS stops the sshfs process, which unmounts the shares in the guest, if it is running
+func (d *Driver) stopSSHFS() {
+	if err := stopDaemon(d.ResolveStorePath(sshfsPidFileName)); err != nil {
+		log.Warnf("Stopping the sshfs server failed: %v", err)
+	}
+}
+
+// SSHFSMountCommand returns the guest command that mounts mountPoint with sshfs, which talks SFTP
+// over its standard input and output instead of connecting to a server. Nothing else may write to
+// the standard output. boot2docker doesn't include sshfs, so it is installed on demand; sshfs 3
+// renamed the slave option to passive.
+func SSHFSMountCommand(mountPoint string) string {
+	return fmt.Sprintf("command -v sshfs >/dev/null || tce-load -wi sshfs-fuse >&2; "+
+		"sudo mkdir -p %[1]s && opt=slave && { sshfs -h 2>&1 | grep -q passive && opt=passive; }; "+
+		"exec sudo sshfs -f -o $opt,allow_other,uid=$(id -u),gid=$(id -g) :/ %[1]s", mountPoint)
+}
+
+// SSHFSUnmountCommand returns the guest command that unmounts an sshfs share
+func SSHFSUnmountCommand(mountPoint string) string {
+	return fmt.Sprintf("sudo umount %s", mountPoint)
+}
@@ -0,0 +1,113 @@
+// +build darwin
+
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hyperkit
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/docker/machine/libmachine/drivers"
+)
+
+func TestValidateShareType(t *testing.T) {
+	for _, shareType := range []string{ShareNFS, Share9P, ShareSSHFS} {
+		if err := ValidateShareType(shareType); err != nil {
+			t.Errorf("ValidateShareType(%q) error = %v", shareType, err)
+		}
+	}
+	for _, shareType := range []string{"", "smb", "NFS"} {
+		if err := ValidateShareType(shareType); err == nil {
+			t.Errorf("ValidateShareType(%q) should fail", shareType)
+		}
+	}
+}
+
+func Test_shares(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "")
+	if err != nil {
+		t.Fatalf("TempDir() error = %v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+	tmpdir, _ = filepath.EvalSymlinks(tmpdir)
+
+	machineDir := filepath.Join(tmpdir, "machines", "test")
+	for _, dir := range []string{filepath.Join(tmpdir, "src"), filepath.Join(machineDir, "data")} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("MkdirAll() error = %v", err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(tmpdir, "src"), filepath.Join(tmpdir, "link")); err != nil {
+		t.Fatalf("Symlink() error = %v", err)
+	}
+
+	d := NewDriver("test", tmpdir)
+	d.BaseDriver = &drivers.BaseDriver{MachineName: "test", StorePath: tmpdir}
+	d.NFSSharesRoot = "/nfsshares"
+	d.NFSShares = []string{filepath.Join(tmpdir, "link"), "data:/data", "data::ro,sync"}
+
+	got, err := d.shares()
+	if err != nil {
+		t.Fatalf("shares() error = %v", err)
+	}
+	want := []Share{
+		{Spec: d.NFSShares[0], LocalPath: filepath.Join(tmpdir, "src"), MountPoint: filepath.Join("/nfsshares", tmpdir, "src")},
+		{Spec: "data:/data", LocalPath: filepath.Join(machineDir, "data"), MountPoint: "/data"},
+		{Spec: "data::ro,sync", LocalPath: filepath.Join(machineDir, "data"), MountPoint: filepath.Join("/nfsshares", machineDir, "data"), Options: "ro,sync"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("shares() = %+v, want %+v", got, want)
+	}
+
+	sockets := d.ninePSockets()
+	if len(sockets) != 3 || sockets[1].Tag != "share1" || sockets[1].Path != filepath.Join(machineDir, "9p1.sock") {
+		t.Errorf("ninePSockets() = %+v", sockets)
+	}
+
+	d.NFSShares = []string{filepath.Join(tmpdir, "missing")}
+	if _, err := d.shares(); err == nil {
+		t.Errorf("shares() should fail for a missing directory")
+	}
+}
+
+func Test_validateShares(t *testing.T) {
+	tests := []struct {
+		shareType string
+		shares    []string
+		wantErr   bool
+	}{
+		{shareType: ShareNFS, shares: []string{"/src", "/src:/dst", "/src:/dst:ro,maproot=0,sync", "/src::vers=4"}},
+		{shareType: "", shares: []string{"/src:/dst:ro"}},
+		{shareType: ShareNFS, shares: []string{"/src:/dst:ro:extra"}, wantErr: true},
+		{shareType: ShareNFS, shares: []string{"/src:/dst:alldirs"}, wantErr: true},
+		{shareType: Share9P, shares: []string{"/src:/dst"}},
+		{shareType: Share9P, shares: []string{"/src:/dst:ro"}, wantErr: true},
+		{shareType: ShareSSHFS, shares: []string{"/src::ro"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		d := NewDriver("test", "")
+		d.ShareType = tt.shareType
+		d.NFSShares = tt.shares
+		if err := d.validateShares(); (err != nil) != tt.wantErr {
+			t.Errorf("validateShares() with %q %v error = %v, wantErr %v", tt.shareType, tt.shares, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+// +build darwin
+
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hyperkit
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/docker/machine/libmachine/log"
+	"github.com/docker/machine/libmachine/state"
+	"github.com/pkg/errors"
+	pkgdrivers "github.com/rancher-sandbox/docker-machine-driver-hyperkit/pkg/drivers"
+)
+
+const (
+	snapshotsDirName = "snapshots"
+	configFileName   = "config.json"
+)
+
+var snapshotNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// Snapshot describes a saved copy of the machine disk and configuration
+type Snapshot struct {
+	Name    string
+	Created time.Time
+}
+
+func (d *Driver) snapshotDir(name string) (string, error) {
+	if !snapshotNameRegexp.MatchString(name) {
+		return "", fmt.Errorf("invalid snapshot name %q", name)
+	}
+	return d.ResolveStorePath(filepath.Join(snapshotsDirName, name)), nil
+}
+
+// snapshotFiles returns the names of all files in the machine directory that are part of a snapshot
+func (d *Driver) snapshotFiles() []string {
+	files := []string{
+		filepath.Base(pkgdrivers.GetDiskPath(d.BaseDriver, d.DiskFormat)),
+		configFileName,
+		machineFileName,
+	}
+	for _, disk := range d.ExtraDisks {
+		files = append(files, filepath.Base(d.dataDiskPath(disk)))
+	}
+	return files
+}
+
+// copySnapshotFiles clones all snapshot files from srcDir to dstDir, replacing existing files.
+// Files that don't exist in srcDir are skipped (e.g. hyperkit.json if the machine never ran).
+func (d *Driver) copySnapshotFiles(srcDir, dstDir string) error {
+	for _, name := range d.snapshotFiles() {
+		src := filepath.Join(srcDir, name)
+		dst := filepath.Join(dstDir, name)
+		if _, err := os.Stat(src); os.IsNotExist(err) {
+			log.Debugf("Skipping %s because it doesn't exist", src)
+			continue
+		}
+		if err := os.Remove(dst); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err := pkgdrivers.CloneFile(src, dst); err != nil {
+			return errors.Wrapf(err, "copying %s to %s", src, dst)
+		}
+	}
+	return nil
+}
+
+// CreateSnapshot saves the machine disk and configuration under the given name
+func (d *Driver) CreateSnapshot(name string) error {
+	dir, err := d.snapshotDir(name)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(dir); err == nil {
+		return fmt.Errorf("snapshot %q already exists", name)
+	}
+
+	st, err := d.GetState()
+	if err != nil {
+		return errors.Wrap(err, "get state")
+	}
+	if st == state.Running {
+		log.Warn("The machine is running; the snapshot will only be crash-consistent")
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	if err := d.copySnapshotFiles(d.ResolveStorePath("."), dir); err != nil {
+		_ = os.RemoveAll(dir)
+		return err
+	}
+	return nil
+}
+
+// ListSnapshots returns all snapshots of the machine, oldest first
+func (d *Driver) ListSnapshots() ([]Snapshot, error) {
+	files, err := ioutil.ReadDir(d.ResolveStorePath(snapshotsDirName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var snapshots []Snapshot
+	for _, f := range files {
+		if f.IsDir() {
+			snapshots = append(snapshots, Snapshot{Name: f.Name(), Created: f.ModTime()})
+		}
+	}
+	// ReadDir sorts by name
+	sort.SliceStable(snapshots, func(i, j int) bool {
+		return snapshots[i].Created.Before(snapshots[j].Created)
+	})
+	return snapshots, nil
+}
+
+// RestoreSnapshot replaces the machine disk and configuration with the saved copies.
+// The machine must not be running.
+func (d *Driver) RestoreSnapshot(name string) error {
+	dir, err := d.snapshotDir(name)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(dir); err != nil {
+		return fmt.Errorf("snapshot %q does not exist", name)
+	}
+
+	st, err := d.GetState()
+	if err != nil {
+		return errors.Wrap(err, "get state")
+	}
+	if st == state.Running {
+		return fmt.Errorf("cannot restore snapshot %q while the machine is running", name)
+	}
+
+	return d.copySnapshotFiles(dir, d.ResolveStorePath("."))
+}
+
+// DeleteSnapshot removes the snapshot with the given name
+func (d *Driver) DeleteSnapshot(name string) error {
+	dir, err := d.snapshotDir(name)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(dir); err != nil {
+		return fmt.Errorf("snapshot %q does not exist", name)
+	}
+	return os.RemoveAll(dir)
+}
@@ -0,0 +1,192 @@
+// +build darwin
+
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hyperkit
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/docker/machine/libmachine/drivers"
+)
+
+// newSnapshotTestDriver returns a driver for a stopped machine with a data disk,
+// whose snapshot files all contain content
+func newSnapshotTestDriver(t *testing.T, content string) *Driver {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "")
+	if err != nil {
+		t.Fatalf("TempDir() error = %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(tmpdir) })
+
+	d := NewDriver("", "")
+	d.BaseDriver = &drivers.BaseDriver{
+		MachineName: "test",
+		StorePath:   tmpdir,
+	}
+	d.ExtraDisks = []DataDisk{{Name: "docker", Size: 1}}
+	if err := os.MkdirAll(d.ResolveStorePath("."), 0700); err != nil {
+		t.Fatal(err)
+	}
+	writeSnapshotFiles(t, d, content)
+	setHyperkitPid(t, d, 0)
+	return d
+}
+
+func writeSnapshotFiles(t *testing.T, d *Driver, content string) {
+	for _, name := range d.snapshotFiles() {
+		if name == machineFileName {
+			continue
+		}
+		if err := ioutil.WriteFile(d.ResolveStorePath(name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func checkSnapshotFiles(t *testing.T, d *Driver, content string) {
+	for _, name := range d.snapshotFiles() {
+		if name == machineFileName {
+			continue
+		}
+		got, err := ioutil.ReadFile(d.ResolveStorePath(name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != content {
+			t.Errorf("%s contains %q, want %q", name, got, content)
+		}
+	}
+}
+
+func setHyperkitPid(t *testing.T, d *Driver, pid int) {
+	config := fmt.Sprintf(`{"pid": %d}`, pid)
+	if err := ioutil.WriteFile(d.ResolveStorePath(machineFileName), []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func snapshotNames(t *testing.T, d *Driver) []string {
+	snapshots, err := d.ListSnapshots()
+	if err != nil {
+		t.Fatalf("ListSnapshots() error = %v", err)
+	}
+	var names []string
+	for _, s := range snapshots {
+		names = append(names, s.Name)
+	}
+	return names
+}
+
+func TestSnapshots(t *testing.T) {
+	d := newSnapshotTestDriver(t, "first")
+
+	if names := snapshotNames(t, d); len(names) != 0 {
+		t.Errorf("ListSnapshots() = %v, want no snapshots", names)
+	}
+
+	// Create "b" before "a", to check that snapshots are listed by age and not by name
+	if err := d.CreateSnapshot("b"); err != nil {
+		t.Fatalf("CreateSnapshot() error = %v", err)
+	}
+	created := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(d.ResolveStorePath(filepath.Join(snapshotsDirName, "b")), created, created); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(d.ResolveStorePath(filepath.Join(snapshotsDirName, "b", "test-docker.rawdisk"))); err != nil {
+		t.Errorf("data disk was not copied into the snapshot: %v", err)
+	}
+
+	writeSnapshotFiles(t, d, "second")
+	if err := d.CreateSnapshot("a"); err != nil {
+		t.Fatalf("CreateSnapshot() error = %v", err)
+	}
+	if err := d.CreateSnapshot("a"); err == nil {
+		t.Errorf("CreateSnapshot() of an existing snapshot succeeded, want an error")
+	}
+	if names := snapshotNames(t, d); fmt.Sprint(names) != "[b a]" {
+		t.Errorf("ListSnapshots() = %v, want [b a]", names)
+	}
+
+	writeSnapshotFiles(t, d, "third")
+	if err := d.RestoreSnapshot("b"); err != nil {
+		t.Fatalf("RestoreSnapshot() error = %v", err)
+	}
+	checkSnapshotFiles(t, d, "first")
+	if err := d.RestoreSnapshot("a"); err != nil {
+		t.Fatalf("RestoreSnapshot() error = %v", err)
+	}
+	checkSnapshotFiles(t, d, "second")
+
+	if err := d.DeleteSnapshot("b"); err != nil {
+		t.Fatalf("DeleteSnapshot() error = %v", err)
+	}
+	if names := snapshotNames(t, d); fmt.Sprint(names) != "[a]" {
+		t.Errorf("ListSnapshots() = %v, want [a]", names)
+	}
+	if err := d.DeleteSnapshot("b"); err == nil {
+		t.Errorf("DeleteSnapshot() of a deleted snapshot succeeded, want an error")
+	}
+	if err := d.RestoreSnapshot("b"); err == nil {
+		t.Errorf("RestoreSnapshot() of a deleted snapshot succeeded, want an error")
+	}
+}
+
+func TestRestoreSnapshot_running(t *testing.T) {
+	d := newSnapshotTestDriver(t, "first")
+	if err := d.CreateSnapshot("snap"); err != nil {
+		t.Fatalf("CreateSnapshot() error = %v", err)
+	}
+
+	// The test binary is named hyperkit.test, so it passes for the hyperkit process
+	setHyperkitPid(t, d, os.Getpid())
+	writeSnapshotFiles(t, d, "second")
+	if err := d.RestoreSnapshot("snap"); err == nil {
+		t.Errorf("RestoreSnapshot() of a running machine succeeded, want an error")
+	}
+	checkSnapshotFiles(t, d, "second")
+}
+
+func TestSnapshotNames(t *testing.T) {
+	d := newSnapshotTestDriver(t, "first")
+	for _, name := range []string{"", ".", "..", "../test", "a/b", ".hidden", "-a", "a b"} {
+		t.Run(name, func(t *testing.T) {
+			if err := d.CreateSnapshot(name); err == nil {
+				t.Errorf("CreateSnapshot(%q) succeeded, want an error", name)
+			}
+			if err := d.RestoreSnapshot(name); err == nil {
+				t.Errorf("RestoreSnapshot(%q) succeeded, want an error", name)
+			}
+			if err := d.DeleteSnapshot(name); err == nil {
+				t.Errorf("DeleteSnapshot(%q) succeeded, want an error", name)
+			}
+		})
+	}
+	if names := snapshotNames(t, d); len(names) != 0 {
+		t.Errorf("ListSnapshots() = %v, want no snapshots", names)
+	}
+	for _, name := range []string{"a", "v1.2_test-3"} {
+		if err := d.CreateSnapshot(name); err != nil {
+			t.Errorf("CreateSnapshot(%q) error = %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hyperkit
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"net"
+
+	"github.com/pkg/errors"
+	"howett.net/plist"
+)
+
+const (
+	// SharedNetAddrKey is the key for the network address
+	SharedNetAddrKey = "Shared_Net_Address"
+	// SharedNetMaskKey is the key for the subnet mask
+	SharedNetMaskKey = "Shared_Net_Mask"
+	// SharedNetRangeStartKey is the key for the first address handed out by DHCP
+	SharedNetRangeStartKey = "Shared_Net_Range_Start"
+	// SharedNetRangeEndKey is the key for the last address handed out by DHCP
+	SharedNetRangeEndKey = "Shared_Net_Range_End"
+)
+
+// VMNetConfig is the configuration of the vmnet shared network
+type VMNetConfig struct {
+	// Address is the address of the host on the shared network, which is also the gateway
+	Address net.IP
+	// Mask is the subnet mask of the shared network
+	Mask net.IPMask
+	// RangeStart and RangeEnd are the first and last address handed out by DHCP
+	RangeStart net.IP
+	RangeEnd   net.IP
+}
+
+// Subnet returns the shared network
+func (c *VMNetConfig) Subnet() *net.IPNet {
+	return &net.IPNet{IP: c.Address.Mask(c.Mask), Mask: c.Mask}
+}
+
+// ReadVMNetConfig reads the vmnet configuration from the plist at path
+func ReadVMNetConfig(path string) (*VMNetConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config, err := parseVMNetConfig(data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing %s", path)
+	}
+	return config, nil
+}
+
+// parseVMNetConfig parses the vmnet plist. Only the address is required: the mask defaults
+// to 255.255.255.0, which vmnet uses when the mask hasn't been configured explicitly, and the
+// DHCP range defaults to all host addresses of the subnet after the address.
+func parseVMNetConfig(data []byte) (*VMNetConfig, error) {
+	// The plist is a dictionary in the XML or binary format
+	var dict map[string]interface{}
+	if _, err := plist.Unmarshal(data, &dict); err != nil {
+		return nil, err
+	}
+
+	var err error
+	config := &VMNetConfig{Mask: net.CIDRMask(24, 32)}
+	if config.Address, err = plistIPv4(dict, SharedNetAddrKey); err != nil {
+		return nil, err
+	}
+	if config.Address == nil {
+		return nil, fmt.Errorf("%s is not set", SharedNetAddrKey)
+	}
+	mask, err := plistIPv4(dict, SharedNetMaskKey)
+	if err != nil {
+		return nil, err
+	}
+	if mask != nil {
+		config.Mask = net.IPMask(mask)
+		if ones, bits := config.Mask.Size(); bits == 0 || ones > 30 {
+			return nil, fmt.Errorf("invalid %s %s", SharedNetMaskKey, mask)
+		}
+	}
+	subnet := config.Subnet()
+
+	if config.RangeStart, err = plistIPv4(dict, SharedNetRangeStartKey); err != nil {
+		return nil, err
+	}
+	if config.RangeStart == nil {
+		config.RangeStart = addIPv4(config.Address, 1)
+	}
+	if config.RangeEnd, err = plistIPv4(dict, SharedNetRangeEndKey); err != nil {
+		return nil, err
+	}
+	if config.RangeEnd == nil {
+		// The address before the broadcast address
+		config.RangeEnd = addIPv4(subnet.IP, ^binary.BigEndian.Uint32(config.Mask)-1)
+	}
+	if !subnet.Contains(config.RangeStart) || !subnet.Contains(config.RangeEnd) ||
+		binary.BigEndian.Uint32(config.RangeStart) > binary.BigEndian.Uint32(config.RangeEnd) {
+		return nil, fmt.Errorf("invalid DHCP range %s-%s for subnet %s", config.RangeStart, config.RangeEnd, subnet)
+	}
+	return config, nil
+}
+
+// plistIPv4 returns the IPv4 address stored as a string under key, or nil if the key is not set
+func plistIPv4(dict map[string]interface{}, key string) (net.IP, error) {
+	value, ok := dict[key]
+	if !ok {
+		return nil, nil
+	}
+	s, ok := value.(string)
+	if !ok {
+		return nil, fmt.Errorf("%s is a %T, not a string", key, value)
+	}
+	ip := net.ParseIP(s).To4()
+	if ip == nil {
+		return nil, fmt.Errorf("%s is not an IPv4 address: %q", key, s)
+	}
+	return ip, nil
+}
+
+// addIPv4 returns the IPv4 address n addresses after ip
+func addIPv4(ip net.IP, n uint32) net.IP {
+	sum := make(net.IP, net.IPv4len)
+	binary.BigEndian.PutUint32(sum, binary.BigEndian.Uint32(ip.To4())+n)
+	return sum
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2021 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hyperkit
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadVMNetConfig(t *testing.T) {
+	want := &VMNetConfig{
+		Address:    net.ParseIP("192.168.105.1").To4(),
+		Mask:       net.CIDRMask(24, 32),
+		RangeStart: net.ParseIP("192.168.105.2").To4(),
+		RangeEnd:   net.ParseIP("192.168.105.254").To4(),
+	}
+	for _, fixture := range []string{"vmnet_test.plist", "vmnet_test_binary.plist"} {
+		got, err := ReadVMNetConfig(fixture)
+		if err != nil {
+			t.Fatalf("ReadVMNetConfig(%s) error = %v", fixture, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("ReadVMNetConfig(%s) = %+v, want %+v", fixture, got, want)
+		}
+	}
+
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "")
+	if err != nil {
+		t.Fatalf("TempDir() error = %v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+	if _, err := ReadVMNetConfig(filepath.Join(tmpdir, "missing.plist")); !os.IsNotExist(err) {
+		t.Errorf("ReadVMNetConfig() error = %v, want a not exist error", err)
+	}
+}
+
+func Test_parseVMNetConfig(t *testing.T) {
+	plist := func(entries string) []byte {
+		return []byte(`<?xml version="1.0" encoding="UTF-8"?><plist version="1.0"><dict>` + entries + `</dict></plist>`)
+	}
+	entry := func(key, value string) string {
+		return "<key>" + key + "</key><string>" + value + "</string>"
+	}
+	tests := []struct {
+		name    string
+		data    []byte
+		want    *VMNetConfig
+		wantErr bool
+	}{
+		{
+			"defaults",
+			plist(entry(SharedNetAddrKey, "192.168.64.1")),
+			&VMNetConfig{
+				Address:    net.ParseIP("192.168.64.1").To4(),
+				Mask:       net.CIDRMask(24, 32),
+				RangeStart: net.ParseIP("192.168.64.2").To4(),
+				RangeEnd:   net.ParseIP("192.168.64.254").To4(),
+			},
+			false,
+		},
+		{
+			"custom mask",
+			plist(entry(SharedNetAddrKey, "10.0.0.1") + entry(SharedNetMaskKey, "255.255.0.0")),
+			&VMNetConfig{
+				Address:    net.ParseIP("10.0.0.1").To4(),
+				Mask:       net.CIDRMask(16, 32),
+				RangeStart: net.ParseIP("10.0.0.2").To4(),
+				RangeEnd:   net.ParseIP("10.0.255.254").To4(),
+			},
+			false,
+		},
+		{"missing address", plist(entry(SharedNetMaskKey, "255.255.255.0")), nil, true},
+		{"invalid address", plist(entry(SharedNetAddrKey, "fd00::1")), nil, true},
+		{"address is not a string", plist("<key>" + SharedNetAddrKey + "</key><integer>1</integer>"), nil, true},
+		{"invalid mask", plist(entry(SharedNetAddrKey, "192.168.64.1") + entry(SharedNetMaskKey, "255.0.255.0")), nil, true},
+		{"range outside subnet", plist(entry(SharedNetAddrKey, "192.168.64.1") + entry(SharedNetRangeEndKey, "192.168.65.10")), nil, true},
+		{"reversed range", plist(entry(SharedNetAddrKey, "192.168.64.1") + entry(SharedNetRangeStartKey, "192.168.64.100") + entry(SharedNetRangeEndKey, "192.168.64.10")), nil, true},
+		{"not a dictionary", []byte("<plist><array/></plist>"), nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseVMNetConfig(tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseVMNetConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseVMNetConfig() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}